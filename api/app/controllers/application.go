@@ -19,9 +19,10 @@ package controllers
 import (
 	"errors"
 	"fmt"
-	"github.com/robfig/revel"
+	"net"
 	m "smart-kids/models"
 	q "smart-kids/query"
+	"strings"
 	"time"
 )
 
@@ -31,9 +32,15 @@ const (
 
 var (
 	// may move query module to build sql
-	userByNameSql       = fmt.Sprintf(simpleQueryTpl, m.UserFields, m.USER_TABLE, m.F_USER_NAME)
-	bannedUserByNameSql = fmt.Sprintf(simpleQueryTpl, m.BannedUserFields,
-		m.BANNED_USER_TABLE, m.F_USER_NAME)
+	userByNameSql      = fmt.Sprintf(simpleQueryTpl, m.UserFields, m.USER_TABLE, m.F_USER_NAME)
+	userByEmailSql     = fmt.Sprintf(simpleQueryTpl, m.UserFields, m.USER_TABLE, m.F_EMAIL)
+	countUserByNameSql = q.ExistsQueryString(m.USER_TABLE, m.F_USER_ID, []string{m.F_USER_NAME})
+	userTokenByHashSql = fmt.Sprintf(simpleQueryTpl, m.UserTokenFields,
+		m.USER_TOKEN_TABLE, m.F_HASH_TOKEN)
+	userSessionByHashSql = fmt.Sprintf(simpleQueryTpl, m.UserSessionFields,
+		m.USER_SESSION_TABLE, m.F_HASH_TOKEN)
+	invalidateUserTokensSql = fmt.Sprintf("UPDATE %s SET %s = 1, %s = ? WHERE %s = ? AND %s = ? AND %s = 0",
+		m.USER_TOKEN_TABLE, m.F_IS_USED, m.F_USED_TIME, m.F_USER_ID, m.F_TOKEN_TYPE, m.F_IS_USED)
 )
 
 type Application struct {
@@ -52,8 +59,25 @@ func (c Application) GetClientInfo() (appKey string, appSecret string) {
 	return appKey, appSecret
 }
 
+// Returns the raw session token of the request header or params.
+func (c Application) GetSessionToken() string {
+	token := c.Request.Header.Get(m.PARAM_SESSION_TOKEN)
+	if len(token) == 0 {
+		token = c.Params.Get(m.PARAM_SESSION_TOKEN)
+	}
+	return token
+}
+
+func (c Application) clientIp() string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
 // Returns User of the specified userId.
-func (c Application) findUser(userId int64) *m.User {
+func (c Application) findUser(userId uint64) *m.User {
 	return m.ToUser(c.Txn.Get(m.User{}, userId))
 }
 
@@ -66,20 +90,164 @@ func (c Application) findUserByName(userName string) *m.User {
 	return users[0]
 }
 
-func (c Application) findValidUserByName(userName string) (*m.User, error) {
-	bUsers := m.ToBannedUsers(c.Txn.Select(m.BannedUser{}, bannedUserByNameSql, userName))
-	if len(bUsers) == 0 {
-		return c.findUserByName(userName), nil
+// Returns User of the specified email, or nil if email not exists.
+func (c Application) findUserByEmail(email string) *m.User {
+	users := m.ToUsers(c.Txn.Select(m.User{}, userByEmailSql, email))
+	if len(users) == 0 {
+		return nil
 	}
-	timeNow := time.Now()
-	for _, bUser := range bUsers {
+	return users[0]
+}
+
+// Returns true if the specified userName is already taken.
+func (c Application) existsUserName(userName string) bool {
+	count, err := c.Txn.SelectInt(countUserByNameSql, userName)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+// Returns true if the specified email is already taken as the email or
+// the spare email of any user.
+func (c Application) existsEmail(email string) bool {
+	inUse, err := m.EmailInUse(c.Txn, email, 0)
+	if err != nil {
+		panic(err)
+	}
+	return inUse
+}
+
+// Returns the user of the specified userId, or error if the user not
+// exists, or is banned or not activated.
+func (c Application) findValidUser(userId uint64) (*m.User, error) {
+	user := c.findUser(userId)
+	if user == nil {
+		return nil, errors.New(c.Message("users.notFound"))
+	}
+	if _, err := c.findValidUserByName(user.UserName); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Returns the valid user of the current session.
+func (c Application) sessionUser() (*m.User, error) {
+	session := c.currentSession()
+	if session == nil {
+		return nil, errors.New(c.Message("sessions.invalid"))
+	}
+	return c.findValidUser(session.UserId)
+}
+
+// Returns User of the specified userName, or error if the user is
+// banned or not activated, or is a disabled inner user.
+func (c Application) findValidUserByName(userName string) (*m.User, error) {
+	for _, bUser := range m.ActiveBannedUsers(c.Txn, userName) {
 		if bUser.IsPermanent {
-			return nil, c.Message("users.permanentBannedUser", bUser.UserName, bUser.Cause)
+			return nil, errors.New(c.Message("users.permanentBannedUser",
+				bUser.UserName, bUser.Cause))
+		}
+		return nil, errors.New(c.Message("users.timelinessBannedUser",
+			bUser.UserName, bUser.BannedTime.Time, bUser.UnbanTime.Time, bUser.Cause))
+	}
+	user := c.findUserByName(userName)
+	if user != nil && !user.IsActivated {
+		if user.IsInnerUser() {
+			return nil, errors.New(c.Message("users.disabledInnerUser", user.UserName))
 		}
-		if bUser.UnbanTime.Valid && bUser.UnbanTime.Time.After(timeNow) {
-			return nil, errors.New(c.Message("users.timelinessBannedUser",
-				bUser.UserName, bUser.BannedTime.Time, bUser.UnbanTime.Time, bUser.Cause))
+		return nil, errors.New(c.Message("users.notActivated", user.UserName))
+	}
+	return user, nil
+}
+
+// Returns the valid user of the specified user name or email and password,
+// the stored hash is upgraded if it was created by a legacy hasher. The user
+// is checked after the password matches, so the state of an account is never
+// revealed without its password.
+func (c Application) authenticate(account, password string) (*m.User, error) {
+	var user *m.User
+	account = strings.TrimSpace(account)
+	if strings.Contains(account, "@") {
+		user = c.findUserByEmail(strings.ToLower(account))
+	} else {
+		user = c.findUserByName(account)
+	}
+	if user == nil {
+		return nil, errors.New(c.Message("users.errorLogin"))
+	}
+	matched, upgraded := user.MatchPassword(password)
+	if !matched {
+		return nil, errors.New(c.Message("users.errorLogin"))
+	}
+	if _, err := c.findValidUserByName(user.UserName); err != nil {
+		return nil, err
+	}
+	if upgraded {
+		if _, err := c.Txn.Update(user); err != nil {
+			panic(err)
 		}
 	}
-	return c.findUserByName(userName), nil
+	return user, nil
+}
+
+// Inserts a new token of the specified type for user and invalidates the
+// previous unused ones of the same type. Returns the raw token.
+func (c Application) issueUserToken(user *m.User, tokenType uint16, email string,
+	expires time.Duration) string {
+	if _, err := c.Txn.Exec(invalidateUserTokensSql, time.Now(), user.UserId, tokenType); err != nil {
+		panic(err)
+	}
+	userToken, token := m.NewUserToken(user, tokenType, email, expires)
+	if err := c.Txn.Insert(userToken); err != nil {
+		panic(err)
+	}
+	return token
+}
+
+// Returns the UserToken of the specified raw token and type and marks it used,
+// or error if the token is not found, used or expired.
+func (c Application) consumeUserToken(token string, tokenType uint16) (*m.UserToken, error) {
+	if len(token) == 0 {
+		return nil, errors.New(c.Message("tokens.invalid"))
+	}
+	userTokens := m.ToUserTokens(c.Txn.Select(m.UserToken{}, userTokenByHashSql,
+		m.HashToken(token)))
+	if len(userTokens) == 0 || userTokens[0].TokenType != tokenType || userTokens[0].IsUsed {
+		return nil, errors.New(c.Message("tokens.invalid"))
+	}
+	userToken := userTokens[0]
+	if userToken.IsExpired() {
+		return nil, errors.New(c.Message("tokens.expired"))
+	}
+	if _, err := c.Txn.Update(userToken.Use()); err != nil {
+		panic(err)
+	}
+	return userToken, nil
+}
+
+// Inserts a new login session of user, returns the session and the raw token.
+func (c Application) issueUserSession(user *m.User, client *m.Client,
+	expires time.Duration) (*m.UserSession, string) {
+	session, token := m.NewUserSession(user, client, c.clientIp(),
+		c.Request.UserAgent(), expires)
+	if err := c.Txn.Insert(session); err != nil {
+		panic(err)
+	}
+	return session, token
+}
+
+// Returns the not expired UserSession of the request's session token,
+// or nil if the request has no valid session.
+func (c Application) currentSession() *m.UserSession {
+	token := c.GetSessionToken()
+	if len(token) == 0 {
+		return nil
+	}
+	sessions := m.ToUserSessions(c.Txn.Select(m.UserSession{}, userSessionByHashSql,
+		m.HashToken(token)))
+	if len(sessions) == 0 || sessions[0].IsExpired() {
+		return nil
+	}
+	return sessions[0]
 }
@@ -0,0 +1,123 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"github.com/robfig/revel"
+	"log"
+	"path/filepath"
+	"smart-kids/avatar"
+	"smart-kids/storage"
+	"smart-kids/util"
+)
+
+const (
+	defaultAvatarMaxSize = 2 << 20 // 2 MB
+)
+
+var (
+	Storage storage.Storage
+
+	avatarErrorKeys = map[error]string{
+		avatar.UnsupportedTypeError: "avatar.unsupportedType",
+		avatar.DimensionError:       "avatar.invalidDimension",
+	}
+)
+
+// Initializes Storage of the `storage.driver` config, only "file" now. The
+// file storage writes into `storage.file.dir`, <app>/public/uploads if empty.
+func initStorage() {
+	switch driver := revel.Config.StringDefault("storage.driver", "file"); driver {
+	case "file":
+		dir := revel.Config.StringDefault("storage.file.dir", "")
+		if len(dir) == 0 {
+			dir = filepath.Join(revel.BasePath, "public", "uploads")
+		}
+		Storage = storage.NewFileStorage(dir, revel.Config.StringDefault("storage.file.url", "/public/uploads"))
+	default:
+		log.Fatalf("Unsupported storage.driver: %s", driver)
+	}
+}
+
+// Uploads the avatar of the current session's user, the JPEG, PNG or GIF
+// image is center cropped and resized to 120x120, 50x50 and 25x25. The old
+// renditions are deleted after the new ones are committed.
+func (u Users) UploadAvatar(avatarFile []byte) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	if len(avatarFile) == 0 {
+		return u.RenderJson(util.FailureResult(u.Message("avatar.required")))
+	}
+	maxSize := revel.Config.IntDefault("avatar.max_size", defaultAvatarMaxSize)
+	renditions, err := avatar.Process(avatarFile, maxSize)
+	if err != nil {
+		if err == avatar.TooLargeError {
+			return u.RenderJson(util.FailureResult(u.Message("avatar.tooLarge", maxSize>>10)))
+		}
+		if key, ok := avatarErrorKeys[err]; ok {
+			return u.RenderJson(util.FailureResult(u.Message(key)))
+		}
+		panic(err)
+	}
+
+	// New keys of every upload, so the cached old avatars are never served.
+	prefix := fmt.Sprintf("avatars/%d/%s", user.UserId, util.RandomAlphanumeric(16))
+	keys := make([]string, 0, len(renditions))
+	uris := make(map[int]string)
+	defer func() {
+		// Deletes the stored renditions if the transaction is rolled back.
+		if err := recover(); err != nil {
+			for _, key := range keys {
+				Storage.Delete(key)
+			}
+			panic(err)
+		}
+	}()
+	for _, r := range renditions {
+		key := fmt.Sprintf("%s-%d.%s", prefix, r.Size, r.Ext)
+		uri, err := Storage.Put(key, r.ContentType, r.Data)
+		if err != nil {
+			panic(err)
+		}
+		keys = append(keys, key)
+		uris[r.Size] = uri
+	}
+	oldUris := []string{user.AvatarUri.String, user.SmallAvatarUri.String, user.ThumbAvatarUri.String}
+	user.AvatarUri.Scan(uris[avatar.LARGE_SIZE])
+	user.SmallAvatarUri.Scan(uris[avatar.SMALL_SIZE])
+	user.ThumbAvatarUri.Scan(uris[avatar.THUMB_SIZE])
+	if _, err = u.Txn.Update(user); err != nil {
+		panic(err)
+	}
+	u.afterCommit(func() {
+		for _, uri := range oldUris {
+			if key, ok := Storage.KeyOf(uri); ok {
+				if err := Storage.Delete(key); err != nil {
+					revel.ERROR.Printf("Remove the old avatar %s error: %s", key, err.Error())
+				}
+			}
+		}
+	})
+	return u.RenderJson(util.SuccessResult(u.Message("avatar.s.uploaded")).
+		AddValue("avatarUri", user.AvatarUri.String).
+		AddValue("smallAvatarUri", user.SmallAvatarUri.String).
+		AddValue("thumbAvatarUri", user.ThumbAvatarUri.String))
+}
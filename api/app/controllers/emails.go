@@ -0,0 +1,183 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	"smart-kids/api/app/routes"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+const (
+	changeEmailExpires     = 24 * time.Hour
+	undoEmailChangeExpires = 7 * 24 * time.Hour
+)
+
+var (
+	emailChangeErrorKeys = map[error]string{
+		m.EmailInUseError:          "emails.inUse",
+		m.SameEmailError:           "emails.same",
+		m.EmailChangeNotFoundError: "emails.notFound",
+	}
+)
+
+// Renders the failure result of the email change error, or panics if it's
+// not an email change error so the transaction is rolled back.
+func (u Users) renderEmailChangeError(err error) revel.Result {
+	if key, ok := emailChangeErrorKeys[err]; ok {
+		return u.RenderJson(util.FailureResult(u.Message(key)))
+	}
+	panic(err)
+}
+
+// Requests to change the email or the spare email of the current session's
+// user to the new address, which is confirmed by the link mailed to it.
+func (u Users) requestEmailChange(kind int16, email, password string) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if m.ValidateEmail(u.Validation, email); u.Validation.HasErrors() {
+		return u.RenderJson(util.FailureResult(u.Message("emails.v.email")))
+	}
+	if matched, _ := user.MatchPassword(password); !matched {
+		return u.RenderJson(util.FailureResult(u.Message("emails.v.password")))
+	}
+	if _, err = m.RequestEmailChange(u.Txn, user, kind, email); err != nil {
+		return u.renderEmailChangeError(err)
+	}
+	tokenType, subject, body := m.TOKEN_CHANGE_EMAIL, "mail.changeEmail.subject", "mail.changeEmail.body"
+	if kind == m.EMAIL_SPARE {
+		tokenType, subject, body = m.TOKEN_VERIFY_SPARE_EMAIL, "mail.verifySpareEmail.subject",
+			"mail.verifySpareEmail.body"
+	}
+	token := u.issueUserToken(user, tokenType, email, changeEmailExpires)
+	link := siteUrl(routes.Users.ConfirmEmailChange(token))
+	if kind == m.EMAIL_SPARE {
+		link = siteUrl(routes.Users.VerifySpareEmail(token))
+	}
+	err = sendMail(email, u.Message(subject),
+		u.Message(body, user.UserName, link, int(changeEmailExpires.Hours())))
+	if err != nil {
+		return u.RenderJson(util.ErrorResult(u.Message("users.errorSendMail")))
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("emails.s.confirmationSent", email)))
+}
+
+// Requests to change the email of the current session's user, confirmed by
+// the login password.
+func (u Users) ChangeEmail(email, password string) revel.Result {
+	return u.requestEmailChange(m.EMAIL_PRIMARY, email, password)
+}
+
+// Requests to set the spare email of the current session's user, the spare
+// email is used to recover the account only after it's verified.
+func (u Users) ChangeSpareEmail(email, password string) revel.Result {
+	return u.requestEmailChange(m.EMAIL_SPARE, email, password)
+}
+
+// Confirms the email change of the specified token, a notice with the undo
+// link is mailed to the old address.
+func (u Users) ConfirmEmailChange(token string) revel.Result {
+	userToken, err := u.consumeUserToken(token, m.TOKEN_CHANGE_EMAIL)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	user := u.findUser(userToken.UserId)
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	change, err := m.ConfirmEmailChange(u.Txn, user, m.EMAIL_PRIMARY, userToken.Email)
+	if err != nil {
+		return u.renderEmailChangeError(err)
+	}
+	// Not issued by issueUserToken, the undo links of the earlier changes
+	// must stay valid.
+	undoToken, rawToken := m.NewUserToken(user, m.TOKEN_UNDO_EMAIL_CHANGE, change.OldEmail,
+		undoEmailChangeExpires)
+	if err = u.Txn.Insert(undoToken); err != nil {
+		panic(err)
+	}
+	// The change is kept if the notice fails, the history shows it.
+	sendMail(change.OldEmail, u.Message("mail.emailChanged.subject"),
+		u.Message("mail.emailChanged.body", user.UserName, change.NewEmail,
+			siteUrl(routes.Users.UndoEmailChange(rawToken)), int(undoEmailChangeExpires.Hours()/24)))
+	return u.RenderJson(util.SuccessResult(u.Message("emails.s.changed", change.NewEmail)))
+}
+
+// Reverts the email change of the specified undo token, the user must log in
+// again with the old email.
+func (u Users) UndoEmailChange(token string) revel.Result {
+	userToken, err := u.consumeUserToken(token, m.TOKEN_UNDO_EMAIL_CHANGE)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	user := u.findUser(userToken.UserId)
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	change, err := m.UndoEmailChange(u.Txn, user, userToken.Email)
+	if err != nil {
+		return u.renderEmailChangeError(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("emails.s.undone", change.OldEmail)))
+}
+
+// Verifies the spare email of the specified token.
+func (u Users) VerifySpareEmail(token string) revel.Result {
+	userToken, err := u.consumeUserToken(token, m.TOKEN_VERIFY_SPARE_EMAIL)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	user := u.findUser(userToken.UserId)
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	if _, err = m.ConfirmEmailChange(u.Txn, user, m.EMAIL_SPARE, userToken.Email); err != nil {
+		return u.renderEmailChangeError(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("emails.s.spareVerified", userToken.Email)))
+}
+
+// Removes the spare email of the current session's user.
+func (u Users) RemoveSpareEmail(password string) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	if matched, _ := user.MatchPassword(password); !matched {
+		return u.RenderJson(util.FailureResult(u.Message("emails.v.password")))
+	}
+	if _, err = m.RemoveSpareEmail(u.Txn, user); err != nil {
+		return u.renderEmailChangeError(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("emails.s.spareRemoved")))
+}
+
+// Returns the latest email changes of the current session's user.
+func (u Users) EmailChanges() revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("changes", m.FindEmailChanges(u.Txn, user.UserId)))
+}
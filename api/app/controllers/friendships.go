@@ -0,0 +1,178 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	m "smart-kids/models"
+	"smart-kids/util"
+)
+
+const (
+	friendPageSize = 20
+)
+
+var (
+	friendshipErrorKeys = map[error]string{
+		m.SelfFriendError:       "friends.self",
+		m.FriendBlockedError:    "friends.blocked",
+		m.AlreadyFriendsError:   "friends.alreadyFriends",
+		m.AlreadyRequestedError: "friends.alreadyRequested",
+		m.NoFriendRequestError:  "friends.noRequest",
+	}
+)
+
+// Renders the failure result of the friendship error, or panics if it's
+// not a friendship error so the transaction is rolled back.
+func (u Users) renderFriendshipError(err error) revel.Result {
+	if key, ok := friendshipErrorKeys[err]; ok {
+		return u.RenderJson(util.FailureResult(u.Message(key)))
+	}
+	panic(err)
+}
+
+func (u Users) friendPageable(p int) *util.Pageable {
+	if p <= 0 {
+		p = 1
+	}
+	pageable, err := util.NewPageable(p, friendPageSize, util.DESC, []string{m.F_LAST_MODIFIED_TIME})
+	if err != nil {
+		panic(err)
+	}
+	return pageable
+}
+
+// Returns the page friends of the current session's user.
+func (u Users) Friends(p int) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("friends", m.FindFriends(u.Txn, user.UserId, u.friendPageable(p))))
+}
+
+// Returns the page pending friend requests to the current session's user.
+func (u Users) FriendRequests(p int) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("requests", m.FindFriendRequests(u.Txn, user.UserId, u.friendPageable(p))))
+}
+
+// Returns the page friends of the current session's user who are also
+// friends of the specified user, unless either of them blocked the other.
+func (u Users) MutualFriends(userId uint64, p int) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	if m.IsBlocked(u.Txn, user.UserId, userId) {
+		return u.RenderJson(util.FailureResult(u.Message("friends.mutualBlocked")))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("friends", m.FindMutualFriends(u.Txn, user.UserId, userId, u.friendPageable(p))))
+}
+
+// Sends a friend request to the specified user, the banned users can
+// neither send nor receive requests.
+func (u Users) RequestFriend(friendId uint64) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	friend, err := u.findValidUser(friendId)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	f, err := m.RequestFriend(u.Txn, user, friend)
+	if err != nil {
+		return u.renderFriendshipError(err)
+	}
+	if f.Status == m.FRIENDSHIP_ACCEPTED {
+		return u.RenderJson(util.SuccessResult(u.Message("friends.s.accepted", friend.UserName)).
+			AddValue("friendship", f))
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("friends.s.requested", friend.UserName)).
+		AddValue("friendship", f))
+}
+
+// Accepts the friend request of the specified user.
+func (u Users) AcceptFriend(requesterId uint64) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	requester, err := u.findValidUser(requesterId)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	f, err := m.AcceptFriend(u.Txn, user, requester.UserId)
+	if err != nil {
+		return u.renderFriendshipError(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("friends.s.accepted", requester.UserName)).
+		AddValue("friendship", f))
+}
+
+// Rejects the friend request of the specified user.
+func (u Users) RejectFriend(requesterId uint64) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	if err = m.RejectFriend(u.Txn, user.UserId, requesterId); err != nil {
+		return u.renderFriendshipError(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("friends.s.rejected")))
+}
+
+// Blocks the specified user, the friendship and requests are removed.
+func (u Users) BlockUser(userId uint64) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	target := u.findUser(userId)
+	if target == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	if _, err = m.BlockUser(u.Txn, user, target); err != nil {
+		return u.renderFriendshipError(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("friends.s.blocked", target.UserName)))
+}
+
+// Ends the friendship with, cancels the request to, or unblocks the
+// specified user.
+func (u Users) Unfollow(userId uint64) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	count, err := m.Unfollow(u.Txn, user.UserId, userId)
+	if err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		return u.RenderJson(util.FailureResult(u.Message("friends.notFound")))
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("friends.s.unfollowed")))
+}
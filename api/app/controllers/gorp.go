@@ -35,6 +35,7 @@ func Init() {
 	Dbm = &gorp.DbMap{Db: db.Db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
 
 	initUsers()
+	initDicts()
 	initApp()
 	Dbm.TraceOn("[gorp]", revel.INFO)
 }
@@ -59,30 +60,124 @@ func initUsers() {
 		"SpareEmail":     50,
 	})
 	t.ColMap("UserName").SetUnique(true)
+	t.ColMap("Email").SetUnique(true)
 
 	// Register UserDigital model
 	t = Dbm.AddTableWithName(models.UserDigital{}, models.USER_DIGITAL_TABLE).SetKeys(false, "UserId")
 	setColumnSizes(t, map[string]int{"UserName": 50})
 
+	// Register ScoreLedger model
+	t = Dbm.AddTableWithName(models.ScoreLedger{}, models.SCORE_LEDGER_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"UserName":     50,
+		"RefId":        64,
+		"OperatorName": 50,
+	})
+
+	// Register GradeRule model
+	t = Dbm.AddTableWithName(models.GradeRule{}, models.GRADE_RULE_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{"Name": 50})
+	t.ColMap("Grade").SetUnique(true)
+
+	// Register WalletTxn model
+	t = Dbm.AddTableWithName(models.WalletTxn{}, models.WALLET_TXN_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"IdempotencyKey": 64,
+		"UserName":       50,
+		"RefId":          64,
+		"Memo":           200,
+		"OperatorName":   50,
+	})
+	t.ColMap("IdempotencyKey").SetUnique(true)
+
+	// Register WalletEntry model
+	t = Dbm.AddTableWithName(models.WalletEntry{}, models.WALLET_ENTRY_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{"Account": 50})
+
+	// Register UserIdentity model
+	t = Dbm.AddTableWithName(models.UserIdentity{}, models.USER_IDENTITY_TABLE).SetKeys(false, "UserId")
+	setColumnSizes(t, map[string]int{
+		"RealName":     200,
+		"Idcard":       200,
+		"IdcardHash":   64,
+		"IdImgDomain":  100,
+		"IdImgPath":    200,
+		"RejectCause":  500,
+		"ReviewerName": 50,
+	})
+
 	// Register UserInfo model
 	t = Dbm.AddTableWithName(models.UserInfo{}, models.USER_INFO_TABLE).SetKeys(false, "UserId")
 	setColumnSizes(t, map[string]int{
 		"UserName":       50,
 		"Nickname":       50,
 		"DateOfBirthStr": 10,
+		"LunarBirthStr":  11,
 		"OtherState":     100,
 	})
 
+	// Register Friendship model
+	t = Dbm.AddTableWithName(models.Friendship{}, models.FRIENDSHIP_TABLE).SetKeys(false, "UserId", "FriendId")
+	setColumnSizes(t, map[string]int{
+		"UserName":   50,
+		"FriendName": 50,
+	})
+
+	// Register UserDataRequest model
+	t = Dbm.AddTableWithName(models.UserDataRequest{}, models.USER_DATA_REQUEST_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"UserName":    50,
+		"ArchiveKey":  200,
+		"ArchiveUri":  255,
+		"FailedCause": 500,
+	})
+
+	// Register EmailChange model
+	t = Dbm.AddTableWithName(models.EmailChange{}, models.EMAIL_CHANGE_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"UserName": 50,
+		"OldEmail": 50,
+		"NewEmail": 50,
+	})
+
+	// Register UserToken model
+	t = Dbm.AddTableWithName(models.UserToken{}, models.USER_TOKEN_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"UserName":  50,
+		"HashToken": 64,
+		"Email":     50,
+	})
+	t.ColMap("HashToken").SetUnique(true)
+
+	// Register UserSession model
+	t = Dbm.AddTableWithName(models.UserSession{}, models.USER_SESSION_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"UserName":  50,
+		"HashToken": 64,
+		"ClientIp":  50,
+		"UserAgent": 255,
+	})
+	t.ColMap("HashToken").SetUnique(true)
+
 	// Register BannedUser model
-	t = Dbm.AddTableWithName(models.BannedUser, models.BANNED_USER_TABLE).SetKeys(true, "Id")
+	t = Dbm.AddTableWithName(models.BannedUser{}, models.BANNED_USER_TABLE).SetKeys(true, "Id")
 	setColumnSizes(t, map[string]int{
 		"UserName":           50,
 		"OperatorName":       50,
 		"Cause":              2000,
+		"LiftedCause":        2000,
 		"LastModifiedByName": 50,
 	})
 }
 
+// Registers the read only dictionary models of locations.
+func initDicts() {
+	Dbm.AddTableWithName(models.Country{}, models.DICT_COUNTRY_TABLE).SetKeys(false, "Id")
+	Dbm.AddTableWithName(models.Province{}, models.DICT_PROVINCE_TABLE).SetKeys(false, "Id")
+	Dbm.AddTableWithName(models.City{}, models.DICT_CITY_TABLE).SetKeys(false, "Id")
+	Dbm.AddTableWithName(models.District{}, models.DICT_DISTRICT_TABLE).SetKeys(false, "Id")
+}
+
 func initApp() {
 	// Register Developer model
 	t := Dbm.AddTableWithName(models.Developer{}, models.DEVELOPER_TABLE).SetKeys(false, "UserId")
@@ -110,15 +205,20 @@ func initApp() {
 	t.ColMap("AppKey").SetUnique(true)
 	t.ColMap("AppSecret").SetUnique(true)
 
-	t = Dbm.AddTableWithName(models.AppSession{}, models.APP_SESSION_TABLE).SetKeys(false, "AppId")
+	// Register AppGrant model
+	t = Dbm.AddTableWithName(models.AppGrant{}, models.APP_GRANT_TABLE).SetKeys(false, "AppId", "UserId")
 	setColumnSizes(t, map[string]int{
-		"AppName":     100,
-		"AppAuthCode": 50,
-		"AccessToken": 50,
-		"AppKey":      100,
-		"AppSecret":   100,
+		"AppName":          100,
+		"UserName":         50,
+		"Scope":            200,
+		"HashAuthCode":     64,
+		"CodeScope":        200,
+		"HashAccessToken":  64,
+		"RedirectUri":      255,
+		"HashRefreshToken": 64,
 	})
-	t.ColMap("AccessToken").SetUnique(true)
+	t.ColMap("HashAccessToken").SetUnique(true)
+	t.ColMap("HashRefreshToken").SetUnique(true)
 }
 
 func initForum() {
@@ -144,6 +244,14 @@ func initForum() {
 type GorpController struct {
 	*revel.Controller
 	Txn *gorp.Transaction
+
+	afterCommits []func()
+}
+
+// Registers the f called after the transaction of the request is committed,
+// such as removing the stored files which are no longer referenced.
+func (c *GorpController) afterCommit(f func()) {
+	c.afterCommits = append(c.afterCommits, f)
 }
 
 func (c *GorpController) Begin() revel.Result {
@@ -163,6 +271,10 @@ func (c *GorpController) Commit() revel.Result {
 		panic(err)
 	}
 	c.Txn = nil
+	for _, f := range c.afterCommits {
+		f()
+	}
+	c.afterCommits = nil
 	return nil
 }
 
@@ -174,5 +286,6 @@ func (c *GorpController) Rollback() revel.Result {
 		panic(err)
 	}
 	c.Txn = nil
+	c.afterCommits = nil
 	return nil
 }
@@ -0,0 +1,51 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	m "smart-kids/models"
+	"smart-kids/util"
+)
+
+// Returns the apps authorized by the current session's user with the
+// granted scopes.
+func (u Users) AuthorizedApps() revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("apps", m.FindUserAppGrants(u.Txn, user.UserId)))
+}
+
+// Revokes the authorization of the current session's user to the app, the
+// tokens issued to the app are invalid at once.
+func (u Users) RevokeApp(appId uint) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	revoked, err := m.RevokeAppGrant(u.Txn, appId, user.UserId)
+	if err != nil {
+		panic(err)
+	}
+	if !revoked {
+		return u.RenderJson(util.FailureResult(u.Message("apps.notAuthorized")))
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("apps.s.revoked")))
+}
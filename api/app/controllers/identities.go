@@ -0,0 +1,171 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/robfig/revel"
+	"smart-kids/avatar"
+	"smart-kids/idcard"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"strings"
+)
+
+const (
+	defaultIdImageMaxSize = 4 << 20 // 4 MB
+)
+
+var (
+	countApprovedIdcardSql = fmt.Sprintf("SELECT count(%s) FROM %s WHERE %s = ? AND %s = ? AND %s <> ?",
+		m.F_USER_ID, m.USER_IDENTITY_TABLE, m.F_IDCARD_HASH, m.F_VERIFY_STATUS, m.F_USER_ID)
+
+	idcardErrorKeys = map[error]string{
+		idcard.LengthError:   "identity.v.idcardLength",
+		idcard.FormatError:   "identity.v.idcardFormat",
+		idcard.BirthError:    "identity.v.idcardBirth",
+		idcard.ChecksumError: "identity.v.idcardChecksum",
+	}
+
+	// The file extensions of the supported idcard image types.
+	idImageExts = map[string]string{
+		"image/jpeg": "jpg",
+		"image/png":  "png",
+		"image/gif":  "gif",
+	}
+)
+
+// Returns the UserIdentity of the specified user, or nil if not submitted.
+func (u Users) findIdentity(userId uint64) *m.UserIdentity {
+	return m.ToUserIdentity(u.Txn.Get(m.UserIdentity{}, userId))
+}
+
+// Submits the real name identity of the current session's user for review,
+// the idcard number must be valid and not approved for another user. The
+// uploaded idcard image is saved by Storage, the previous one is deleted
+// after the submission commits.
+func (u Users) SubmitIdentity(realName, idcardNo string, idImage []byte) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	realName = strings.TrimSpace(realName)
+	u.Validation.Required(realName).Key("realName").Message(u.Message("identity.v.realNameRequired"))
+	u.Validation.Range(len([]rune(realName)), 2, 20).Key("realName").
+		Message(u.Message("identity.v.realNameLength", 2, 20))
+	maxSize := revel.Config.IntDefault("identity.image_max_size", defaultIdImageMaxSize)
+	contentType, err := avatar.ContentType(idImage)
+	switch {
+	case len(idImage) == 0:
+		u.Validation.Error(u.Message("identity.v.imageRequired")).Key("idImage")
+	case len(idImage) > maxSize:
+		u.Validation.Error(u.Message("identity.v.imageTooLarge", maxSize>>10)).Key("idImage")
+	case err != nil:
+		u.Validation.Error(u.Message("identity.v.imageType")).Key("idImage")
+	}
+	id, err := idcard.Parse(idcardNo)
+	if err != nil {
+		u.Validation.Error(u.Message(idcardErrorKeys[err])).Key("idcard")
+	}
+	if u.Validation.HasErrors() {
+		result := util.FailureResult(u.Message("identity.v.submitFailed"))
+		for k, v := range u.Validation.ErrorMap() {
+			if v != nil {
+				result.AddValue(k, v.Message)
+			}
+		}
+		return u.RenderJson(result)
+	}
+	count, err := u.Txn.SelectInt(countApprovedIdcardSql, m.IdcardHashOf(id.Number), m.IDENTITY_APPROVED, user.UserId)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		return u.RenderJson(util.FailureResult(u.Message("identity.idcardUsed")))
+	}
+
+	identity := u.findIdentity(user.UserId)
+	isNew := identity == nil
+	if isNew {
+		identity = &m.UserIdentity{UserId: user.UserId}
+	} else if identity.Status == m.IDENTITY_APPROVED {
+		return u.RenderJson(util.FailureResult(u.Message("identity.alreadyApproved")))
+	}
+	oldUri := identity.IdImageUrl()
+
+	// New key of every submission, the image is never linked to a guessed uri.
+	key := fmt.Sprintf("identities/%d/%s.%s", user.UserId, util.RandomAlphanumeric(32),
+		idImageExts[contentType])
+	uri, err := Storage.Put(key, contentType, idImage)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		// Deletes the stored image if the transaction is rolled back.
+		if err := recover(); err != nil {
+			Storage.Delete(key)
+			panic(err)
+		}
+	}()
+	identity.RealName, identity.Idcard = realName, id.Number
+	identity.Gender = m.Female
+	if id.IsMale {
+		identity.Gender = m.Male
+	}
+	identity.GenderCode = identity.Gender.Code
+	identity.IdImgDomain, identity.IdImgPath = sql.NullString{}, sql.NullString{uri, true}
+	identity.Submit()
+	if isNew {
+		err = u.Txn.Insert(identity)
+	} else {
+		_, err = u.Txn.Update(identity)
+	}
+	if err != nil {
+		panic(err)
+	}
+	u.afterCommit(func() {
+		if key, ok := Storage.KeyOf(oldUri); ok {
+			if err := Storage.Delete(key); err != nil {
+				revel.ERROR.Printf("Remove the old idcard image %s error: %s", key, err.Error())
+			}
+		}
+	})
+	return u.RenderJson(util.SuccessResult(u.Message("identity.s.submitted")))
+}
+
+// Returns the identity verify status of the current session's user.
+func (u Users) Identity() revel.Result {
+	session := u.currentSession()
+	if session == nil {
+		return u.RenderJson(util.FailureResult(u.Message("sessions.invalid")))
+	}
+	identity := u.findIdentity(session.UserId)
+	if identity == nil {
+		return u.RenderJson(util.SuccessResult("").AddValue("status", m.IDENTITY_NONE))
+	}
+	result := util.SuccessResult("").
+		AddValue("status", identity.Status).
+		AddValue("realName", identity.MaskedRealName()).
+		AddValue("idcard", identity.MaskedIdcard()).
+		AddValue("rejectCause", identity.RejectCause)
+	if identity.VarifiedDate.Valid {
+		result.AddValue("varifiedDate", identity.VarifiedDate.Time)
+	}
+	return u.RenderJson(result)
+}
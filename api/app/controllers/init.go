@@ -17,14 +17,24 @@
 package controllers
 
 import (
+	"encoding/base64"
 	"github.com/robfig/revel"
-	"reflect"
+	"log"
+	"smart-kids/crypt"
+	m "smart-kids/models"
+	"smart-kids/passwd"
 	"smart-kids/util"
-	"strings"
 )
 
 func init() {
 	revel.OnAppStart(Init)
+	revel.OnAppStart(initInnerUsers)
+	revel.OnAppStart(initPasswordHasher)
+	revel.OnAppStart(initCrypt)
+	revel.OnAppStart(initMailer)
+	revel.OnAppStart(initStorage)
+	revel.OnAppStart(initGradeRules)
+	revel.OnAppStart(initJobs)
 	revel.InterceptMethod((*GorpController).Begin, revel.BEFORE)
 	// revel.InterceptMethod(Application.AddAdmin, revel.BEFORE)
 	// revel.InterceptMethod(Application.AddMenus, revel.BEFORE)
@@ -37,3 +47,42 @@ func init() {
 	revel.TemplateFuncs["lt"] = util.LessThan
 	revel.TemplateFuncs["le"] = util.LessThanOrEqual
 }
+
+// Reserves the inner user ids, the public registration never receives them.
+func initInnerUsers() {
+	raised, err := m.ReserveInnerUserIds(Dbm)
+	if err != nil {
+		log.Fatalf("Reserve inner user ids error: %s", err.Error())
+	}
+	if raised {
+		revel.INFO.Printf("The user ids up to %d are reserved for the inner users", m.MAX_INNER_USER_ID)
+	}
+}
+
+// Sets the current password hasher of the `passwd.hasher` config,
+// hashes created by other hashers are upgraded on login.
+func initPasswordHasher() {
+	hasher := revel.Config.StringDefault("passwd.hasher", passwd.BCRYPT)
+	if err := passwd.SetDefault(hasher); err != nil {
+		log.Fatalf("Unsupported passwd.hasher: %s", hasher)
+	}
+}
+
+// Sets the default keyring of the `crypt.*` configs which encrypts the
+// personal data, the app can not start without valid keys.
+func initCrypt() {
+	keys, err := crypt.ParseKeys(revel.Config.StringDefault("crypt.keys", ""))
+	if err != nil {
+		log.Fatalf("Invalid crypt.keys: %s", err.Error())
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(revel.Config.StringDefault("crypt.index_key", ""))
+	if err != nil {
+		log.Fatalf("Invalid crypt.index_key: %s", err.Error())
+	}
+	current := revel.Config.IntDefault("crypt.current", 1)
+	keyring, err := crypt.NewKeyring(uint32(current), keys, indexKey)
+	if err != nil {
+		log.Fatalf("Invalid crypt configs: %s", err.Error())
+	}
+	crypt.SetDefault(keyring)
+}
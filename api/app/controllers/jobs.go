@@ -0,0 +1,244 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/robfig/revel"
+	"github.com/robfig/revel/modules/jobs/app/jobs"
+	"log"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"time"
+)
+
+// Job ends the temporary bans which unban time has passed.
+type ExpireBannedUsers struct{}
+
+func (j ExpireBannedUsers) Run() {
+	count, err := m.ExpireBannedUsers(Dbm, time.Now())
+	if err != nil {
+		revel.ERROR.Printf("Expire banned users error: %s", err.Error())
+		return
+	}
+	if count > 0 {
+		revel.INFO.Printf("%d expired banned users are unbanned", count)
+	}
+}
+
+// Job reloads the grade rules edited in ruler.
+type ReloadGradeRules struct{}
+
+func (j ReloadGradeRules) Run() {
+	defer func() {
+		if err := recover(); err != nil {
+			revel.ERROR.Printf("Reload grade rules error: %v", err)
+		}
+	}()
+	m.SetGradeRules(m.LoadGradeRules(Dbm))
+}
+
+// Job mails the birthday greetings to the users whose solar or lunar
+// birthday is today.
+type BirthdayReminders struct{}
+
+func (j BirthdayReminders) Run() {
+	defer func() {
+		if err := recover(); err != nil {
+			revel.ERROR.Printf("Birthday reminders error: %v", err)
+		}
+	}()
+	locale := revel.Config.StringDefault("i18n.default_language", "zh-cn")
+	count := 0
+	for _, userInfo := range m.FindBirthdayUserInfos(Dbm, time.Now()) {
+		if userInfo.User == nil || !userInfo.User.IsActivated {
+			continue
+		}
+		name := userInfo.UserName
+		if userInfo.Nickname.Valid {
+			name = userInfo.Nickname.String
+		}
+		if sendMail(userInfo.User.Email, revel.Message(locale, "mail.birthday.subject"),
+			revel.Message(locale, "mail.birthday.body", name)) == nil {
+			count++
+		}
+	}
+	revel.INFO.Printf("%d birthday reminders are sent", count)
+}
+
+// Job exports the personal data and deletes the accounts of the pending data
+// requests, and removes the expired export archives.
+type ProcessDataRequests struct{}
+
+func (j ProcessDataRequests) Run() {
+	defer func() {
+		if err := recover(); err != nil {
+			revel.ERROR.Printf("Process data requests error: %v", err)
+		}
+	}()
+	limit := revel.Config.IntDefault("data.batch", 10)
+	for _, request := range m.PendingDataRequests(Dbm, m.DATA_EXPORT, limit) {
+		processDataRequest(request, exportUserData)
+	}
+	for _, request := range m.PendingDataRequests(Dbm, m.DATA_DELETION, limit) {
+		processDataRequest(request, deleteUserData)
+	}
+	for _, request := range m.ExpiredDataExports(Dbm, time.Now()) {
+		if err := Storage.Delete(request.ArchiveKey); err != nil {
+			revel.ERROR.Printf("Remove the archive of %s error: %s", request, err.Error())
+			continue
+		}
+		if _, err := Dbm.Update(request.Expire()); err != nil {
+			revel.ERROR.Printf("Expire %s error: %s", request, err.Error())
+		}
+	}
+}
+
+// Processes the request by fn in a transaction, the returned func of fn is
+// called after committed. The request is marked failed if fn fails.
+func processDataRequest(request *m.UserDataRequest,
+	fn func(*gorp.Transaction, *m.UserDataRequest) (func(), error)) {
+	txn, err := Dbm.Begin()
+	if err != nil {
+		revel.ERROR.Printf("Begin transaction error: %s", err.Error())
+		return
+	}
+	var committed func()
+	err = func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}()
+		committed, err = fn(txn, request)
+		return
+	}()
+	if err == nil {
+		err = txn.Commit()
+	} else {
+		txn.Rollback()
+	}
+	if err != nil {
+		revel.ERROR.Printf("Process %s error: %s", request, err.Error())
+		if _, err = Dbm.Update(request.Fail(err.Error())); err != nil {
+			revel.ERROR.Printf("Fail %s error: %s", request, err.Error())
+		}
+		return
+	}
+	if committed != nil {
+		committed()
+	}
+}
+
+// Stores the archive of the user's data and mails the link of it, the link
+// expires after `data.export_expires` hours.
+func exportUserData(txn *gorp.Transaction, request *m.UserDataRequest) (func(), error) {
+	user := m.ToUser(txn.Get(m.User{}, request.UserId))
+	if user == nil {
+		return nil, errors.New("The user is not found.")
+	}
+	data, err := m.CollectUserData(txn, user)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := data.Archive()
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("exports/%d/%s.zip", user.UserId, util.RandomToken(24))
+	uri, err := Storage.Put(key, "application/zip", archive)
+	if err != nil {
+		return nil, err
+	}
+	hours := revel.Config.IntDefault("data.export_expires", 168)
+	if _, err = txn.Update(request.Done(key, uri, time.Duration(hours)*time.Hour)); err != nil {
+		Storage.Delete(key)
+		return nil, err
+	}
+	return func() {
+		locale := revel.Config.StringDefault("i18n.default_language", "zh-cn")
+		sendMail(user.Email, revel.Message(locale, "mail.dataExport.subject"),
+			revel.Message(locale, "mail.dataExport.body", user.UserName, uri, hours))
+	}, nil
+}
+
+// Deletes the user's account and data, the stored avatars and export archives
+// are removed and a confirmation is mailed after committed.
+func deleteUserData(txn *gorp.Transaction, request *m.UserDataRequest) (func(), error) {
+	user := m.ToUser(txn.Get(m.User{}, request.UserId))
+	if user == nil {
+		return nil, errors.New("The user is not found.")
+	}
+	keys, err := m.DeleteUserData(txn, user)
+	if err != nil {
+		return nil, err
+	}
+	for _, uri := range []string{user.AvatarUri.String, user.SmallAvatarUri.String, user.ThumbAvatarUri.String} {
+		if key, ok := Storage.KeyOf(uri); ok {
+			keys = append(keys, key)
+		}
+	}
+	request.UserName = m.DELETED_USER_NAME
+	if _, err = txn.Update(request.Done("", "", 0)); err != nil {
+		return nil, err
+	}
+	return func() {
+		for _, key := range keys {
+			if err := Storage.Delete(key); err != nil {
+				revel.ERROR.Printf("Remove %s of the deleted user error: %s", key, err.Error())
+			}
+		}
+		locale := revel.Config.StringDefault("i18n.default_language", "zh-cn")
+		sendMail(user.Email, revel.Message(locale, "mail.accountDeleted.subject"),
+			revel.Message(locale, "mail.accountDeleted.body", user.UserName))
+	}, nil
+}
+
+// Loads the grade rules and logs the grade changes.
+func initGradeRules() {
+	m.SetGradeRules(m.LoadGradeRules(Dbm))
+	m.OnGradeChanged(func(change m.GradeChange) {
+		revel.INFO.Printf("User (%d, %s) grade changed: %d -> %d", change.UserId,
+			change.UserName, change.OldGrade, change.Grade)
+	})
+}
+
+// Schedules the background jobs, the schedule of ExpireBannedUsers is the
+// `bans.sweep` config, ReloadGradeRules is the `grades.reload` config, and
+// BirthdayReminders is the `birthdays.remind` config, and ProcessDataRequests
+// is the `data.process` config.
+func initJobs() {
+	spec := revel.Config.StringDefault("bans.sweep", "@every 1m")
+	if err := jobs.Schedule(spec, ExpireBannedUsers{}); err != nil {
+		log.Fatalf("Invalid bans.sweep: %s", spec)
+	}
+	spec = revel.Config.StringDefault("grades.reload", "@every 1m")
+	if err := jobs.Schedule(spec, ReloadGradeRules{}); err != nil {
+		log.Fatalf("Invalid grades.reload: %s", spec)
+	}
+	spec = revel.Config.StringDefault("birthdays.remind", "0 0 8 * * *")
+	if err := jobs.Schedule(spec, BirthdayReminders{}); err != nil {
+		log.Fatalf("Invalid birthdays.remind: %s", spec)
+	}
+	spec = revel.Config.StringDefault("data.process", "@every 5m")
+	if err := jobs.Schedule(spec, ProcessDataRequests{}); err != nil {
+		log.Fatalf("Invalid data.process: %s", spec)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	"log"
+	"smart-kids/mail"
+	"strings"
+)
+
+var (
+	Mailer mail.Mailer
+)
+
+// Initializes Mailer of the `mail.driver` config, "file" (default) or "smtp".
+func initMailer() {
+	from := revel.Config.StringDefault("mail.from", "noreply@smartkids.com")
+	switch driver := revel.Config.StringDefault("mail.driver", "file"); driver {
+	case "file":
+		Mailer = mail.NewFileMailer(revel.Config.StringDefault("mail.file.dir", ""), from)
+	case "smtp":
+		Mailer = mail.NewSmtpMailer(
+			revel.Config.StringDefault("mail.smtp.addr", "127.0.0.1:25"), from,
+			revel.Config.StringDefault("mail.smtp.username", ""),
+			revel.Config.StringDefault("mail.smtp.password", ""))
+	default:
+		log.Fatalf("Unsupported mail.driver: %s", driver)
+	}
+}
+
+// Returns the absolute url of the specified path, prefixed by `site.url` config.
+func siteUrl(path string) string {
+	return strings.TrimRight(revel.Config.StringDefault("site.url", ""), "/") + path
+}
+
+// Sends a plain text mail, the error is logged and returned.
+func sendMail(to, subject, body string) error {
+	err := Mailer.Send(mail.NewMessage(to, subject, body))
+	if err != nil {
+		revel.ERROR.Printf("Send mail to %s error: %s", to, err.Error())
+	}
+	return err
+}
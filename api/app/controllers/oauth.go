@@ -17,102 +17,190 @@
 package controllers
 
 import (
-	"errors"
 	"fmt"
 	"github.com/robfig/revel"
 	m "smart-kids/models"
 	"smart-kids/util"
-	"strings"
+	"time"
 )
 
 var (
 	appByKeySql = fmt.Sprintf(simpleQueryTpl, m.AppFields, m.APP_TABLE, m.F_APP_KEY)
-
-	loaderFuncErr = errors.New("Loader function return is nil.")
 )
 
 type OAuth struct {
 	*Application
 }
 
-func (o OAuth) getAppSession(appId int64, loader func(int64) *m.AppSession) *m.AppSession {
-	appSession := m.ToAppSession(o.Txn.Get(m.AppSession{}, appId))
-	if appSession == nil {
-		appSession = loader(appId)
-		if appSession == nil {
-			panic(loaderFuncErr)
+// Returns the lifetime of the config key in seconds.
+func oauthExpires(key string, defaultSeconds int) time.Duration {
+	return time.Duration(revel.Config.IntDefault(key, defaultSeconds)) * time.Second
+}
+
+// Returns the grant of the app to the user, a new one is inserted if the
+// user has not authorized the app.
+func (o OAuth) getAppGrant(app *m.App, user *m.User) *m.AppGrant {
+	appGrant := m.ToAppGrant(o.Txn.Get(m.AppGrant{}, app.Id, user.UserId))
+	if appGrant == nil {
+		appGrant = m.NewAppGrant(app, user)
+		if err := o.Txn.Insert(appGrant); err != nil {
+			panic(err)
 		}
 	}
-	return appSession
+	return appGrant
 }
 
-func (o OAuth) getAppSessionIfPresent(appId int64) *m.AppSession {
-	return m.ToAppSession(o.Txn.Get(m.AppSession{}, appId))
+// Renders the token endpoint response, which must not be cached.
+func (o OAuth) renderToken(v interface{}) revel.Result {
+	o.Response.Out.Header().Set("Cache-Control", "no-store")
+	o.Response.Out.Header().Set("Pragma", "no-cache")
+	return o.RenderJson(v)
 }
 
-// API authoirze
-func (o OAuth) Authorize() revel.Result {
+// Renders the RFC 6749 error response of the token endpoint.
+func (o OAuth) renderAuthError(err *m.AuthError) revel.Result {
+	o.Response.Status = err.Status()
+	return o.renderToken(err)
+}
+
+// The validated params of an authorization request.
+type authRequest struct {
+	app         *m.App
+	user        *m.User
+	redirectUri string
+	scope       string
+	state       string
+}
+
+// Returns the authorization request of the params, or the error result if
+// the client, the redirect_uri or the scope is invalid, or the request has no
+// valid session.
+func (o OAuth) authRequest() (*authRequest, revel.Result) {
 	clientId, _ := o.GetClientInfo()
 	if len(clientId) == 0 {
-		return o.RenderJson(m.Err_Invalid_Client)
+		return nil, o.RenderJson(m.Err_Invalid_Client)
 	}
 
 	app := m.ToApp(o.Txn.Select(m.App{}, appByKeySql, clientId))
 	if app == nil {
-		return o.RenderJson(m.Err_Invalid_Client)
+		return nil, o.RenderJson(m.Err_Invalid_Client)
 	}
 
+	// the code is never redirected to a host not registered by the app
 	redirectUri := o.Params.Get("redirect_uri")
-	if len(redirectUri) == 0 {
-		return o.RenderJson(m.Err_Redirect_URI_Mismatch)
+	if len(redirectUri) == 0 || !app.MatchRedirectUri(redirectUri) {
+		return nil, o.RenderJson(m.Err_Redirect_URI_Mismatch)
 	}
 
-	display := o.Params.Get("display")
-	if len(display) == 0 {
-		display = "default"
+	scope, authErr := m.ParseScope(o.Params.Get("scope"))
+	if authErr != nil {
+		return nil, o.RenderJson(authErr)
 	}
-	state := o.Params.Get("state")
-	forceLogin := false
-	forceLoginStr := o.Params.Get("forcelogin")
-	if len(forceLoginStr) > 0 && "true" == forceLoginStr {
-		forceLogin = true
+	user, err := o.sessionUser()
+	if err != nil {
+		return nil, o.RenderJson(m.Err_access_denied)
 	}
+	return &authRequest{app, user, redirectUri, scope, o.Params.Get("state")}, nil
+}
 
-	appSession := o.getAppSession(app.Id, func(appId int64) *m.AppSession {
-		target := m.NewAppSession(app)
-		row, err := o.Txn.Insert(target)
-		if err != nil {
-			panic(err)
+// API authoirze, the auth code is bound to the user of the current session
+// and the requested scope. The code is issued at once if the scope is granted
+// to the app, otherwise the consent is rendered, the user approves or denies
+// it by Approve.
+func (o OAuth) Authorize() revel.Result {
+	req, result := o.authRequest()
+	if result != nil {
+		return result
+	}
+	appGrant := m.ToAppGrant(o.Txn.Get(m.AppGrant{}, req.app.Id, req.user.UserId))
+	if appGrant == nil || !appGrant.Covers(req.scope) {
+		granted := ""
+		if appGrant != nil {
+			granted = appGrant.Scope
 		}
-		return target
-	})
+		return o.RenderJson(util.SuccessResult(o.Message("oauth.consentRequired", req.app.Name)).
+			AddValue("consent", true).
+			AddValue("app", req.app.Name).
+			AddValue("scope", req.scope).
+			AddValue("granted", granted))
+	}
+	return o.redirectAuthCode(req, appGrant)
+}
 
-	code := appSession.FlushAuthCode()
-	redirectUrl := util.AddParamsToUrl(redirectUri, map[string]string{
-		"state": state,
+// Approves or denies the authorization requested with the same params by the
+// user of the current session, the scope of an existing grant is widened only
+// if approved.
+func (o OAuth) Approve(approved bool) revel.Result {
+	req, result := o.authRequest()
+	if result != nil {
+		return result
+	}
+	if !approved {
+		return o.Redirect(util.AddParamsToUrl(req.redirectUri, map[string]string{
+			"state": req.state,
+			"error": m.Err_access_denied.Error,
+		}))
+	}
+	return o.redirectAuthCode(req, o.getAppGrant(req.app, req.user))
+}
+
+// Flushes the auth code of the request and redirects to its redirect_uri.
+func (o OAuth) redirectAuthCode(req *authRequest, appGrant *m.AppGrant) revel.Result {
+	code := appGrant.NewAuthCode(req.redirectUri, req.scope, oauthExpires("oauth.code_expires", 600))
+	if _, err := o.Txn.Update(appGrant); err != nil {
+		panic(err)
+	}
+	redirectUrl := util.AddParamsToUrl(req.redirectUri, map[string]string{
+		"state": req.state,
 		"code":  code,
 	})
 	return o.Redirect(redirectUrl)
 }
 
+// Exchanges the auth code of the `authorization_code` grant or the refresh
+// token of the `refresh_token` grant for a new access token and refresh token.
 func (o OAuth) AccessToken() revel.Result {
 	appKey, appSecret := o.GetClientInfo()
 	if len(appKey) == 0 || len(appSecret) == 0 {
-		return o.RenderJson(m.Err_Invalid_Client)
+		return o.renderAuthError(m.Err_Invalid_Client)
 	}
 	app := m.ToApp(o.Txn.Select(m.App{}, appByKeySql, appKey))
-	if app == nil {
-		return o.RenderJson(m.Err_Invalid_Client)
-	}
-    grantType := o.Params.Get("grant_type")
-    if len(grantType) == 0 || grantType != "authorization_code" {
-    	return o.RenderJson(m.Err_unsupported_grant_type)
-    }
-
-    code := o.Params.Get("code")
-    appSession := o.getAppSessionIfPresent(app.Id)
-    if len(code) == 0 || code != appSession.AppAuthCode {
-    	return o.RenderJson(m.Err_Invalid_Request)
-    }
-	return nil
+	if app == nil || app.AppSecret != appSecret {
+		return o.renderAuthError(m.Err_Invalid_Client)
+	}
+
+	var appGrant *m.AppGrant
+	var authErr *m.AuthError
+	code, refreshToken := o.Params.Get("code"), o.Params.Get("refresh_token")
+	switch grantType := o.Params.Get("grant_type"); grantType {
+	case m.GRANT_AUTHORIZATION_CODE:
+		if appGrant = m.FindAppGrantByCode(o.Txn, app.Id, code); appGrant != nil {
+			authErr = appGrant.UseAuthCode(code, o.Params.Get("redirect_uri"))
+		}
+	case m.GRANT_REFRESH_TOKEN:
+		if appGrant = m.FindAppGrantByRefreshToken(o.Txn, app.Id, refreshToken); appGrant != nil {
+			authErr = appGrant.UseRefreshToken(refreshToken)
+		}
+	case "":
+		return o.renderAuthError(m.Err_Invalid_Request)
+	default:
+		return o.renderAuthError(m.Err_unsupported_grant_type)
+	}
+	if appGrant == nil {
+		return o.renderAuthError(m.Err_Invalid_Grant)
+	}
+	var accessToken string
+	if authErr == nil {
+		accessToken, refreshToken = appGrant.IssueTokens(oauthExpires("oauth.token_expires", 7200),
+			oauthExpires("oauth.refresh_expires", 30*24*3600))
+	}
+	// the expired code is cleared as well, the transaction is committed
+	// for the error response.
+	if _, err := o.Txn.Update(appGrant); err != nil {
+		panic(err)
+	}
+	if authErr != nil {
+		return o.renderAuthError(authErr)
+	}
+	return o.renderToken(appGrant.TokenResponse(accessToken, refreshToken))
 }
@@ -0,0 +1,243 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	"smart-kids/lunar"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+// The profile params of UpdateProfile, bound from "profile.<Field>",
+// zero id means not selected.
+type ProfileForm struct {
+	Nickname     string
+	CalendarMode int16  // 0: solar, 1: lunar
+	DateOfBirth  string // 2006-01-02, or lunar.Date format if lunar
+	OtherState   string
+	HtCountryId  uint
+	HtStateId    uint
+	HtCityId     uint
+	HtDistId     uint
+	PorCountryId uint
+	PorStateId   uint
+	PorCityId    uint
+	PorDistId    uint
+	EduId        uint16
+	FeelingId    uint16
+	BloodTypeId  uint16
+}
+
+// The Visibility codes of the profile sections, bound from "visibility.<Field>".
+type ProfileVisibilityForm struct {
+	Birthday  uint16
+	Hometown  uint16
+	Residence uint16
+	Education uint16
+	Feeling   uint16
+	BloodType uint16
+}
+
+// Returns the UserInfo of the specified user, or nil if not exists.
+func (u Users) findUserInfo(userId uint64) *m.UserInfo {
+	return m.ToUserInfo(u.Txn.Get(m.UserInfo{}, userId))
+}
+
+// Returns the profile of the current session's user, the dictionary
+// ids are resolved to the objects.
+func (u Users) Profile() revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	userInfo := u.findUserInfo(user.UserId)
+	if userInfo == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("profile", userInfo).
+		AddValue("age", userInfo.Age()).
+		AddValue("ageBand", userInfo.AgeBand()))
+}
+
+// Updates the profile of the current session's user, every dictionary id
+// must exist and the locations must be in their parents. The constellation
+// is derived from the date of birth.
+func (u Users) UpdateProfile(profile ProfileForm) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	userInfo := u.findUserInfo(user.UserId)
+	if userInfo == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+
+	builder := m.NewUserInfoBuilder(userInfo)
+	profile.Nickname = strings.TrimSpace(profile.Nickname)
+	u.Validation.MaxSize([]rune(profile.Nickname), 20).Key("profile.Nickname").
+		Message(u.Message("profile.v.nicknameLength", 20))
+	builder.Nickname(profile.Nickname)
+	profile.OtherState = strings.TrimSpace(profile.OtherState)
+	u.Validation.MaxSize([]rune(profile.OtherState), 100).Key("profile.OtherState").
+		Message(u.Message("profile.v.otherStateLength", 100))
+	builder.OtherState(profile.OtherState)
+
+	u.setDateOfBirth(builder, profile.CalendarMode, strings.TrimSpace(profile.DateOfBirth))
+
+	hometown, err := m.LoadLocation(u.Txn, profile.HtCountryId, profile.HtStateId, profile.HtCityId, profile.HtDistId)
+	if err == m.InvalidLocationError {
+		u.Validation.Error(u.Message("profile.v.hometown")).Key("profile.Hometown")
+	} else if err != nil {
+		panic(err)
+	}
+	builder.Hometown(hometown)
+	por, err := m.LoadLocation(u.Txn, profile.PorCountryId, profile.PorStateId, profile.PorCityId, profile.PorDistId)
+	if err == m.InvalidLocationError {
+		u.Validation.Error(u.Message("profile.v.placeOfResidence")).Key("profile.PlaceOfResidence")
+	} else if err != nil {
+		panic(err)
+	}
+	builder.PlaceOfResidence(por)
+
+	builder.Education(nil).Feeling(nil).BloodType(nil)
+	if profile.EduId > 0 {
+		if edu := m.EducationOf(profile.EduId, nil); edu == m.EDU_Unknown {
+			u.Validation.Error(u.Message("profile.v.education")).Key("profile.EduId")
+		} else {
+			builder.Education(edu)
+		}
+	}
+	if profile.FeelingId > 0 {
+		if feeling := m.FeelingOf(profile.FeelingId, nil); feeling == m.FL_Unknown {
+			u.Validation.Error(u.Message("profile.v.feeling")).Key("profile.FeelingId")
+		} else {
+			builder.Feeling(feeling)
+		}
+	}
+	if profile.BloodTypeId > 0 {
+		if bloodType := m.BloodTypeOf(profile.BloodTypeId, nil); bloodType == m.BL_Unknown {
+			u.Validation.Error(u.Message("profile.v.bloodType")).Key("profile.BloodTypeId")
+		} else {
+			builder.BloodType(bloodType)
+		}
+	}
+
+	if u.Validation.HasErrors() {
+		result := util.FailureResult(u.Message("profile.v.updateFailed"))
+		for k, v := range u.Validation.ErrorMap() {
+			if v != nil {
+				result.AddValue(k, v.Message)
+			}
+		}
+		return u.RenderJson(result)
+	}
+	if _, err = u.Txn.Update(builder.Builder()); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("profile.s.updated")).
+		AddValue("profile", userInfo))
+}
+
+// Sets the date of birth of the calendar mode, the lunar date is stored
+// with its solar date.
+func (u Users) setDateOfBirth(builder *m.UserInfoBuilder, mode int16, dateOfBirth string) {
+	if len(dateOfBirth) == 0 {
+		builder.DateOfBirth(time.Time{}, m.CALENDAR_SOLAR)
+		return
+	}
+	switch mode {
+	case m.CALENDAR_SOLAR:
+		solar, err := time.Parse(m.DATE_OF_BIRTH_LAYOUT, dateOfBirth)
+		if err == nil && !solar.After(time.Now()) {
+			builder.DateOfBirth(solar, mode)
+			return
+		}
+	case m.CALENDAR_LUNAR:
+		lunarDate, err := lunar.Parse(dateOfBirth)
+		if err == nil {
+			if solar, _ := lunarDate.ToSolar(); !solar.After(time.Now()) {
+				builder.LunarDateOfBirth(lunarDate)
+				return
+			}
+		}
+	}
+	u.Validation.Error(u.Message("profile.v.dateOfBirth")).Key("profile.DateOfBirth")
+}
+
+// Returns the profile of the specified user, the sections are filtered by
+// their visibilities for the current session's user, or anyone if not logged in.
+func (u Users) ViewProfile(userId uint64) revel.Result {
+	var viewerId uint64
+	if session := u.currentSession(); session != nil {
+		viewerId = session.UserId
+	}
+	userInfo := u.findUserInfo(userId)
+	if userInfo == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	isFriend := m.AreFriends(u.Txn, viewerId, userId)
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("profile", m.NewProfileView(userInfo, viewerId, isFriend)))
+}
+
+// Updates the visibilities of the profile sections of the current session's
+// user, every code must be V_ALL, V_FRIENDS or V_SELF.
+func (u Users) UpdateProfileVisibility(visibility ProfileVisibilityForm) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	userInfo := u.findUserInfo(user.UserId)
+	if userInfo == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	codes := map[string]uint16{
+		"visibility.Birthday":  visibility.Birthday,
+		"visibility.Hometown":  visibility.Hometown,
+		"visibility.Residence": visibility.Residence,
+		"visibility.Education": visibility.Education,
+		"visibility.Feeling":   visibility.Feeling,
+		"visibility.BloodType": visibility.BloodType,
+	}
+	for key, code := range codes {
+		if !m.IsProfileVisibility(code) {
+			u.Validation.Error(u.Message("profile.v.visibility")).Key(key)
+		}
+	}
+	if u.Validation.HasErrors() {
+		result := util.FailureResult(u.Message("profile.v.updateFailed"))
+		for k, v := range u.Validation.ErrorMap() {
+			if v != nil {
+				result.AddValue(k, v.Message)
+			}
+		}
+		return u.RenderJson(result)
+	}
+	userInfo.BirthdayVCode, userInfo.HometownVCode = visibility.Birthday, visibility.Hometown
+	userInfo.ResidenceVCode, userInfo.EduVCode = visibility.Residence, visibility.Education
+	userInfo.FeelingVCode, userInfo.BloodTypeVCode = visibility.Feeling, visibility.BloodType
+	if _, err := u.Txn.Update(userInfo); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("profile.s.updated")).
+		AddValue("profile", userInfo))
+}
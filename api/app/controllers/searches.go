@@ -0,0 +1,61 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"strings"
+)
+
+const (
+	searchPageSize = 20
+)
+
+// Returns the pageable of the user search sorted by the comma separated
+// properties of m.UserSortColumns, the newest users first if sort is empty.
+func userSearchPageable(p int, sort, direction string) (*util.Pageable, error) {
+	if p <= 0 {
+		p = 1
+	}
+	if len(strings.TrimSpace(sort)) == 0 {
+		return util.NewPageable0(p, searchPageSize, nil)
+	}
+	if len(direction) == 0 {
+		direction = util.ASC
+	}
+	userSort, err := m.NewUserSort(direction, strings.Split(strings.Replace(sort, " ", "", -1), ","))
+	if err != nil {
+		return nil, err
+	}
+	return util.NewPageable0(p, searchPageSize, userSort)
+}
+
+// Searches the public user directory by the current session's user, the
+// email, the activation and the ban status can not be filtered.
+func (u Users) Search(search m.UserSearch, p int, sort, dir string) revel.Result {
+	if _, err := u.sessionUser(); err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	pageable, err := userSearchPageable(p, sort, dir)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(u.Message("search.v.sort")))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("users", m.SearchUsers(u.Txn, search.Public(), pageable)))
+}
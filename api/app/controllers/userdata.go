@@ -0,0 +1,75 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	m "smart-kids/models"
+	"smart-kids/util"
+)
+
+// Returns the latest data requests of the current session's user.
+func (u Users) DataRequests() revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("requests", m.FindUserDataRequests(u.Txn, user.UserId)))
+}
+
+// Requests to export the personal data of the current session's user, the
+// link of the archive is mailed when it's ready.
+func (u Users) RequestDataExport() revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	if m.FindPendingDataRequest(u.Txn, user.UserId, m.DATA_EXPORT) != nil {
+		return u.RenderJson(util.FailureResult(u.Message("data.exportPending")))
+	}
+	request := m.NewUserDataRequest(user, m.DATA_EXPORT)
+	if err = u.Txn.Insert(request); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("data.s.exportRequested", user.Email)).
+		AddValue("request", request))
+}
+
+// Requests to delete the account of the current session's user, confirmed
+// by the login password. The inner users can not be deleted.
+func (u Users) RequestAccountDeletion(password string) revel.Result {
+	user, err := u.sessionUser()
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	if user.IsInnerUser() {
+		return u.RenderJson(util.FailureResult(u.Message("data.innerUserDeletion")))
+	}
+	if matched, _ := user.MatchPassword(password); !matched {
+		return u.RenderJson(util.FailureResult(u.Message("data.v.password")))
+	}
+	if m.FindPendingDataRequest(u.Txn, user.UserId, m.DATA_DELETION) != nil {
+		return u.RenderJson(util.FailureResult(u.Message("data.deletionPending")))
+	}
+	request := m.NewUserDataRequest(user, m.DATA_DELETION)
+	if err = u.Txn.Insert(request); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("data.s.deletionRequested")).
+		AddValue("request", request))
+}
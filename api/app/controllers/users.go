@@ -17,16 +17,282 @@
 package controllers
 
 import (
-	"fmt"
 	"github.com/robfig/revel"
+	"smart-kids/api/app/routes"
 	m "smart-kids/models"
-	q "smart-kids/query"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+const (
+	activationExpires    = 48 * time.Hour
+	resetPasswordExpires = 30 * time.Minute
+	sessionExpires       = 30 * 24 * time.Hour
+	ledgerPageSize       = 20
 )
 
 type Users struct {
 	*Application
 }
 
-func (u *Users) Register(user *User) revel.Result {
+// Issues an activation token for user and mails the activation link.
+func (u Users) sendActivationMail(user *m.User) error {
+	token := u.issueUserToken(user, m.TOKEN_ACTIVATION, user.Email, activationExpires)
+	link := siteUrl(routes.Users.Activate(token))
+	return sendMail(user.Email, u.Message("mail.activation.subject"),
+		u.Message("mail.activation.body", user.UserName, link, int(activationExpires.Hours())))
+}
+
+// Registers a new user, the User, UserDigital and UserInfo records
+// are inserted in the same transaction.
+func (u Users) Register(user m.User) revel.Result {
+	user.UserName = strings.TrimSpace(user.UserName)
+	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+	user.Validate(u.Validation)
+	if u.Validation.HasErrors() {
+		result := util.FailureResult(u.Message("users.v.registerFailed"))
+		for k, v := range u.Validation.ErrorMap() {
+			if v != nil {
+				result.AddValue(k, v.Message)
+			}
+		}
+		return u.RenderJson(result)
+	}
+	if u.existsUserName(user.UserName) {
+		return u.RenderJson(util.FailureResult(u.Message("users.errorExistName", user.UserName)))
+	}
+	if u.existsEmail(user.Email) {
+		return u.RenderJson(util.FailureResult(u.Message("users.errorExistEmail", user.Email)))
+	}
+
+	user.UserId, user.IsActivated = 0, false
+	if user.Gender == nil {
+		user.Gender = m.GenderOf(user.GenderCode)
+	}
+	if !u.insertUser(user.EncodePassword()) {
+		return u.RenderJson(util.FailureResult(u.Message("users.errorRegister")))
+	}
+	digital := m.NewDigital(&user)
+	userInfo := m.NewUserInfoBuilder(nil).User(&user).Builder()
+	if err := u.Txn.Insert(digital, userInfo); err != nil {
+		panic(err)
+	}
+	// The user may request another mail if this one fails.
+	u.sendActivationMail(&user)
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.registered", user.UserName)).
+		AddValue("user", &user))
+}
+
+// Inserts the new user, returns false if the user still receives a reserved
+// inner id. The reserved ids are raised again if the AUTO_INCREMENT of the user
+// table is reset, and the insert is retried in a new transaction, as the ALTER
+// TABLE commits implicitly.
+func (u Users) insertUser(user *m.User) bool {
+	for retried := false; ; retried = true {
+		user.UserId = 0
+		if err := u.Txn.Insert(user); err != nil {
+			panic(err)
+		}
+		if !user.IsInnerUser() {
+			return true
+		}
+		if err := u.Txn.Rollback(); err != nil {
+			panic(err)
+		}
+		if retried {
+			return false
+		}
+		if _, err := m.ReserveInnerUserIds(Dbm); err != nil {
+			panic(err)
+		}
+		txn, err := Dbm.Begin()
+		if err != nil {
+			panic(err)
+		}
+		u.Txn = txn
+	}
+}
+
+// Activates the user of the specified activation token.
+func (u Users) Activate(token string) revel.Result {
+	userToken, err := u.consumeUserToken(token, m.TOKEN_ACTIVATION)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	user := u.findUser(userToken.UserId)
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	if !user.IsActivated {
+		user.IsActivated = true
+		if _, err = u.Txn.Update(user); err != nil {
+			panic(err)
+		}
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.activated", user.UserName)))
+}
+
+// Sends a new activation mail to the not activated user of the specified email.
+func (u Users) ResendActivation(email string) revel.Result {
+	user := u.findUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	if user.IsActivated {
+		return u.RenderJson(util.FailureResult(u.Message("users.alreadyActivated", user.UserName)))
+	}
+	if user.IsInnerUser() {
+		return u.RenderJson(util.FailureResult(u.Message("users.disabledInnerUser", user.UserName)))
+	}
+	if err := u.sendActivationMail(user); err != nil {
+		return u.RenderJson(util.ErrorResult(u.Message("users.errorSendMail")))
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.activationSent", user.Email)))
+}
+
+// Sends a reset password link to the primary email of the user of the specified
+// user name or email, or to the verified spare email if spare is true.
+func (u Users) ForgotPassword(account string, spare bool) revel.Result {
+	var user *m.User
+	account = strings.TrimSpace(account)
+	if strings.Contains(account, "@") {
+		user = u.findUserByEmail(strings.ToLower(account))
+	} else if len(account) > 0 {
+		user = u.findUserByName(account)
+	}
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	email := user.Email
+	if spare {
+		if !user.SpareEmail.Valid || len(user.SpareEmail.String) == 0 {
+			return u.RenderJson(util.FailureResult(u.Message("users.noSpareEmail")))
+		}
+		if !user.IsSpareVerified { // never mail a token to an unverified address
+			return u.RenderJson(util.FailureResult(u.Message("users.spareEmailNotVerified")))
+		}
+		email = user.SpareEmail.String
+	}
+	token := u.issueUserToken(user, m.TOKEN_RESET_PASSWORD, email, resetPasswordExpires)
+	link := util.AddParamsToUrl(revel.Config.StringDefault("site.resetPasswordUrl",
+		siteUrl("/reset_password")), map[string]string{"token": token})
+	err := sendMail(email, u.Message("mail.resetPassword.subject"),
+		u.Message("mail.resetPassword.body", user.UserName, link, int(resetPasswordExpires.Minutes())))
+	if err != nil {
+		return u.RenderJson(util.ErrorResult(u.Message("users.errorSendMail")))
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.resetPasswordSent")))
+}
+
+// Sets a new password for the user of the specified reset password token.
+func (u Users) ResetPassword(token, password string) revel.Result {
+	m.ValidatePassword(u.Validation, password).Key("password")
+	if u.Validation.HasErrors() {
+		result := util.FailureResult(u.Message("users.v.resetPasswordFailed"))
+		for k, v := range u.Validation.ErrorMap() {
+			if v != nil {
+				result.AddValue(k, v.Message)
+			}
+		}
+		return u.RenderJson(result)
+	}
+	userToken, err := u.consumeUserToken(token, m.TOKEN_RESET_PASSWORD)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	user := u.findUser(userToken.UserId)
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	user.Password = password
+	if _, err = u.Txn.Update(user.EncodePassword()); err != nil {
+		panic(err)
+	}
+	// the sessions logged in by the old password are revoked
+	if _, err = m.DeleteUserSessions(u.Txn, user.UserId); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.passwordReset", user.UserName)))
+}
+
+// Logs in the user of the specified user name or email and password from
+// the client of the specified code, returns a new session token.
+func (u Users) Login(account, password string, client uint16) revel.Result {
+	if len(strings.TrimSpace(account)) == 0 || len(password) == 0 {
+		return u.RenderJson(util.FailureResult(u.Message("users.errorLogin")))
+	}
+	user, err := u.authenticate(account, password)
+	if err != nil {
+		return u.RenderJson(util.FailureResult(err.Error()))
+	}
+	session, token := u.issueUserSession(user, m.ClientOf(client, m.UNKNOWN_CLIENT),
+		sessionExpires)
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.loggedIn", user.UserName)).
+		AddValue(m.PARAM_SESSION_TOKEN, token).
+		AddValue("expired", session.ExpiredTime.Time).
+		AddValue("user", user))
+}
+
+// Logs out the current session.
+func (u Users) Logout() revel.Result {
+	session := u.currentSession()
+	if session == nil {
+		return u.RenderJson(util.FailureResult(u.Message("sessions.invalid")))
+	}
+	if _, err := u.Txn.Delete(session); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult(u.Message("users.s.loggedOut", session.UserName)))
+}
+
+// Returns the user and client of the current session.
+func (u Users) Me() revel.Result {
+	session := u.currentSession()
+	if session == nil {
+		return u.RenderJson(util.FailureResult(u.Message("sessions.invalid")))
+	}
+	user := u.findUser(session.UserId)
+	if user == nil {
+		return u.RenderJson(util.FailureResult(u.Message("users.notFound")))
+	}
+	if _, err := u.Txn.Update(session.Touch()); err != nil {
+		panic(err)
+	}
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("user", user).
+		AddValue("session", session))
+}
+
+// Returns the score ledgers of the current session's user, the newest first.
+func (u Users) Scores(p int) revel.Result {
+	session := u.currentSession()
+	if session == nil {
+		return u.RenderJson(util.FailureResult(u.Message("sessions.invalid")))
+	}
+	if p <= 0 {
+		p = 1
+	}
+	ledgers, total := m.FindScoreLedgers(u.Txn, session.UserId, (p-1)*ledgerPageSize, ledgerPageSize)
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("ledgers", ledgers).
+		AddValue("total", total))
+}
 
+// Returns the wallet statement of the current session's user, the newest first.
+func (u Users) Wallet(p int) revel.Result {
+	session := u.currentSession()
+	if session == nil {
+		return u.RenderJson(util.FailureResult(u.Message("sessions.invalid")))
+	}
+	if p <= 0 {
+		p = 1
+	}
+	pageable, err := util.NewPageable(p, ledgerPageSize, util.DESC, []string{m.F_ID})
+	if err != nil {
+		panic(err)
+	}
+	statement := m.FindWalletStatement(u.Txn, session.UserId, pageable)
+	return u.RenderJson(util.SuccessResult("").
+		AddValue("statement", statement))
 }
@@ -0,0 +1,166 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package archive packs JSON documents into a zip archive, the manifest.json
+// of the archive describes every document and its sha256 checksum.
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	MANIFEST_NAME = "manifest.json"
+)
+
+var (
+	InvalidNameError = errors.New("archive: invalid or duplicate document name.")
+)
+
+// Entry describes a document of the archive.
+type Entry struct {
+	Name   string `json:"name"`
+	Count  int    `json:"count"` // the number of records, 1 if it's not a slice
+	Size   int    `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// Manifest describes the archive and its documents.
+type Manifest struct {
+	Title       string    `json:"title"`
+	CreatedTime time.Time `json:"created"`
+	Entries     []*Entry  `json:"entries"`
+}
+
+// Archive collects the JSON documents in memory.
+type Archive struct {
+	manifest *Manifest
+	data     map[string][]byte
+}
+
+func New(title string) *Archive {
+	return &Archive{
+		manifest: &Manifest{Title: title, CreatedTime: time.Now()},
+		data:     make(map[string][]byte),
+	}
+}
+
+// Adds the indented JSON document of v, the name must be a unique file name
+// without directories and not be MANIFEST_NAME.
+func (a *Archive) AddJSON(name string, v interface{}) error {
+	if len(name) == 0 || strings.ContainsAny(name, "/\\") || name == MANIFEST_NAME {
+		return InvalidNameError
+	}
+	if _, exists := a.data[name]; exists {
+		return InvalidNameError
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	a.data[name] = data
+	a.manifest.Entries = append(a.manifest.Entries, &Entry{
+		Name: name, Count: countOf(v), Size: len(data), Sha256: hex.EncodeToString(sum[:]),
+	})
+	return nil
+}
+
+// Returns the manifest of the added documents.
+func (a *Archive) Manifest() *Manifest {
+	return a.manifest
+}
+
+// Returns the zip archive of the documents in the added order, followed by
+// the manifest.
+func (a *Archive) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range a.manifest.Entries {
+		if err := writeFile(w, entry.Name, a.data[entry.Name]); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFile(w, MANIFEST_NAME, manifest); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Reads the manifest of the zip archive data.
+func ReadManifest(data []byte) (*Manifest, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range r.File {
+		if f.Name != MANIFEST_NAME {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		manifest := &Manifest{}
+		if err = json.NewDecoder(rc).Decode(manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+	return nil, errors.New("archive: no " + MANIFEST_NAME + ".")
+}
+
+func writeFile(w *zip.Writer, name string, data []byte) error {
+	f, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// Returns the length of the slice, 0 if v is nil, otherwise 1.
+func countOf(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return value.Len()
+	case reflect.Ptr, reflect.Map:
+		if value.IsNil() {
+			return 0
+		}
+	}
+	return 1
+}
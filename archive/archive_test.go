@@ -0,0 +1,78 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+type record struct {
+	Id   int
+	Name string
+}
+
+func TestArchive(t *testing.T) {
+	a := New("kid")
+	var missing *record
+	for name, v := range map[string]interface{}{
+		"account.json": &record{1, "kid"},
+		"photos.json":  []*record{{1, "a"}, {2, "b"}},
+		"info.json":    missing,
+	} {
+		if err := a.AddJSON(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"", "photos.json", MANIFEST_NAME, "../a.json", "a/b.json"} {
+		if err := a.AddJSON(name, 1); err != InvalidNameError {
+			t.Errorf("The name %q must be invalid, actual: %v", name, err)
+		}
+	}
+	data, err := a.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Title != "kid" || len(manifest.Entries) != 3 {
+		t.Fatalf("The manifest is error, actual: %+v", manifest)
+	}
+	counts := map[string]int{"account.json": 1, "photos.json": 2, "info.json": 0}
+	r, _ := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	files := make(map[string][]byte)
+	for _, f := range r.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+	for _, entry := range manifest.Entries {
+		if entry.Count != counts[entry.Name] {
+			t.Errorf("The count of %s should be %d, actual: %d", entry.Name, counts[entry.Name], entry.Count)
+		}
+		sum := sha256.Sum256(files[entry.Name])
+		if entry.Size != len(files[entry.Name]) || entry.Sha256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("The checksum of %s is error", entry.Name)
+		}
+	}
+}
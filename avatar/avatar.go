@@ -0,0 +1,177 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package avatar validates the uploaded avatar images and generates the
+// square renditions of the user avatar sizes.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	LARGE_SIZE = 120 // User.AvatarUri
+	SMALL_SIZE = 50  // User.SmallAvatarUri
+	THUMB_SIZE = 25  // User.ThumbAvatarUri
+
+	MAX_DIMENSION = 4096
+	JPEG_QUALITY  = 90
+)
+
+var (
+	UnsupportedTypeError = errors.New("avatar: only JPEG, PNG and GIF images are supported.")
+	TooLargeError        = errors.New("avatar: the image file is too large.")
+	DimensionError       = errors.New("avatar: the image dimensions are invalid.")
+
+	// The supported content types and their image.Decode format names.
+	formats = map[string]string{
+		"image/jpeg": "jpeg",
+		"image/png":  "png",
+		"image/gif":  "gif",
+	}
+
+	// The rendition sizes, from large to thumb.
+	Sizes = []int{LARGE_SIZE, SMALL_SIZE, THUMB_SIZE}
+)
+
+// An encoded square image of the avatar.
+type Rendition struct {
+	Size        int
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// Returns the content type detected from the file content, the uploaded
+// file name and the request header are not trusted.
+func ContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := formats[contentType]; !ok {
+		return contentType, UnsupportedTypeError
+	}
+	return contentType, nil
+}
+
+// Validates the uploaded image and returns its renditions of Sizes. JPEG
+// images are encoded as JPEG, the others as PNG to keep the transparency.
+func Process(data []byte, maxBytes int) ([]*Rendition, error) {
+	if len(data) > maxBytes {
+		return nil, TooLargeError
+	}
+	contentType, err := ContentType(data)
+	if err != nil {
+		return nil, err
+	}
+	// Checks the dimensions before decoding the pixels.
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != formats[contentType] {
+		return nil, UnsupportedTypeError
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		config.Width > MAX_DIMENSION || config.Height > MAX_DIMENSION {
+		return nil, DimensionError
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, UnsupportedTypeError
+	}
+
+	square := CropSquare(img)
+	renditions := make([]*Rendition, 0, len(Sizes))
+	for _, size := range Sizes {
+		r := &Rendition{Size: size, ContentType: "image/png", Ext: "png"}
+		if contentType == "image/jpeg" {
+			r.ContentType, r.Ext = "image/jpeg", "jpg"
+		}
+		if r.Data, err = encode(Resize(square, size), r.ContentType); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, nil
+}
+
+// Returns the largest centered square of the image.
+func CropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	min := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, min, draw.Src)
+	return square
+}
+
+// Resizes the square image to size x size by averaging the source pixels
+// covered by every target pixel.
+func Resize(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, size, side)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, size, side)
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// Returns the source range [from, to) of the target pixel i, at least one pixel.
+func span(i, size, side int) (int, int) {
+	from, to := i*side/size, (i+1)*side/size
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEG_QUALITY})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avatar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// Returns a w x h image, red on the left half and blue on the right half.
+func newImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestProcess(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, newImage(300, 200))
+	renditions, err := Process(buf.Bytes(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renditions) != len(Sizes) {
+		t.Fatalf("Process must return %d renditions, actual: %d", len(Sizes), len(renditions))
+	}
+	for i, r := range renditions {
+		img, err := png.Decode(bytes.NewReader(r.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size != Sizes[i] || r.ContentType != "image/png" ||
+			img.Bounds().Dx() != Sizes[i] || img.Bounds().Dy() != Sizes[i] {
+			t.Errorf("The rendition %d is error, actual: %d x %d", Sizes[i], img.Bounds().Dx(), img.Bounds().Dy())
+		}
+	}
+}
+
+func TestProcessJpegAndGif(t *testing.T) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, newImage(64, 64), nil)
+	if renditions, err := Process(buf.Bytes(), 1<<20); err != nil || renditions[0].Ext != "jpg" {
+		t.Errorf("JPEG must be encoded as jpg, actual: %v", err)
+	}
+	buf.Reset()
+	gif.Encode(&buf, newImage(20, 30), nil)
+	if renditions, err := Process(buf.Bytes(), 1<<20); err != nil || renditions[0].Ext != "png" {
+		t.Errorf("GIF must be encoded as png, actual: %v", err)
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	if _, err := Process([]byte("<html><body>not image</body></html>"), 1<<20); err != UnsupportedTypeError {
+		t.Errorf("The html must be unsupported, actual: %v", err)
+	}
+	if _, err := Process(make([]byte, 100), 10); err != TooLargeError {
+		t.Errorf("The file must be too large, actual: %v", err)
+	}
+	// The PNG signature followed by garbage.
+	if _, err := Process([]byte("\x89PNG\r\n\x1a\nbroken"), 1<<20); err != UnsupportedTypeError {
+		t.Errorf("The broken png must be unsupported, actual: %v", err)
+	}
+}
+
+func TestCropSquareAndResize(t *testing.T) {
+	square := CropSquare(newImage(300, 100))
+	if square.Bounds().Dx() != 100 || square.Bounds().Dy() != 100 {
+		t.Fatalf("CropSquare must keep the shorter side, actual: %v", square.Bounds())
+	}
+	small := Resize(square, 2)
+	if c := small.RGBAAt(0, 0); c.R != 255 || c.B != 0 {
+		t.Errorf("The left pixel must be red, actual: %v", c)
+	}
+	if c := small.RGBAAt(1, 0); c.R != 0 || c.B != 255 {
+		t.Errorf("The right pixel must be blue, actual: %v", c)
+	}
+}
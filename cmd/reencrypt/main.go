@@ -0,0 +1,129 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command reencrypt re-encrypts the personal data of UserIdentity by the
+// current key, it must be run after the current key is rotated, and once
+// to encrypt the legacy plaintext data.
+//
+//	reencrypt -dsn "user:pass@tcp(127.0.0.1:3306)/smart_kids?charset=utf8" \
+//		-keys "1:<base64 key>,2:<base64 key>" -current 2 -index_key "<base64 key>"
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	_ "github.com/go-sql-driver/mysql"
+	"log"
+	"smart-kids/crypt"
+	"smart-kids/models"
+)
+
+var (
+	dsn      = flag.String("dsn", "", "the mysql data source name")
+	keys     = flag.String("keys", "", "the crypt.keys config")
+	current  = flag.Uint("current", 1, "the crypt.current config")
+	indexKey = flag.String("index_key", "", "the crypt.index_key config")
+	batch    = flag.Int("batch", 500, "the number of rows loaded at a time")
+
+	identityBatchSql = fmt.Sprintf("SELECT %s, %s, %s, %s FROM %s WHERE %s > ? ORDER BY %s LIMIT ?",
+		models.F_USER_ID, models.F_READ_NAME, models.F_IDCARD, models.F_IDCARD_HASH,
+		models.USER_IDENTITY_TABLE, models.F_USER_ID, models.F_USER_ID)
+)
+
+// The stored columns of UserIdentity, loaded without decrypted by the hooks.
+type storedIdentity struct {
+	UserId     uint64 `db:"user_id"`
+	RealName   string `db:"real_name"`
+	Idcard     string `db:"idcard"`
+	IdcardHash string `db:"idcard_hash"`
+}
+
+func main() {
+	flag.Parse()
+	keyring := newKeyring()
+	crypt.SetDefault(keyring)
+
+	db, err := sql.Open("mysql", *dsn)
+	if err != nil {
+		log.Fatalf("Open database error: %s", err.Error())
+	}
+	defer db.Close()
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	dbm.AddTableWithName(models.UserIdentity{}, models.USER_IDENTITY_TABLE).SetKeys(false, "UserId")
+
+	var lastId uint64
+	var total, updated int
+	for {
+		rows, err := dbm.Select(storedIdentity{}, identityBatchSql, lastId, *batch)
+		if err != nil {
+			log.Fatalf("Load identities error: %s", err.Error())
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			stored := row.(*storedIdentity)
+			lastId = stored.UserId
+			total++
+			if keyring.NeedsReencrypt(stored.RealName) || keyring.NeedsReencrypt(stored.Idcard) ||
+				len(stored.IdcardHash) == 0 {
+				reencrypt(dbm, stored.UserId)
+				updated++
+			}
+		}
+		log.Printf("%d identities checked, %d re-encrypted", total, updated)
+	}
+	log.Printf("Done, %d identities checked, %d re-encrypted", total, updated)
+}
+
+func newKeyring() *crypt.Keyring {
+	parsedKeys, err := crypt.ParseKeys(*keys)
+	if err != nil {
+		log.Fatalf("Invalid keys: %s", err.Error())
+	}
+	parsedIndexKey, err := base64.StdEncoding.DecodeString(*indexKey)
+	if err != nil {
+		log.Fatalf("Invalid index_key: %s", err.Error())
+	}
+	keyring, err := crypt.NewKeyring(uint32(*current), parsedKeys, parsedIndexKey)
+	if err != nil {
+		log.Fatalf("Invalid keyring: %s", err.Error())
+	}
+	return keyring
+}
+
+// Loads the identity which is decrypted by PostGet, and saves it which is
+// encrypted by the current key in PreUpdate.
+func reencrypt(dbm *gorp.DbMap, userId uint64) {
+	txn, err := dbm.Begin()
+	if err != nil {
+		log.Fatalf("Begin transaction error: %s", err.Error())
+	}
+	identity := models.ToUserIdentity(txn.Get(models.UserIdentity{}, userId))
+	if identity != nil {
+		if _, err = txn.Update(identity); err != nil {
+			txn.Rollback()
+			log.Fatalf("Re-encrypt the identity %d error: %s", userId, err.Error())
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		log.Fatalf("Commit the identity %d error: %s", userId, err.Error())
+	}
+}
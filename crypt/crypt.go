@@ -0,0 +1,241 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crypt encrypts personal data fields at rest with AES-256-GCM.
+//
+// An encrypted value is an envelope of the key version and the sealed data:
+//
+//    enc$<version>$<base64(nonce + ciphertext)>
+//
+// so the key can be rotated: new values are encrypted by the current key,
+// old values are still decrypted by their own key until re-encrypted.
+// A value without the envelope prefix is treated as legacy plaintext.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ENVELOPE_PREFIX = "enc$"
+	KEY_SIZE        = 32
+)
+
+var (
+	NoKeyringError       = errors.New("crypt: the keyring is not set.")
+	InvalidEnvelopeError = errors.New("crypt: invalid envelope.")
+	UnknownKeyError      = errors.New("crypt: unknown key version.")
+
+	encoding = base64.URLEncoding
+
+	defaultKeyring *Keyring
+	defaultLock    sync.RWMutex
+)
+
+// Keyring holds the versioned data keys, the current key encrypts new values.
+// The index key computes the blind index for equality lookup of encrypted values.
+type Keyring struct {
+	keys     map[uint32]cipher.AEAD
+	current  uint32
+	indexKey []byte
+}
+
+// Returns a new Keyring, every key must be 32 bytes and the current version
+// must be one of the keys.
+func NewKeyring(current uint32, keys map[uint32][]byte, indexKey []byte) (*Keyring, error) {
+	if _, exists := keys[current]; !exists {
+		return nil, UnknownKeyError
+	}
+	if len(indexKey) == 0 {
+		return nil, errors.New("crypt: the index key must not be empty.")
+	}
+	k := &Keyring{keys: make(map[uint32]cipher.AEAD), current: current, indexKey: indexKey}
+	for version, key := range keys {
+		if len(key) != KEY_SIZE {
+			return nil, fmt.Errorf("crypt: the key %d must be %d bytes.", version, KEY_SIZE)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[version] = aead
+	}
+	return k, nil
+}
+
+// Parses the keys of the format "1:<base64 key>,2:<base64 key>".
+func ParseKeys(s string) (map[uint32][]byte, error) {
+	keys := make(map[uint32][]byte)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		pair := strings.SplitN(part, ":", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("crypt: invalid key %s.", part)
+		}
+		version, err := strconv.ParseUint(pair[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("crypt: invalid key version %s.", pair[0])
+		}
+		key, err := base64.StdEncoding.DecodeString(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("crypt: invalid key %d: %s", version, err.Error())
+		}
+		keys[uint32(version)] = key
+	}
+	return keys, nil
+}
+
+// Returns true if the value is an encrypted envelope.
+func IsEnvelope(s string) bool {
+	return strings.HasPrefix(s, ENVELOPE_PREFIX)
+}
+
+// Returns the key version of the envelope.
+func versionOf(s string) (uint32, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, ENVELOPE_PREFIX), "$", 2)
+	if len(parts) != 2 {
+		return 0, "", InvalidEnvelopeError
+	}
+	version, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, "", InvalidEnvelopeError
+	}
+	return uint32(version), parts[1], nil
+}
+
+// Encrypts the plaintext by the current key, an empty plaintext stays empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if len(plaintext) == 0 {
+		return "", nil
+	}
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return fmt.Sprintf("%s%d$%s", ENVELOPE_PREFIX, k.current,
+		encoding.EncodeToString(sealed)), nil
+}
+
+// Decrypts the envelope by its key, a legacy plaintext is returned as is.
+func (k *Keyring) Decrypt(s string) (string, error) {
+	if !IsEnvelope(s) {
+		return s, nil
+	}
+	version, data, err := versionOf(s)
+	if err != nil {
+		return "", err
+	}
+	aead, exists := k.keys[version]
+	if !exists {
+		return "", UnknownKeyError
+	}
+	sealed, err := encoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", InvalidEnvelopeError
+	}
+	nonceSize := aead.NonceSize()
+	plain, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", InvalidEnvelopeError
+	}
+	return string(plain), nil
+}
+
+// Returns true if the value is a legacy plaintext or not encrypted by the
+// current key.
+func (k *Keyring) NeedsReencrypt(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	if !IsEnvelope(s) {
+		return true
+	}
+	version, _, err := versionOf(s)
+	return err != nil || version != k.current
+}
+
+// Returns the hex HMAC-SHA256 of the value, equal values have the same index
+// whatever keys encrypted them.
+func (k *Keyring) BlindIndex(value string) string {
+	h := hmac.New(sha256.New, k.indexKey)
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sets the keyring used by the package functions.
+func SetDefault(k *Keyring) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultKeyring = k
+}
+
+// Returns the keyring used by the package functions, or nil if not set.
+func Default() *Keyring {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultKeyring
+}
+
+// Encrypts the plaintext by the default keyring.
+func Encrypt(plaintext string) (string, error) {
+	k := Default()
+	if k == nil {
+		return "", NoKeyringError
+	}
+	return k.Encrypt(plaintext)
+}
+
+// Decrypts the envelope by the default keyring.
+func Decrypt(s string) (string, error) {
+	if !IsEnvelope(s) {
+		return s, nil
+	}
+	k := Default()
+	if k == nil {
+		return "", NoKeyringError
+	}
+	return k.Decrypt(s)
+}
+
+// Returns the blind index of the value by the default keyring.
+func BlindIndex(value string) (string, error) {
+	k := Default()
+	if k == nil {
+		return "", NoKeyringError
+	}
+	return k.BlindIndex(value), nil
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, current uint32) *Keyring {
+	keys := map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, KEY_SIZE),
+		2: bytes.Repeat([]byte{2}, KEY_SIZE),
+	}
+	k, err := NewKeyring(current, keys, []byte("index-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptAndDecrypt(t *testing.T) {
+	k := testKeyring(t, 1)
+	encrypted, err := k.Encrypt("11010519491231002X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc$1$") || strings.Contains(encrypted, "1101051949") {
+		t.Errorf("The envelope is error, actual: %s", encrypted)
+	}
+	if plain, err := k.Decrypt(encrypted); err != nil || plain != "11010519491231002X" {
+		t.Errorf("Decrypt is error, actual: %s, %v", plain, err)
+	}
+	if plain, err := k.Decrypt("legacy"); err != nil || plain != "legacy" {
+		t.Errorf("The legacy plaintext must be returned as is, actual: %s, %v", plain, err)
+	}
+	if encrypted, _ := k.Encrypt(""); encrypted != "" {
+		t.Errorf("The empty plaintext must stay empty, actual: %s", encrypted)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, rotated := testKeyring(t, 1), testKeyring(t, 2)
+	encrypted, _ := old.Encrypt("张三")
+	if !rotated.NeedsReencrypt(encrypted) || !rotated.NeedsReencrypt("张三") {
+		t.Error("The value of the old key or plaintext needs re-encrypt.")
+	}
+	plain, err := rotated.Decrypt(encrypted)
+	if err != nil || plain != "张三" {
+		t.Errorf("The rotated keyring must decrypt the old key, actual: %s, %v", plain, err)
+	}
+	reencrypted, _ := rotated.Encrypt(plain)
+	if rotated.NeedsReencrypt(reencrypted) || old.BlindIndex(plain) != rotated.BlindIndex(plain) {
+		t.Error("The re-encrypted value is error: ", reencrypted)
+	}
+	if _, err = rotated.Decrypt("enc$3$AAAA"); err != UnknownKeyError {
+		t.Errorf("The unknown key version must be error, actual: %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, KEY_SIZE))
+	keys, err := ParseKeys("1:" + key + ", 3:" + key)
+	if err != nil || len(keys) != 2 || len(keys[3]) != KEY_SIZE {
+		t.Errorf("ParseKeys is error, actual: %v, %v", keys, err)
+	}
+	if _, err = ParseKeys("x:" + key); err == nil {
+		t.Error("The invalid key version must be error.")
+	}
+}
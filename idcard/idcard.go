@@ -0,0 +1,91 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idcard validates and parses the 18-digit resident identity card
+// number of the People's Republic of China (GB 11643-1999).
+package idcard
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	ID_LENGTH = 18
+)
+
+var (
+	LengthError   = errors.New("The idcard number must be 18 characters.")
+	FormatError   = errors.New("The idcard number must be 17 digits and a digit or X.")
+	BirthError    = errors.New("The birth date of the idcard number is invalid.")
+	ChecksumError = errors.New("The check code of the idcard number is invalid.")
+
+	weights    = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	checkCodes = "10X98765432"
+	minBirth   = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.Local)
+)
+
+// The information encoded in an idcard number.
+type Idcard struct {
+	Number    string    // upper case
+	Region    string    // the 6-digit administrative division code
+	BirthDate time.Time // the local date of birth
+	Sequence  string    // the 3-digit sequence code
+	IsMale    bool      // the sequence code is odd for male
+}
+
+// Returns the check code of the first 17 digits.
+func CheckCode(digits17 string) byte {
+	sum := 0
+	for i := 0; i < ID_LENGTH-1; i++ {
+		sum += int(digits17[i]-'0') * weights[i]
+	}
+	return checkCodes[sum%11]
+}
+
+// Parses the idcard number, the birth date must be a real date between
+// 1900-01-01 and today, and the check code must match.
+func Parse(number string) (*Idcard, error) {
+	number = strings.ToUpper(strings.TrimSpace(number))
+	if len(number) != ID_LENGTH {
+		return nil, LengthError
+	}
+	for i := 0; i < ID_LENGTH; i++ {
+		c := number[i]
+		if (c < '0' || c > '9') && !(i == ID_LENGTH-1 && c == 'X') {
+			return nil, FormatError
+		}
+	}
+	birth, err := time.ParseInLocation("20060102", number[6:14], time.Local)
+	if err != nil || birth.Before(minBirth) || birth.After(time.Now()) {
+		return nil, BirthError
+	}
+	if CheckCode(number) != number[ID_LENGTH-1] {
+		return nil, ChecksumError
+	}
+	return &Idcard{
+		Number: number, Region: number[:6], BirthDate: birth,
+		Sequence: number[14:17], IsMale: (number[16]-'0')%2 == 1,
+	}, nil
+}
+
+// Returns nil if the idcard number is valid.
+func Validate(number string) error {
+	_, err := Parse(number)
+	return err
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package idcard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	id, err := Parse("11010519491231002x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Number != "11010519491231002X" || id.Region != "110105" || id.IsMale {
+		t.Errorf("Parse result is error, actual: %+v", id)
+	}
+	if id.BirthDate.Year() != 1949 || id.BirthDate.Month() != time.December || id.BirthDate.Day() != 31 {
+		t.Errorf("The birth date is error, actual: %v", id.BirthDate)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	cases := map[string]error{
+		"1101051949123100":   LengthError,
+		"1101051949123100XX": FormatError,
+		"110105194902300020": BirthError,
+		"110105299912310020": BirthError,
+		"110105194912310021": ChecksumError,
+	}
+	for number, expected := range cases {
+		if err := Validate(number); err != expected {
+			t.Errorf("Validate(%s) should be %v, actual: %v", number, expected, err)
+		}
+	}
+}
+
+func TestCheckCode(t *testing.T) {
+	if c := CheckCode("11010519491231002"); c != 'X' {
+		t.Errorf("CheckCode should be X, actual: %c", c)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lunar converts dates between the Gregorian (solar) calendar and
+// the Chinese lunar calendar of the years 1900 - 2100, including leap months.
+package lunar
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MIN_YEAR = 1900
+	MAX_YEAR = 2100
+)
+
+var (
+	OutOfRangeError  = errors.New("lunar: the date is out of range 1900 - 2100.")
+	InvalidDateError = errors.New("lunar: invalid lunar date.")
+
+	// The solar date of the lunar date 1900-01-01.
+	baseDate = time.Date(1900, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+	// The month info of the lunar years 1900 - 2100. The bits 0 - 3 are the
+	// leap month (0 if none), the bits 4 - 15 are the months 12 - 1 which
+	// have 30 days if set, otherwise 29 days, and the bit 16 is set if the
+	// leap month has 30 days.
+	lunarInfo = [...]uint32{
+		0x04bd8, 0x04ae0, 0x0a570, 0x054d5, 0x0d260, 0x0d950, 0x16554, 0x056a0, 0x09ad0, 0x055d2, // 1900-1909
+		0x04ae0, 0x0a5b6, 0x0a4d0, 0x0d250, 0x1d255, 0x0b540, 0x0d6a0, 0x0ada2, 0x095b0, 0x14977, // 1910-1919
+		0x04970, 0x0a4b0, 0x0b4b5, 0x06a50, 0x06d40, 0x1ab54, 0x02b60, 0x09570, 0x052f2, 0x04970, // 1920-1929
+		0x06566, 0x0d4a0, 0x0ea50, 0x16a95, 0x05ad0, 0x02b60, 0x186e3, 0x092e0, 0x1c8d7, 0x0c950, // 1930-1939
+		0x0d4a0, 0x1d8a6, 0x0b550, 0x056a0, 0x1a5b4, 0x025d0, 0x092d0, 0x0d2b2, 0x0a950, 0x0b557, // 1940-1949
+		0x06ca0, 0x0b550, 0x15355, 0x04da0, 0x0a5b0, 0x14573, 0x052b0, 0x0a9a8, 0x0e950, 0x06aa0, // 1950-1959
+		0x0aea6, 0x0ab50, 0x04b60, 0x0aae4, 0x0a570, 0x05260, 0x0f263, 0x0d950, 0x05b57, 0x056a0, // 1960-1969
+		0x096d0, 0x04dd5, 0x04ad0, 0x0a4d0, 0x0d4d4, 0x0d250, 0x0d558, 0x0b540, 0x0b6a0, 0x195a6, // 1970-1979
+		0x095b0, 0x049b0, 0x0a974, 0x0a4b0, 0x0b27a, 0x06a50, 0x06d40, 0x0af46, 0x0ab60, 0x09570, // 1980-1989
+		0x04af5, 0x04970, 0x064b0, 0x074a3, 0x0ea50, 0x06b58, 0x05ac0, 0x0ab60, 0x096d5, 0x092e0, // 1990-1999
+		0x0c960, 0x0d954, 0x0d4a0, 0x0da50, 0x07552, 0x056a0, 0x0abb7, 0x025d0, 0x092d0, 0x0cab5, // 2000-2009
+		0x0a950, 0x0b4a0, 0x0baa4, 0x0ad50, 0x055d9, 0x04ba0, 0x0a5b0, 0x15176, 0x052b0, 0x0a930, // 2010-2019
+		0x07954, 0x06aa0, 0x0ad50, 0x05b52, 0x04b60, 0x0a6e6, 0x0a4e0, 0x0d260, 0x0ea65, 0x0d530, // 2020-2029
+		0x05aa0, 0x076a3, 0x096d0, 0x04afb, 0x04ad0, 0x0a4d0, 0x1d0b6, 0x0d250, 0x0d520, 0x0dd45, // 2030-2039
+		0x0b5a0, 0x056d0, 0x055b2, 0x049b0, 0x0a577, 0x0a4b0, 0x0aa50, 0x1b255, 0x06d20, 0x0ada0, // 2040-2049
+		0x14b63, 0x09370, 0x049f8, 0x04970, 0x064b0, 0x168a6, 0x0ea50, 0x06b20, 0x1a6c4, 0x0aae0, // 2050-2059
+		0x092e0, 0x0d2e3, 0x0c960, 0x0d557, 0x0d4a0, 0x0da50, 0x05d55, 0x056a0, 0x0a6d0, 0x055d4, // 2060-2069
+		0x052d0, 0x0a9b8, 0x0a950, 0x0b4a0, 0x0b6a6, 0x0ad50, 0x055a0, 0x0aba4, 0x0a5b0, 0x052b0, // 2070-2079
+		0x0b273, 0x06930, 0x07337, 0x06aa0, 0x0ad50, 0x14b55, 0x04b60, 0x0a570, 0x054e4, 0x0d160, // 2080-2089
+		0x0e968, 0x0d520, 0x0daa0, 0x16aa6, 0x056d0, 0x04ae0, 0x0a9d4, 0x0a2d0, 0x0d150, 0x0f252, // 2090-2099
+		0x0d520, // 2100
+	}
+)
+
+// A date of the Chinese lunar calendar, IsLeap is true if Month is the
+// leap month of the year.
+type Date struct {
+	Year   int  `json:"year"`
+	Month  int  `json:"month"`
+	Day    int  `json:"day"`
+	IsLeap bool `json:"isLeap"`
+}
+
+// Returns the date of format "2006-01-02", the month of leap month is
+// prefixed by "L", e.g. "2020-L04-01".
+func (d Date) String() string {
+	if d.IsLeap {
+		return fmt.Sprintf("%04d-L%02d-%02d", d.Year, d.Month, d.Day)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// Parses the date of the String format.
+func Parse(s string) (Date, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 3 {
+		return Date{}, InvalidDateError
+	}
+	d := Date{}
+	if d.IsLeap = strings.HasPrefix(parts[1], "L"); d.IsLeap {
+		parts[1] = parts[1][1:]
+	}
+	var err error
+	if d.Year, err = strconv.Atoi(parts[0]); err != nil {
+		return Date{}, InvalidDateError
+	}
+	if d.Month, err = strconv.Atoi(parts[1]); err != nil {
+		return Date{}, InvalidDateError
+	}
+	if d.Day, err = strconv.Atoi(parts[2]); err != nil {
+		return Date{}, InvalidDateError
+	}
+	return d, d.Validate()
+}
+
+// Returns OutOfRangeError if the year is out of range, or InvalidDateError
+// if the month, leap month or day does not exist.
+func (d Date) Validate() error {
+	days, err := MonthDays(d.Year, d.Month, d.IsLeap)
+	if err != nil {
+		return err
+	}
+	if d.Day < 1 || d.Day > days {
+		return InvalidDateError
+	}
+	return nil
+}
+
+// Returns the solar date at 00:00 UTC of this lunar date.
+func (d Date) ToSolar() (time.Time, error) {
+	if err := d.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	days := 0
+	for y := MIN_YEAR; y < d.Year; y++ {
+		days += YearDays(y)
+	}
+	leap := LeapMonth(d.Year)
+	for m := 1; m < d.Month; m++ {
+		days += monthDays(d.Year, m)
+		if m == leap {
+			days += leapDays(d.Year)
+		}
+	}
+	if d.IsLeap {
+		days += monthDays(d.Year, d.Month)
+	}
+	return baseDate.AddDate(0, 0, days+d.Day-1), nil
+}
+
+// Returns the lunar date of the solar date t, the clock and location of t
+// are ignored.
+func FromSolar(t time.Time) (Date, error) {
+	solar := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := int(solar.Sub(baseDate).Hours() / 24)
+	if offset < 0 {
+		return Date{}, OutOfRangeError
+	}
+	d := Date{Year: MIN_YEAR}
+	for ; d.Year <= MAX_YEAR && offset >= YearDays(d.Year); d.Year++ {
+		offset -= YearDays(d.Year)
+	}
+	if d.Year > MAX_YEAR {
+		return Date{}, OutOfRangeError
+	}
+	leap := LeapMonth(d.Year)
+	for d.Month = 1; ; d.Month++ {
+		if days := monthDays(d.Year, d.Month); offset < days {
+			break
+		} else {
+			offset -= days
+		}
+		if d.Month == leap {
+			if days := leapDays(d.Year); offset < days {
+				d.IsLeap = true
+				break
+			} else {
+				offset -= days
+			}
+		}
+	}
+	d.Day = offset + 1
+	return d, nil
+}
+
+// Returns the leap month of the year, or 0 if the year has no leap month.
+func LeapMonth(year int) int {
+	if year < MIN_YEAR || year > MAX_YEAR {
+		return 0
+	}
+	return int(lunarInfo[year-MIN_YEAR] & 0xf)
+}
+
+// Returns the days of the lunar year, or 0 if the year is out of range.
+func YearDays(year int) int {
+	if year < MIN_YEAR || year > MAX_YEAR {
+		return 0
+	}
+	days := leapDays(year)
+	for m := 1; m <= 12; m++ {
+		days += monthDays(year, m)
+	}
+	return days
+}
+
+// Returns the days of the month, 29 or 30.
+func MonthDays(year, month int, isLeap bool) (int, error) {
+	if year < MIN_YEAR || year > MAX_YEAR {
+		return 0, OutOfRangeError
+	}
+	if month < 1 || month > 12 || (isLeap && LeapMonth(year) != month) {
+		return 0, InvalidDateError
+	}
+	if isLeap {
+		return leapDays(year), nil
+	}
+	return monthDays(year, month), nil
+}
+
+func monthDays(year, month int) int {
+	if lunarInfo[year-MIN_YEAR]&(0x10000>>uint(month)) != 0 {
+		return 30
+	}
+	return 29
+}
+
+func leapDays(year int) int {
+	if LeapMonth(year) == 0 {
+		return 0
+	}
+	if lunarInfo[year-MIN_YEAR]&0x10000 != 0 {
+		return 30
+	}
+	return 29
+}
+
+// Returns the lunar date of the birthday of birth in the lunar year. The
+// birthday of a leap month is in the normal month if the year has no such
+// leap month, and the 30th is the 29th if the month has only 29 days.
+func BirthdayIn(birth Date, year int) (Date, error) {
+	d := Date{Year: year, Month: birth.Month, Day: birth.Day, IsLeap: birth.IsLeap}
+	if d.IsLeap && LeapMonth(year) != d.Month {
+		d.IsLeap = false
+	}
+	days, err := MonthDays(d.Year, d.Month, d.IsLeap)
+	if err != nil {
+		return Date{}, err
+	}
+	if d.Day > days {
+		d.Day = days
+	}
+	return d, nil
+}
+
+// Returns the month, day and leap of the lunar birthdays which are on the
+// solar date t by BirthdayIn, the Year of them is the lunar year of t.
+func BirthdaysOn(t time.Time) ([]Date, error) {
+	today, err := FromSolar(t)
+	if err != nil {
+		return nil, err
+	}
+	days, _ := MonthDays(today.Year, today.Month, today.IsLeap)
+	births := []Date{today}
+	if today.Day == days && days == 29 {
+		births = append(births, Date{today.Year, today.Month, 30, today.IsLeap})
+	}
+	// The leap month birthdays are in the normal month if no leap month.
+	if !today.IsLeap && LeapMonth(today.Year) != today.Month {
+		for _, d := range births {
+			births = append(births, Date{d.Year, d.Month, d.Day, true})
+		}
+	}
+	return births, nil
+}
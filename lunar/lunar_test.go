@@ -0,0 +1,99 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lunar
+
+import (
+	"testing"
+	"time"
+)
+
+func solar(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFromSolar(t *testing.T) {
+	cases := map[time.Time]Date{
+		solar(1900, time.January, 31): Date{1900, 1, 1, false},
+		solar(2000, time.February, 5): Date{2000, 1, 1, false},
+		solar(2020, time.May, 23):     Date{2020, 4, 1, true},
+		solar(2020, time.June, 21):    Date{2020, 5, 1, false},
+		solar(2023, time.March, 22):   Date{2023, 2, 1, true},
+		solar(2024, time.February, 9): Date{2023, 12, 30, false},
+		solar(2100, time.February, 9): Date{2100, 1, 1, false},
+	}
+	for s, expected := range cases {
+		d, err := FromSolar(s)
+		if err != nil || d != expected {
+			t.Errorf("FromSolar(%s) should be %s, actual: %s, %v", s.Format("2006-01-02"), expected, d, err)
+		}
+		back, err := expected.ToSolar()
+		if err != nil || !back.Equal(s) {
+			t.Errorf("%s.ToSolar() should be %s, actual: %s, %v", expected, s.Format("2006-01-02"), back, err)
+		}
+	}
+	if _, err := FromSolar(solar(1900, time.January, 30)); err != OutOfRangeError {
+		t.Errorf("The date before 1900 must be out of range, actual: %v", err)
+	}
+	if _, err := FromSolar(solar(2101, time.December, 31)); err != OutOfRangeError {
+		t.Errorf("The date after 2100 must be out of range, actual: %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for s := solar(1900, time.January, 31); s.Year() < 2101; s = s.AddDate(0, 0, 7) {
+		d, err := FromSolar(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back, err := d.ToSolar(); err != nil || !back.Equal(s) {
+			t.Fatalf("The round trip of %s is error, actual: %s, %v", s.Format("2006-01-02"), back, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	d, err := Parse("2020-L04-15")
+	if err != nil || d != (Date{2020, 4, 15, true}) || d.String() != "2020-L04-15" {
+		t.Errorf("Parse leap month date is error, actual: %s, %v", d, err)
+	}
+	for _, s := range []string{"2021-L04-15", "2020-13-01", "2020-04-31", "1899-01-01", "2020-04"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%s) must be error", s)
+		}
+	}
+}
+
+func TestBirthdays(t *testing.T) {
+	// Born in the leap 4th month of 2020, 2021 has no leap month.
+	d, err := BirthdayIn(Date{2020, 4, 30, true}, 2021)
+	if err != nil || d != (Date{2021, 4, 29, false}) {
+		t.Errorf("BirthdayIn is error, actual: %s, %v", d, err)
+	}
+	// 2021-06-09 is the lunar 2021-04-29, the last day of the month.
+	births, err := BirthdaysOn(solar(2021, time.June, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, b := range births {
+		found = found || b == Date{2021, 4, 30, true}
+	}
+	if len(births) != 4 || !found {
+		t.Errorf("BirthdaysOn is error, actual: %v", births)
+	}
+}
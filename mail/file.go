@@ -0,0 +1,54 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+var sequence uint64
+
+// FileMailer writes every message into Dir as an .eml file, or prints it
+// to the log if Dir is empty. Use it in development instead of a SMTP server.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+func (f *FileMailer) Send(msg *Message) error {
+	if err := msg.prepare(f.From); err != nil {
+		return err
+	}
+	if len(f.Dir) == 0 {
+		log.Printf("[mail] %s\n%s\n", msg, msg.Bytes())
+		return nil
+	}
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.eml", msg.Date.UnixNano(), atomic.AddUint64(&sequence, 1))
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), msg.Bytes(), 0644)
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	noRecipientError = errors.New("The mail message must have at least one recipient.")
+)
+
+// Mail message
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	IsHtml  bool
+	Date    time.Time
+}
+
+// Returns a new plain text message to the specified recipient.
+func NewMessage(to, subject, body string) *Message {
+	return &Message{To: []string{to}, Subject: subject, Body: body}
+}
+
+// Validates this message and fills the default From and Date values.
+func (m *Message) prepare(defaultFrom string) error {
+	if len(m.To) == 0 {
+		return noRecipientError
+	}
+	if len(m.From) == 0 {
+		m.From = defaultFrom
+	}
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+	return nil
+}
+
+// Returns the RFC 822 representation of this message.
+func (m Message) Bytes() []byte {
+	contentType := "text/plain"
+	if m.IsHtml {
+		contentType = "text/html"
+	}
+	headers := []string{
+		fmt.Sprintf("From: %s", m.From),
+		fmt.Sprintf("To: %s", strings.Join(m.To, ", ")),
+		fmt.Sprintf("Subject: %s", m.Subject),
+		fmt.Sprintf("Date: %s", m.Date.Format(time.RFC1123Z)),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: %s; charset=UTF-8", contentType),
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + m.Body)
+}
+
+func (m Message) String() string {
+	return fmt.Sprintf("Message{From=%s, To=%v, Subject=%s}", m.From, m.To, m.Subject)
+}
+
+// Implement this interface to deliver mail messages.
+type Mailer interface {
+
+	// Sends the given message, returns error if it could not be delivered.
+	Send(msg *Message) error
+}
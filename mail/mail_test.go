@@ -0,0 +1,54 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer := NewFileMailer(dir, "noreply@smartkids.com")
+	if err = mailer.Send(NewMessage("kid@smartkids.com", "Hello", "Body")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("FileMailer should write 1 file, actual: %d", len(files))
+	}
+	content, _ := ioutil.ReadFile(dir + "/" + files[0].Name())
+	for _, expected := range []string{"From: noreply@smartkids.com",
+		"To: kid@smartkids.com", "Subject: Hello", "\r\n\r\nBody"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Mail file does not contain %q:\n%s", expected, content)
+		}
+	}
+}
+
+func TestSendWithoutRecipient(t *testing.T) {
+	if err := NewFileMailer("", "").Send(&Message{Subject: "Hello"}); err != noRecipientError {
+		t.Error("Send a message without recipient should return noRecipientError, actual: ", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mail
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SmtpMailer delivers messages through the SMTP server at Addr (host:port).
+type SmtpMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// Returns a SmtpMailer uses PLAIN authentication if username is not empty.
+func NewSmtpMailer(addr, from, username, password string) *SmtpMailer {
+	mailer := &SmtpMailer{Addr: addr, From: from}
+	if len(username) > 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		mailer.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (s *SmtpMailer) Send(msg *Message) error {
+	if err := msg.prepare(s.From); err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Addr, s.Auth, msg.From, msg.To, msg.Bytes())
+}
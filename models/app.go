@@ -17,21 +17,25 @@
 package models
 
 import (
-	"crypto/md5"
-	_ "database/sql"
+	"database/sql"
 	"fmt"
+	"github.com/coopernurse/gorp"
 	"github.com/go-sql-driver/mysql"
-	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
 	"reflect"
+	"smart-kids/tokens"
+	"sort"
 	"strings"
 	"time"
 )
 
 // app module table names
 const (
-	DEVELOPER_TABLE   = "sk_developer"
-	APP_TABLE         = "sk_app"
-	APP_SESSION_TABLE = "sk_app_session"
+	DEVELOPER_TABLE = "sk_developer"
+	APP_TABLE       = "sk_app"
+	APP_GRANT_TABLE = "sk_app_grant"
 )
 
 // sk_developer fields constants
@@ -137,7 +141,6 @@ var (
 		F_TAG_ID2, F_TAG_ID3, F_USER_ID, F_USER_NAME, F_APP_KEY,
 		F_APP_SECRET, F_CREATED_TIME, F_LAST_MODIFIED_TIME,
 	}, ", ")
-	random = rand.New(rand.NewSource(time.Now().UnixNano()))
 )
 
 type App struct {
@@ -161,6 +164,27 @@ type App struct {
 	LastModifiedTime mysql.NullTime `db:"last_modified_time"`
 }
 
+// Returns true if the redirectUri is an absolute URL without fragment under
+// the Url of the app, or on the host of the Url or its subdomains if the app
+// binds its domain.
+func (a App) MatchRedirectUri(redirectUri string) bool {
+	appUrl, err := url.Parse(a.Url)
+	if err != nil || len(appUrl.Host) == 0 {
+		return false
+	}
+	uri, err := url.Parse(redirectUri)
+	if err != nil || uri.Scheme != appUrl.Scheme || uri.User != nil || len(uri.Fragment) > 0 {
+		return false
+	}
+	host, appHost := strings.ToLower(uri.Host), strings.ToLower(appUrl.Host)
+	if a.IsBindDomain {
+		return host == appHost || strings.HasSuffix(host, "."+appHost)
+	}
+	// the dot segments are resolved by the browser before the redirection
+	uriPath, appPath := path.Clean("/"+uri.Path), strings.TrimSuffix(path.Clean("/"+appUrl.Path), "/")
+	return host == appHost && (uriPath == appPath || strings.HasPrefix(uriPath, appPath+"/"))
+}
+
 func ToApp(i []interface{}, err error) *App {
 	if len(i) == 0 || i[0] == nil || reflect.ValueOf(i[0]).IsNil() {
 		return nil
@@ -183,81 +207,282 @@ func ToApps(results []interface{}, err error) []*App {
 	return apps
 }
 
-// sk_app fields constants
+// sk_app_grant fields constants
+const (
+	F_APP_ACCESS_TOKEN     = "access_token"
+	F_APP_AUTH_CODE        = "app_auth_code"
+	F_LAST_ACCESS_TIME     = "last_access_time"
+	F_REDIRECT_URI         = "redirect_uri"
+	F_REFRESH_TOKEN        = "refresh_token"
+	F_SCOPE                = "scope"
+	F_CODE_SCOPE           = "code_scope"
+	F_CODE_EXPIRED_TIME    = "code_expired_time"
+	F_TOKEN_EXPIRED_TIME   = "token_expired_time"
+	F_REFRESH_EXPIRED_TIME = "refresh_expired_time"
+	F_ISSUED_TIME          = "issued_time"
+)
+
+// OAuth2 grant types and token type
 const (
-	F_APP_ACCESS_TOKEN = "access_token"
-	F_APP_AUTH_CODE    = "app_auth_code"
-	F_LAST_ACCESS_TIME = "last_access_time"
+	GRANT_AUTHORIZATION_CODE = "authorization_code"
+	GRANT_REFRESH_TOKEN      = "refresh_token"
+	TOKEN_TYPE_BEARER        = "bearer"
+)
+
+// OAuth2 scopes, the scope of a request is a space separated list of them.
+const (
+	SCOPE_BASIC   = "basic"   // 用户名和头像
+	SCOPE_PROFILE = "profile" // 个人资料
+	SCOPE_EMAIL   = "email"   // 登录邮箱
+	SCOPE_FRIENDS = "friends" // 好友列表
+
+	DEFAULT_SCOPE = SCOPE_BASIC
 )
 
 var (
-	AppSessionFields = strings.Join([]string{
-		F_APP_ID, F_APP_NAME, F_APP_AUTH_CODE, F_APP_ACCESS_TOKEN, F_APP_KEY,
-		F_APP_SECRET, F_LAST_ACCESS_TIME, F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+	AppScopes = map[string]bool{
+		SCOPE_BASIC: true, SCOPE_PROFILE: true, SCOPE_EMAIL: true, SCOPE_FRIENDS: true,
+	}
+
+	AppGrantFields = strings.Join([]string{
+		F_APP_ID, F_APP_NAME, F_USER_ID, F_USER_NAME, F_SCOPE, F_APP_AUTH_CODE,
+		F_CODE_SCOPE, F_APP_ACCESS_TOKEN, F_REDIRECT_URI, F_REFRESH_TOKEN,
+		F_CODE_EXPIRED_TIME, F_TOKEN_EXPIRED_TIME, F_REFRESH_EXPIRED_TIME,
+		F_ISSUED_TIME, F_LAST_ACCESS_TIME, F_CREATED_TIME, F_LAST_MODIFIED_TIME,
 	}, ", ")
+
+	userAppGrantsSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? ORDER BY %s DESC",
+		AppGrantFields, APP_GRANT_TABLE, F_USER_ID, F_LAST_ACCESS_TIME)
+	// locks the grant, so a code or refresh token is exchanged only once
+	appGrantForUpdateSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ? FOR UPDATE",
+		AppGrantFields, APP_GRANT_TABLE, F_APP_ID, "%s")
+	appGrantByCodeSql    = fmt.Sprintf(appGrantForUpdateSql, F_APP_AUTH_CODE)
+	appGrantByRefreshSql = fmt.Sprintf(appGrantForUpdateSql, F_REFRESH_TOKEN)
+	deleteAppGrantSql    = fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?",
+		APP_GRANT_TABLE, F_APP_ID, F_USER_ID)
 )
 
-type AppSession struct {
-	AppId            uint           `db:"app_id"`
-	AppName          string         `db:"app_name"`
-	AppAuthCode      string         `db:"app_auth_code"` // Dynamic change
-	AccessToken      string         `db:"access_token"`  // Unique Index
-	AppKey           string         `db:"app_key"`
-	AppSecret        string         `db:"app_secret"`
-	LastAccessTime   uint64         `db:"last_access_time"`
-	CreatedTime      mysql.NullTime `db:"created_time"`
-	LastModifiedTime mysql.NullTime `db:"last_modified_time"`
+// AppGrant is the authorization of a user to an app, it keeps the granted
+// scope, the auth code and the tokens of the app for the user. Only the
+// sha256 hashes of the code and tokens are stored, the raw values are only
+// known by the client.
+type AppGrant struct {
+	AppId              uint           `db:"app_id" json:"appId"`
+	AppName            string         `db:"app_name" json:"appName"`
+	UserId             uint64         `db:"user_id" json:"-"`
+	UserName           string         `db:"user_name" json:"-"`
+	Scope              string         `db:"scope" json:"scope"`     // the scope of the issued tokens
+	HashAuthCode       string         `db:"app_auth_code" json:"-"` // Dynamic change, empty once exchanged
+	CodeScope          string         `db:"code_scope" json:"-"`    // the requested scope of the auth code
+	HashAccessToken    sql.NullString `db:"access_token" json:"-"`  // Unique Index
+	RedirectUri        string         `db:"redirect_uri" json:"-"`  // the redirect_uri of the auth code
+	HashRefreshToken   sql.NullString `db:"refresh_token" json:"-"` // Unique Index
+	CodeExpiredTime    mysql.NullTime `db:"code_expired_time" json:"-"`
+	TokenExpiredTime   mysql.NullTime `db:"token_expired_time" json:"-"`
+	RefreshExpiredTime mysql.NullTime `db:"refresh_expired_time" json:"-"`
+	IssuedTime         mysql.NullTime `db:"issued_time" json:"issued"` // the tokens are last issued
+	LastAccessTime     mysql.NullTime `db:"last_access_time" json:"lastUsed"`
+	CreatedTime        mysql.NullTime `db:"created_time" json:"created"`
+	LastModifiedTime   mysql.NullTime `db:"last_modified_time" json:"-"`
+}
+
+// Returns a new AppGrant of the app to the user without code and tokens.
+func NewAppGrant(app *App, user *User) *AppGrant {
+	return &AppGrant{AppId: app.Id, AppName: app.Name, UserId: user.UserId,
+		UserName: user.UserName}
+}
+
+func (a AppGrant) String() string {
+	return fmt.Sprintf("AppGrant{App=(%d, %s),User=(%d, %s),Scope=%s}", a.AppId, a.AppName,
+		a.UserId, a.UserName, a.Scope)
+}
+
+// Returns true if all the scopes of the scope are granted to the app.
+func (a AppGrant) Covers(scope string) bool {
+	granted := strings.Fields(a.Scope)
+	for _, name := range strings.Fields(scope) {
+		i := sort.SearchStrings(granted, name)
+		if i == len(granted) || granted[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// Flushes AppGrant's auth code, the issued tokens are kept until the new
+// code is exchanged. Returns the new raw auth code.
+func (a *AppGrant) FlushAuthCode() string {
+	code, hash := tokens.NewCode()
+	a.HashAuthCode = hash
+	return code
+}
+
+// Flushes the auth code of the redirectUri and the requested scope, the code
+// must be exchanged within expires. Returns the new auth code.
+func (a *AppGrant) NewAuthCode(redirectUri, scope string, expires time.Duration) string {
+	a.RedirectUri, a.CodeScope = redirectUri, scope
+	a.CodeExpiredTime = mysql.NullTime{time.Now().Add(expires), true}
+	return a.FlushAuthCode()
+}
+
+// Exchanges the auth code of the redirectUri, the code can be used only once.
+// The redirectUri must be the one the code is issued to.
+// The scope of the code is granted to the next IssueTokens.
+func (a *AppGrant) UseAuthCode(code, redirectUri string) *AuthError {
+	if !tokens.Match(code, a.HashAuthCode) {
+		return Err_Invalid_Grant
+	}
+	// the redirect_uri of Authorize must be repeated, RFC 6749 section 4.1.3
+	if redirectUri != a.RedirectUri {
+		return Err_Redirect_URI_Mismatch
+	}
+	expired := isExpired(a.CodeExpiredTime)
+	a.HashAuthCode, a.CodeExpiredTime = "", mysql.NullTime{}
+	if expired {
+		return Err_Expired_Token
+	}
+	a.Scope, a.CodeScope = a.CodeScope, ""
+	return nil
+}
+
+// Uses the refresh token, which is replaced by the next IssueTokens.
+func (a *AppGrant) UseRefreshToken(refreshToken string) *AuthError {
+	if !tokens.Match(refreshToken, a.HashRefreshToken.String) {
+		return Err_Invalid_Grant
+	}
+	if isExpired(a.RefreshExpiredTime) {
+		return Err_Expired_Token
+	}
+	return nil
 }
 
-// Flushes AppSession's AppAuthCode value, at the same time,
-// flushes AccessToken value. Returns new AppAuthCode value.
-func (a *AppSession) FlushAuthCode() string {
-	authCode := string(random.Int63n(8))
-	h := md5.New()
-	h.Write([]byte(fmt.Sprintf("AuthCode{%s,%s,%s}", a.AppKey,
-		a.AppSecret, authCode)))
-	accessToken := fmt.Sprintf("%x", h.Sum(nil))
-	a.AppAuthCode = authCode
-	a.AccessToken = accessToken
-	return a.AppAuthCode
+// Issues a new access token expiring after tokenExpires and a new refresh
+// token expiring after refreshExpires, the old ones are revoked. The auth
+// code is not changed. Returns the raw access token and refresh token.
+func (a *AppGrant) IssueTokens(tokenExpires, refreshExpires time.Duration) (string, string) {
+	timeNow := time.Now()
+	accessToken, accessHash := tokens.NewToken()
+	refreshToken, refreshHash := tokens.NewToken()
+	a.HashAccessToken, a.HashRefreshToken = sql.NullString{accessHash, true}, sql.NullString{refreshHash, true}
+	a.TokenExpiredTime = mysql.NullTime{timeNow.Add(tokenExpires), true}
+	a.RefreshExpiredTime = mysql.NullTime{timeNow.Add(refreshExpires), true}
+	a.IssuedTime = mysql.NullTime{timeNow, true}
+	a.LastAccessTime = mysql.NullTime{timeNow, true}
+	return accessToken, refreshToken
+}
+
+// Returns the RFC 6749 token response of the raw tokens issued by IssueTokens.
+func (a AppGrant) TokenResponse(accessToken, refreshToken string) map[string]interface{} {
+	expiresIn := int64(0)
+	if a.TokenExpiredTime.Valid {
+		expiresIn = int64(a.TokenExpiredTime.Time.Sub(time.Now()).Seconds())
+	}
+	return map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    TOKEN_TYPE_BEARER,
+		"expires_in":    expiresIn,
+		"refresh_token": refreshToken,
+		"scope":         a.Scope,
+		"uid":           a.UserId,
+	}
 }
 
-func NewAppSession(app *App) *AppSession {
+func (a *AppGrant) PreInsert(_ gorp.SqlExecutor) error {
 	timeNow := time.Now()
-	session := &AppSession{
-		AppId: app.Id, AppName: app.Name, AppKey: app.AppKey,
-		AppSecret: app.AppSecret, LastAccessTime: uint64(timeNow.Unix()),
-		CreatedTime:      mysql.NullTime{timeNow, true},
-		LastModifiedTime: mysql.NullTime{timeNow, true},
-	}
-	session.FlushAuthCode()
-	return session
+	a.CreatedTime = mysql.NullTime{timeNow, true}
+	a.LastModifiedTime = mysql.NullTime{timeNow, true}
+	return nil
+}
+
+func (a *AppGrant) PreUpdate(_ gorp.SqlExecutor) error {
+	a.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+func isExpired(t mysql.NullTime) bool {
+	return !t.Valid || !t.Time.After(time.Now())
+}
+
+// Returns the normalized scope of the requested scope, the scopes are
+// sorted and deduplicated. Returns Err_invalid_scope if any is unknown.
+func ParseScope(scope string) (string, *AuthError) {
+	names := strings.Fields(scope)
+	if len(names) == 0 {
+		return DEFAULT_SCOPE, nil
+	}
+	seen := make(map[string]bool, len(names))
+	scopes := make([]string, 0, len(names))
+	for _, name := range names {
+		if !AppScopes[name] {
+			return "", Err_invalid_scope
+		}
+		if !seen[name] {
+			seen[name] = true
+			scopes = append(scopes, name)
+		}
+	}
+	sort.Strings(scopes)
+	return strings.Join(scopes, " "), nil
 }
 
-func ToAppSession(i interface{}, err error) *AppSession {
+// Returns the apps authorized by the user, the last used first.
+func FindUserAppGrants(exe gorp.SqlExecutor, userId uint64) []*AppGrant {
+	return ToAppGrants(exe.Select(AppGrant{}, userAppGrantsSql, userId))
+}
+
+// Returns the locked grant of the app which has the auth code, or nil.
+func FindAppGrantByCode(exe gorp.SqlExecutor, appId uint, code string) *AppGrant {
+	return firstAppGrant(exe.Select(AppGrant{}, appGrantByCodeSql, appId, tokens.Hash(code)))
+}
+
+// Returns the locked grant of the app which has the refresh token, or nil.
+func FindAppGrantByRefreshToken(exe gorp.SqlExecutor, appId uint, refreshToken string) *AppGrant {
+	return firstAppGrant(exe.Select(AppGrant{}, appGrantByRefreshSql, appId, tokens.Hash(refreshToken)))
+}
+
+// Revokes the authorization of the user to the app, the code and tokens are
+// removed with the grant. Returns false if the user has not authorized it.
+func RevokeAppGrant(exe gorp.SqlExecutor, appId uint, userId uint64) (bool, error) {
+	res, err := exe.Exec(deleteAppGrantSql, appId, userId)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+func firstAppGrant(results []interface{}, err error) *AppGrant {
+	grants := ToAppGrants(results, err)
+	if len(grants) == 0 {
+		return nil
+	}
+	return grants[0]
+}
+
+func ToAppGrant(i interface{}, err error) *AppGrant {
 	if err != nil {
 		panic(err)
 	}
 	if i == nil || reflect.ValueOf(i).IsNil() {
 		return nil
 	}
-	return i.(*AppSession)
+	return i.(*AppGrant)
 }
 
-func ToAppSessions(results []interface{}, err error) []*AppSession {
+func ToAppGrants(results []interface{}, err error) []*AppGrant {
 	if err != nil {
 		panic(err)
 	}
 	size := len(results)
-	appSessions := make([]*AppSession, size)
+	appGrants := make([]*AppGrant, size)
 	if size == 0 {
-		return appSessions
+		return appGrants
 	}
 
 	for i, result := range results {
-		appSessions[i] = result.(*AppSession)
+		appGrants[i] = result.(*AppGrant)
 	}
-	return appSessions
+	return appGrants
 }
 
 type AuthError struct {
@@ -266,6 +491,17 @@ type AuthError struct {
 	Message string `json:"error_description"`
 }
 
+// Returns the HTTP status of the error response, RFC 6749 section 5.2.
+func (a *AuthError) Status() int {
+	switch a {
+	case Err_Invalid_Client:
+		return http.StatusUnauthorized
+	case Err_temporarily_unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
 func (a *AuthError) Equals(other *AuthError) bool {
 	if other == nil {
 		return false
@@ -285,4 +521,5 @@ var (
 	Err_unsupported_response_type = &AuthError{"unsupported_response_type", 21329, "不支持的 ResponseType"}
 	Err_access_denied             = &AuthError{"access_denied", 21330, "用户或授权服务器拒绝授予数据访问权限"}
 	Err_temporarily_unavailable   = &AuthError{"temporarily_unavailable", 21331, "服务暂时无法访问"}
+	Err_invalid_scope             = &AuthError{"invalid_scope", 21332, "请求的权限范围无效"}
 )
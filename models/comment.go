@@ -20,9 +20,25 @@ import (
 	"database/sql"
 	"github.com/coopernurse/gorp"
 	_ "github.com/go-sql-driver/mysql"
+	"strings"
 	"time"
 )
 
+// Comment fields
+const (
+	F_USER_EMAIL = "user_email"
+	F_USER_URL   = "user_url"
+	F_CONTENT    = "content"
+	F_IS_TOP     = "is_top"
+)
+
+var (
+	BaseCommentFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_USER_NAME, F_USER_EMAIL, F_USER_URL, F_CLIENT_CODE,
+		F_CLIENT_IP, F_TITLE, F_CONTENT, F_IS_TOP, F_CREATED_TIME,
+	}, ", ")
+)
+
 type BaseComment struct {
 	Id          uint64         `db:"id"`
 	UserId      uint64         `db:"user_id"`
@@ -17,8 +17,22 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"github.com/coopernurse/gorp"
 	"reflect"
+	"time"
+)
+
+const (
+	DICT_COUNTRY_TABLE  = "dict_country"
+	DICT_PROVINCE_TABLE = "dict_province"
+	DICT_CITY_TABLE     = "dict_city"
+	DICT_DISTRICT_TABLE = "dict_district"
+)
+
+var (
+	InvalidLocationError = errors.New("Location.invalid")
 )
 
 // mapped table dict_country
@@ -118,7 +132,7 @@ func ToProvinces(results []interface{}, err error) []*Province {
 // mapped table `dict_city`
 type City struct {
 	Id        uint   `db:"id" json:"id"`
-	CountryId uint   `db:"country_id", json:"countryId"`
+	CountryId uint   `db:"country_id" json:"countryId"`
 	ProId     uint   `db:"pro_id" json:"proId"`
 	Name      string `db:"c_name" json:"name"`
 	Code      string `db:"d_code" json:"code"`
@@ -214,10 +228,81 @@ func ToDistricts(results []interface{}, err error) []*District {
 
 // 所在地、位置信息
 type Location struct {
-	Country  *Country
-	Province *Province
-	City     *City
-	District *District
+	Country  *Country  `json:"country,omitempty"`
+	Province *Province `json:"province,omitempty"`
+	City     *City     `json:"city,omitempty"`
+	District *District `json:"district,omitempty"`
+}
+
+// Returns the Location of the specified dictionary ids, zero id means not
+// selected. Returns InvalidLocationError if any id is not found or not in
+// its parent, or returns nil Location if no id is selected.
+func LoadLocation(exe gorp.SqlExecutor, countryId, provinceId, cityId, districtId uint) (*Location, error) {
+	if countryId == 0 && provinceId == 0 && cityId == 0 && districtId == 0 {
+		return nil, nil
+	}
+	if countryId == 0 || (provinceId == 0 && cityId > 0) || (cityId == 0 && districtId > 0) {
+		return nil, InvalidLocationError
+	}
+	loc := &Location{}
+	obj, err := exe.Get(Country{}, countryId)
+	if err != nil || obj == nil {
+		return nil, locationError(err)
+	}
+	loc.Country = obj.(*Country)
+	if provinceId > 0 {
+		if obj, err = exe.Get(Province{}, provinceId); err != nil || obj == nil {
+			return nil, locationError(err)
+		}
+		if loc.Province = obj.(*Province); loc.Province.CountryId != countryId {
+			return nil, InvalidLocationError
+		}
+	}
+	if cityId > 0 {
+		if obj, err = exe.Get(City{}, cityId); err != nil || obj == nil {
+			return nil, locationError(err)
+		}
+		if loc.City = obj.(*City); loc.City.ProId != provinceId {
+			return nil, InvalidLocationError
+		}
+	}
+	if districtId > 0 {
+		if obj, err = exe.Get(District{}, districtId); err != nil || obj == nil {
+			return nil, locationError(err)
+		}
+		if loc.District = obj.(*District); loc.District.CityId != cityId {
+			return nil, InvalidLocationError
+		}
+	}
+	return loc, nil
+}
+
+// Returns the database error, or InvalidLocationError if not found.
+func locationError(err error) error {
+	if err != nil {
+		return err
+	}
+	return InvalidLocationError
+}
+
+// Returns the dictionary ids of this Location, zero if not selected.
+func (l *Location) Ids() (countryId, provinceId, cityId, districtId uint) {
+	if l == nil {
+		return
+	}
+	if l.Country != nil {
+		countryId = l.Country.Id
+	}
+	if l.Province != nil {
+		provinceId = l.Province.Id
+	}
+	if l.City != nil {
+		cityId = l.City.Id
+	}
+	if l.District != nil {
+		districtId = l.District.Id
+	}
+	return
 }
 
 type Education struct {
@@ -258,7 +343,7 @@ func EducationOf(id uint16, def *Education) *Education {
 	if def == nil {
 		return EDU_Unknown
 	}
-	return nil
+	return def
 }
 
 func AllEducations() []*Education {
@@ -266,6 +351,7 @@ func AllEducations() []*Education {
 	i := 0
 	for _, v := range educationMap {
 		results[i] = v
+		i++
 	}
 	return results
 }
@@ -359,6 +445,7 @@ func AllBloodTypes() []*BloodType {
 	i := 0
 	for _, v := range bloodMap {
 		results[i] = v
+		i++
 	}
 	return results
 }
@@ -410,6 +497,29 @@ func ConstellationOf(id uint16, def *Constellation) *Constellation {
 	return def
 }
 
+// Returns true if the month and day is in the date range of this
+// Constellation, the range of Capricornus wraps the year end.
+func (c Constellation) Contains(month, day int) bool {
+	md, start, end := month*100+day, c.StartMonth*100+c.StartDay, c.EndMonth*100+c.EndDay
+	if start <= end {
+		return md >= start && md <= end
+	}
+	return md >= start || md <= end
+}
+
+// Returns the Constellation of the solar date t, or Cons_Unknown if t is zero.
+func ConstellationFor(t time.Time) *Constellation {
+	if t.IsZero() {
+		return Cons_Unknown
+	}
+	for _, c := range constellationMap {
+		if c.Contains(int(t.Month()), t.Day()) {
+			return c
+		}
+	}
+	return Cons_Unknown
+}
+
 // Returns all constellation instance.
 func AllConstellations() []*Constellation {
 	results := make([]*Constellation, 12)
@@ -420,3 +530,90 @@ func AllConstellations() []*Constellation {
 	}
 	return results
 }
+
+// The age band of the users, MaxAge is -1 if no upper limit.
+type AgeBand struct {
+	Id     uint16 `json:"id"`
+	Name   string `json:"name"`
+	EName  string `json:"ename"`
+	MinAge int    `json:"minAge"`
+	MaxAge int    `json:"maxAge"`
+}
+
+// Returns AgeBand object string
+func (a AgeBand) String() string {
+	return fmt.Sprintf("AgeBand{%d,\"%s\",\"%s\",(%d - %d)}", a.Id, a.Name, a.EName, a.MinAge, a.MaxAge)
+}
+
+// Returns true if the age is in this AgeBand.
+func (a AgeBand) Contains(age int) bool {
+	return age >= a.MinAge && (a.MaxAge < 0 || age <= a.MaxAge)
+}
+
+// AgeBand instances, from young to old.
+var (
+	AGE_Unknown = &AgeBand{uint16(0), "未知", "Unknown", -1, -1}
+	ageBands    = []*AgeBand{
+		&AgeBand{uint16(1), "婴幼儿", "Infant", 0, 2},
+		&AgeBand{uint16(2), "学龄前", "Preschool", 3, 5},
+		&AgeBand{uint16(3), "小学", "Primary", 6, 11},
+		&AgeBand{uint16(4), "初中", "Junior", 12, 14},
+		&AgeBand{uint16(5), "高中", "Senior", 15, 17},
+		&AgeBand{uint16(6), "成人", "Adult", 18, -1},
+	}
+)
+
+// Returns the AgeBand of the age, or AGE_Unknown if the age is negative.
+func AgeBandOf(age int) *AgeBand {
+	for _, band := range ageBands {
+		if band.Contains(age) {
+			return band
+		}
+	}
+	return AGE_Unknown
+}
+
+// Returns the AgeBand of the specified id.
+// if the def is nil, default returns AGE_Unknown
+func AgeBandById(id uint16, def *AgeBand) *AgeBand {
+	for _, band := range ageBands {
+		if band.Id == id {
+			return band
+		}
+	}
+	if def == nil {
+		return AGE_Unknown
+	}
+	return def
+}
+
+// Returns all age bands, from young to old.
+func AllAgeBands() []*AgeBand {
+	results := make([]*AgeBand, len(ageBands))
+	copy(results, ageBands)
+	return results
+}
+
+// Returns the full years of age at the time t of the date of birth, or -1
+// if the date of birth is zero or after t. The birthday of February 29 is
+// reached on February 28 in common years, the same day as the birthday
+// greetings of FindBirthdayUserInfos.
+func AgeAt(dateOfBirth, t time.Time) int {
+	if dateOfBirth.IsZero() || dateOfBirth.After(t) {
+		return -1
+	}
+	month, day := dateOfBirth.Month(), dateOfBirth.Day()
+	if month == time.February && day == 29 && !isLeapYear(t.Year()) {
+		day = 28
+	}
+	age := t.Year() - dateOfBirth.Year()
+	if t.Month() < month || (t.Month() == month && t.Day() < day) {
+		age--
+	}
+	return age
+}
+
+// Returns true if February of the solar year has 29 days.
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
@@ -0,0 +1,320 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	EMAIL_CHANGE_TABLE = "sk_email_change"
+)
+
+// EmailChange kind constants
+const (
+	EMAIL_PRIMARY = int16(1) // 登录邮箱
+	EMAIL_SPARE   = int16(2) // 备用邮箱
+)
+
+// EmailChange status constants
+const (
+	EMAIL_CHANGE_REQUESTED = int16(1) // waits for the confirmation of the new address
+	EMAIL_CHANGE_CONFIRMED = int16(2)
+	EMAIL_CHANGE_UNDONE    = int16(3) // reverted by the undo link sent to the old address
+	EMAIL_CHANGE_CANCELLED = int16(4) // replaced by a later request
+)
+
+// sk_email_change fields constants
+const (
+	F_EMAIL_KIND     = "email_kind"
+	F_OLD_EMAIL      = "old_email"
+	F_NEW_EMAIL      = "new_email"
+	F_CONFIRMED_TIME = "confirmed_time"
+	F_UNDONE_TIME    = "undone_time"
+)
+
+var (
+	EmailInUseError          = errors.New("EmailChange.emailInUse")
+	SameEmailError           = errors.New("EmailChange.sameEmail")
+	EmailChangeNotFoundError = errors.New("EmailChange.notFound")
+
+	EmailChangeFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_USER_NAME, F_EMAIL_KIND, F_OLD_EMAIL, F_NEW_EMAIL, F_STATUS,
+		F_CONFIRMED_TIME, F_UNDONE_TIME, F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+	}, ", ")
+
+	// The email and the spare email share the same namespace.
+	emailInUseSql = fmt.Sprintf("SELECT COUNT(%s) FROM %s WHERE (%s = ? OR %s = ?) AND %s <> ?",
+		F_USER_ID, USER_TABLE, F_EMAIL, F_SPARE_EMAIL, F_USER_ID)
+	emailChangesSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? ORDER BY %s DESC LIMIT 20",
+		EmailChangeFields, EMAIL_CHANGE_TABLE, F_USER_ID, F_ID)
+	emailChangeOfSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ? AND %s = ? "+
+		"AND %s = ? ORDER BY %s DESC LIMIT 1", EmailChangeFields, EMAIL_CHANGE_TABLE,
+		F_USER_ID, F_EMAIL_KIND, F_STATUS, "%s", F_ID)
+	requestedEmailChangeSql = fmt.Sprintf(emailChangeOfSql, F_NEW_EMAIL)
+	confirmedEmailChangeSql = fmt.Sprintf(emailChangeOfSql, F_OLD_EMAIL)
+	cancelEmailChangesSql   = fmt.Sprintf("UPDATE %s SET %s = ?, %s = ? WHERE %s = ? AND %s = ? AND %s = ?",
+		EMAIL_CHANGE_TABLE, F_STATUS, F_LAST_MODIFIED_TIME, F_USER_ID, F_EMAIL_KIND, F_STATUS)
+	confirmedChangesAfterSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ? AND %s = ? AND %s > ? ORDER BY %s",
+		EmailChangeFields, EMAIL_CHANGE_TABLE, F_USER_ID, F_EMAIL_KIND, F_STATUS, F_ID, F_ID)
+	spareChangedAfterSql = fmt.Sprintf("SELECT COUNT(%s) FROM %s WHERE %s = ? AND %s = ? AND %s = ? AND %s >= ?",
+		F_ID, EMAIL_CHANGE_TABLE, F_USER_ID, F_EMAIL_KIND, F_STATUS, F_CONFIRMED_TIME)
+	invalidateMailedTokensSql = fmt.Sprintf("UPDATE %s SET %s = 1, %s = ? WHERE %s = ? AND %s IN (%d, %d, %d, %d) AND %s = 0",
+		USER_TOKEN_TABLE, F_IS_USED, F_USED_TIME, F_USER_ID, F_TOKEN_TYPE, TOKEN_RESET_PASSWORD,
+		TOKEN_CHANGE_EMAIL, TOKEN_UNDO_EMAIL_CHANGE, TOKEN_VERIFY_SPARE_EMAIL, F_IS_USED)
+)
+
+// EmailChange is a change of the email or the spare email of a user, the
+// records are kept as the history of the addresses.
+type EmailChange struct {
+	Id               uint64         `db:"id" json:"id"`
+	UserId           uint64         `db:"user_id" json:"-"`
+	UserName         string         `db:"user_name" json:"-"`
+	Kind             int16          `db:"email_kind" json:"kind"`
+	OldEmail         string         `db:"old_email" json:"oldEmail"`
+	NewEmail         string         `db:"new_email" json:"newEmail"` // empty if the spare email is removed
+	Status           int16          `db:"status" json:"status"`
+	ConfirmedTime    mysql.NullTime `db:"confirmed_time" json:"confirmed"`
+	UndoneTime       mysql.NullTime `db:"undone_time" json:"undone"`
+	CreatedTime      mysql.NullTime `db:"created_time" json:"created"`
+	LastModifiedTime mysql.NullTime `db:"last_modified_time" json:"-"`
+}
+
+func (c EmailChange) String() string {
+	return fmt.Sprintf("EmailChange{Id=%d,User=(%d, %s),Kind=%d,Old=%s,New=%s,Status=%d}",
+		c.Id, c.UserId, c.UserName, c.Kind, c.OldEmail, c.NewEmail, c.Status)
+}
+
+func (c *EmailChange) PreInsert(_ gorp.SqlExecutor) error {
+	timeNow := time.Now()
+	c.CreatedTime = mysql.NullTime{timeNow, true}
+	c.LastModifiedTime = mysql.NullTime{timeNow, true}
+	return nil
+}
+
+func (c *EmailChange) PreUpdate(_ gorp.SqlExecutor) error {
+	c.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+// Returns the current address of the kind of the user.
+func (u User) emailOf(kind int16) string {
+	if kind == EMAIL_PRIMARY {
+		return u.Email
+	}
+	if u.SpareEmail.Valid {
+		return u.SpareEmail.String
+	}
+	return ""
+}
+
+// Returns true if the email is the email or the spare email of any user
+// other than the user of exceptUserId.
+func EmailInUse(exe gorp.SqlExecutor, email string, exceptUserId uint64) (bool, error) {
+	count, err := exe.SelectInt(emailInUseSql, email, email, exceptUserId)
+	return count > 0, err
+}
+
+// Requests to change the email of the kind of the user to the new address,
+// the earlier requests of the kind are cancelled. The change takes effect
+// after ConfirmEmailChange by the link sent to the new address.
+func RequestEmailChange(exe gorp.SqlExecutor, user *User, kind int16, email string) (*EmailChange, error) {
+	if email == user.Email || (user.SpareEmail.Valid && email == user.SpareEmail.String) {
+		return nil, SameEmailError
+	}
+	if inUse, err := EmailInUse(exe, email, user.UserId); err != nil || inUse {
+		if err == nil {
+			err = EmailInUseError
+		}
+		return nil, err
+	}
+	if _, err := exe.Exec(cancelEmailChangesSql, EMAIL_CHANGE_CANCELLED, time.Now(),
+		user.UserId, kind, EMAIL_CHANGE_REQUESTED); err != nil {
+		return nil, err
+	}
+	change := &EmailChange{UserId: user.UserId, UserName: user.UserName, Kind: kind,
+		OldEmail: user.emailOf(kind), NewEmail: email, Status: EMAIL_CHANGE_REQUESTED}
+	if err := exe.Insert(change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Confirms the requested change of the kind of the user to the email, the
+// confirmed spare email can be used to recover the account.
+func ConfirmEmailChange(exe gorp.SqlExecutor, user *User, kind int16, email string) (*EmailChange, error) {
+	change, err := findEmailChange(exe, requestedEmailChangeSql, user.UserId, kind,
+		EMAIL_CHANGE_REQUESTED, email)
+	if err != nil {
+		return nil, err
+	}
+	// the address may be taken since the request
+	if inUse, err := EmailInUse(exe, email, user.UserId); err != nil || inUse {
+		if err == nil {
+			err = EmailInUseError
+		}
+		return nil, err
+	}
+	if kind == EMAIL_PRIMARY {
+		user.Email = email
+	} else {
+		user.SpareEmail, user.IsSpareVerified = sql.NullString{email, true}, true
+	}
+	if _, err = exe.Update(user); err != nil {
+		return nil, err
+	}
+	change.Status, change.ConfirmedTime = EMAIL_CHANGE_CONFIRMED, mysql.NullTime{time.Now(), true}
+	if _, err = exe.Update(change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Reverts the latest confirmed change of the email of the user from the old
+// address, with all the changes confirmed after it. The change must still lead
+// to the current email, or EmailChangeNotFoundError is returned. The login
+// sessions and the mailed tokens of the user are revoked, and the spare email
+// confirmed since the change is removed, as they may be set by someone else
+// who took over the account.
+func UndoEmailChange(exe gorp.SqlExecutor, user *User, oldEmail string) (*EmailChange, error) {
+	change, err := findEmailChange(exe, confirmedEmailChangeSql, user.UserId, EMAIL_PRIMARY,
+		EMAIL_CHANGE_CONFIRMED, oldEmail)
+	if err != nil {
+		return nil, err
+	}
+	laters, err := exe.Select(EmailChange{}, confirmedChangesAfterSql, user.UserId, EMAIL_PRIMARY,
+		EMAIL_CHANGE_CONFIRMED, change.Id)
+	if err != nil {
+		return nil, err
+	}
+	chain := []*EmailChange{change}
+	for _, r := range laters {
+		later := r.(*EmailChange)
+		if later.OldEmail != chain[len(chain)-1].NewEmail {
+			return nil, EmailChangeNotFoundError
+		}
+		chain = append(chain, later)
+	}
+	if chain[len(chain)-1].NewEmail != user.Email {
+		return nil, EmailChangeNotFoundError
+	}
+	if inUse, err := EmailInUse(exe, oldEmail, user.UserId); err != nil || inUse {
+		if err == nil {
+			err = EmailInUseError
+		}
+		return nil, err
+	}
+	count, err := exe.SelectInt(spareChangedAfterSql, user.UserId, EMAIL_SPARE,
+		EMAIL_CHANGE_CONFIRMED, change.ConfirmedTime.Time)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		user.SpareEmail, user.IsSpareVerified = sql.NullString{}, false
+	}
+	user.Email = oldEmail
+	if _, err = exe.Update(user); err != nil {
+		return nil, err
+	}
+	for _, c := range chain {
+		c.Status, c.UndoneTime = EMAIL_CHANGE_UNDONE, mysql.NullTime{time.Now(), true}
+		if _, err = exe.Update(c); err != nil {
+			return nil, err
+		}
+	}
+	for _, kind := range []int16{EMAIL_PRIMARY, EMAIL_SPARE} {
+		if _, err = exe.Exec(cancelEmailChangesSql, EMAIL_CHANGE_CANCELLED, time.Now(),
+			user.UserId, kind, EMAIL_CHANGE_REQUESTED); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = exe.Exec(invalidateMailedTokensSql, time.Now(), user.UserId); err != nil {
+		return nil, err
+	}
+	if _, err = DeleteUserSessions(exe, user.UserId); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Removes the spare email of the user, the removal is kept in the history.
+func RemoveSpareEmail(exe gorp.SqlExecutor, user *User) (*EmailChange, error) {
+	if !user.SpareEmail.Valid || len(user.SpareEmail.String) == 0 {
+		return nil, EmailChangeNotFoundError
+	}
+	timeNow := time.Now()
+	change := &EmailChange{UserId: user.UserId, UserName: user.UserName, Kind: EMAIL_SPARE,
+		OldEmail: user.SpareEmail.String, Status: EMAIL_CHANGE_CONFIRMED,
+		ConfirmedTime: mysql.NullTime{timeNow, true}}
+	if err := exe.Insert(change); err != nil {
+		return nil, err
+	}
+	user.SpareEmail, user.IsSpareVerified = sql.NullString{}, false
+	if _, err := exe.Update(user); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Returns the latest email changes of the user, the newest first.
+func FindEmailChanges(exe gorp.SqlExecutor, userId uint64) []*EmailChange {
+	return ToEmailChanges(exe.Select(EmailChange{}, emailChangesSql, userId))
+}
+
+func findEmailChange(exe gorp.SqlExecutor, query string, userId uint64, kind, status int16,
+	email string) (*EmailChange, error) {
+	results, err := exe.Select(EmailChange{}, query, userId, kind, status, email)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, EmailChangeNotFoundError
+	}
+	return results[0].(*EmailChange), nil
+}
+
+func ToEmailChange(i interface{}, err error) *EmailChange {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*EmailChange)
+}
+
+func ToEmailChanges(results []interface{}, err error) []*EmailChange {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	changes := make([]*EmailChange, size)
+	if size == 0 {
+		return changes
+	}
+	for i, r := range results {
+		changes[i] = r.(*EmailChange)
+	}
+	return changes
+}
@@ -23,9 +23,40 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/robfig/revel"
 	"reflect"
+	"strings"
 	"time"
 )
 
+// Thread, Posts and PostsReply fields
+const (
+	F_FORUM_ID          = "forum_id"
+	F_TYPE_ID           = "type_id"
+	F_REPLY_COUNT       = "reply_count"
+	F_LAST_POST_ID      = "last_post_id"
+	F_LAST_POST_USER_ID = "last_post_user_id"
+	F_LAST_POST_TIME    = "last_post_time"
+	F_IS_GOOD           = "is_good"
+	F_OPTIONS           = "options"
+	F_THREAD_ID         = "thread_id"
+	F_POSTS_ID          = "posts_id"
+)
+
+var (
+	ThreadFields = strings.Join([]string{
+		F_ID, F_ID_ALIAS, F_USER_ID, F_FORUM_ID, F_TYPE_ID, F_TITLE, F_CONTENT,
+		F_TAGS, F_SOURCE_URL, F_VIEW_COUNT, F_REPLY_COUNT, F_LAST_POST_ID,
+		F_LAST_POST_USER_ID, F_LAST_POST_TIME, F_IS_TOP, F_IS_GOOD, F_CLIENT_IP,
+		F_CREATED_TIME, F_LAST_MODIFIED_TIME, F_OPTIONS, F_STATUS,
+	}, ", ")
+	PostsFields = strings.Join([]string{
+		F_ID, F_THREAD_ID, F_USER_ID, F_USER_NAME, F_USER_EMAIL, F_USER_URL,
+		F_TITLE, F_CONTENT, F_CLIENT_IP, F_CREATED_TIME, F_OPTIONS, F_STATUS,
+	}, ", ")
+	PostsReplyFields = strings.Join([]string{
+		F_ID, F_POSTS_ID, F_USER_ID, F_USER_NAME, F_USER_EMAIL, F_USER_URL,
+	}, ", ")
+)
+
 // Forum model
 type Forum struct {
 	Id               uint16         `db:"id"`
@@ -0,0 +1,283 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"smart-kids/query"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+const (
+	FRIENDSHIP_TABLE = "sk_friendship"
+)
+
+// Friendship status constants
+const (
+	FRIENDSHIP_PENDING  = int16(1) // UserId requested FriendId
+	FRIENDSHIP_ACCEPTED = int16(2) // friends, both directions are accepted
+	FRIENDSHIP_BLOCKED  = int16(3) // UserId blocked FriendId
+)
+
+// sk_friendship fields constants
+const (
+	F_FRIEND_ID   = "friend_id"
+	F_FRIEND_NAME = "friend_name"
+)
+
+var (
+	FriendshipFields = strings.Join([]string{
+		F_USER_ID, F_USER_NAME, F_FRIEND_ID, F_FRIEND_NAME, F_STATUS,
+		F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+	}, ", ")
+
+	countFriendshipSql = fmt.Sprintf("SELECT count(*) FROM %s WHERE %s = ? AND %s = ? AND %s = ?",
+		FRIENDSHIP_TABLE, F_USER_ID, F_FRIEND_ID, F_STATUS)
+	deleteFriendshipSql = fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s = ?",
+		FRIENDSHIP_TABLE, F_USER_ID, F_FRIEND_ID)
+	deleteAcceptedSql = deleteFriendshipSql + fmt.Sprintf(" AND %s = ?", F_STATUS)
+	friendsSql        = query.SimpleQuerySql(FriendshipFields, FRIENDSHIP_TABLE, "x") +
+		fmt.Sprintf(" WHERE x.%s = ? AND x.%s = ?", F_USER_ID, F_STATUS)
+	countFriendsSql = query.CountSql(F_FRIEND_ID, FRIENDSHIP_TABLE) +
+		fmt.Sprintf(" WHERE x.%s = ? AND x.%s = ?", F_USER_ID, F_STATUS)
+	friendRequestsSql = query.SimpleQuerySql(FriendshipFields, FRIENDSHIP_TABLE, "x") +
+		fmt.Sprintf(" WHERE x.%s = ? AND x.%s = ?", F_FRIEND_ID, F_STATUS)
+	countFriendRequestsSql = query.CountSql(F_USER_ID, FRIENDSHIP_TABLE) +
+		fmt.Sprintf(" WHERE x.%s = ? AND x.%s = ?", F_FRIEND_ID, F_STATUS)
+	mutualCondition = fmt.Sprintf(" AND x.%s IN (SELECT %s FROM %s WHERE %s = ? AND %s = ?)",
+		F_FRIEND_ID, F_FRIEND_ID, FRIENDSHIP_TABLE, F_USER_ID, F_STATUS)
+
+	SelfFriendError       = errors.New("Friendship.self")
+	FriendBlockedError    = errors.New("Friendship.blocked")
+	AlreadyFriendsError   = errors.New("Friendship.alreadyFriends")
+	AlreadyRequestedError = errors.New("Friendship.alreadyRequested")
+	NoFriendRequestError  = errors.New("Friendship.noRequest")
+)
+
+// A directed relationship from UserId to FriendId. A friend request is a
+// pending row, two accepted rows of both directions are a friendship, and a
+// blocked row stops all requests of FriendId to UserId.
+type Friendship struct {
+	UserId           uint64         `db:"user_id" json:"uid"`
+	UserName         string         `db:"user_name" json:"userName"`
+	FriendId         uint64         `db:"friend_id" json:"fid"`
+	FriendName       string         `db:"friend_name" json:"friendName"`
+	Status           int16          `db:"status" json:"status"`
+	CreatedTime      mysql.NullTime `db:"created_time" json:"-"`
+	LastModifiedTime mysql.NullTime `db:"last_modified_time" json:"time"`
+}
+
+func NewFriendship(user, friend *User, status int16) *Friendship {
+	return &Friendship{
+		UserId: user.UserId, UserName: user.UserName,
+		FriendId: friend.UserId, FriendName: friend.UserName, Status: status,
+	}
+}
+
+func (f Friendship) String() string {
+	return fmt.Sprintf("Friendship{%d(%s) -> %d(%s), Status=%d}",
+		f.UserId, f.UserName, f.FriendId, f.FriendName, f.Status)
+}
+
+func (f *Friendship) PreInsert(_ gorp.SqlExecutor) error {
+	timeNow := time.Now()
+	f.CreatedTime = mysql.NullTime{timeNow, true}
+	f.LastModifiedTime = mysql.NullTime{timeNow, true}
+	return nil
+}
+
+func (f *Friendship) PreUpdate(_ gorp.SqlExecutor) error {
+	f.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+// Returns the Friendship from userId to friendId, or nil if not exists.
+func FindFriendship(exe gorp.SqlExecutor, userId, friendId uint64) *Friendship {
+	return ToFriendship(exe.Get(Friendship{}, userId, friendId))
+}
+
+// Returns true if the users are friends, it's a primary key lookup.
+func AreFriends(exe gorp.SqlExecutor, userId, otherId uint64) bool {
+	if userId == 0 || otherId == 0 || userId == otherId {
+		return false
+	}
+	count, err := exe.SelectInt(countFriendshipSql, userId, otherId, FRIENDSHIP_ACCEPTED)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+// Returns true if either of the users has blocked the other.
+func IsBlocked(exe gorp.SqlExecutor, userId, otherId uint64) bool {
+	for _, ids := range [][2]uint64{{userId, otherId}, {otherId, userId}} {
+		count, err := exe.SelectInt(countFriendshipSql, ids[0], ids[1], FRIENDSHIP_BLOCKED)
+		if err != nil {
+			panic(err)
+		}
+		if count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends a friend request from user to friend. The friendship is accepted
+// at once if the friend has requested the user.
+func RequestFriend(exe gorp.SqlExecutor, user, friend *User) (*Friendship, error) {
+	if user.UserId == friend.UserId {
+		return nil, SelfFriendError
+	}
+	if reverse := FindFriendship(exe, friend.UserId, user.UserId); reverse != nil {
+		switch reverse.Status {
+		case FRIENDSHIP_BLOCKED:
+			return nil, FriendBlockedError
+		case FRIENDSHIP_PENDING:
+			return AcceptFriend(exe, user, friend.UserId)
+		}
+	}
+	if f := FindFriendship(exe, user.UserId, friend.UserId); f != nil {
+		switch f.Status {
+		case FRIENDSHIP_ACCEPTED:
+			return nil, AlreadyFriendsError
+		case FRIENDSHIP_PENDING:
+			return nil, AlreadyRequestedError
+		}
+		// The user unblocks the friend by requesting.
+		f.Status = FRIENDSHIP_PENDING
+		_, err := exe.Update(f)
+		return f, err
+	}
+	f := NewFriendship(user, friend, FRIENDSHIP_PENDING)
+	return f, exe.Insert(f)
+}
+
+// Accepts the friend request of the requester to user, returns the
+// Friendship from user to the requester.
+func AcceptFriend(exe gorp.SqlExecutor, user *User, requesterId uint64) (*Friendship, error) {
+	request := FindFriendship(exe, requesterId, user.UserId)
+	if request == nil || request.Status != FRIENDSHIP_PENDING {
+		return nil, NoFriendRequestError
+	}
+	request.Status = FRIENDSHIP_ACCEPTED
+	if _, err := exe.Update(request); err != nil {
+		return nil, err
+	}
+	f := FindFriendship(exe, user.UserId, requesterId)
+	if f == nil {
+		f = &Friendship{UserId: user.UserId, UserName: user.UserName,
+			FriendId: requesterId, FriendName: request.UserName, Status: FRIENDSHIP_ACCEPTED}
+		return f, exe.Insert(f)
+	}
+	f.Status = FRIENDSHIP_ACCEPTED
+	_, err := exe.Update(f)
+	return f, err
+}
+
+// Rejects the friend request of the requester to the user.
+func RejectFriend(exe gorp.SqlExecutor, userId, requesterId uint64) error {
+	request := FindFriendship(exe, requesterId, userId)
+	if request == nil || request.Status != FRIENDSHIP_PENDING {
+		return NoFriendRequestError
+	}
+	_, err := exe.Delete(request)
+	return err
+}
+
+// Blocks the target, the friendship and requests between them are removed,
+// and the target can not request the user until unblocked.
+func BlockUser(exe gorp.SqlExecutor, user, target *User) (*Friendship, error) {
+	if user.UserId == target.UserId {
+		return nil, SelfFriendError
+	}
+	if _, err := exe.Exec(deleteFriendshipSql, target.UserId, user.UserId); err != nil {
+		return nil, err
+	}
+	f := FindFriendship(exe, user.UserId, target.UserId)
+	if f == nil {
+		f = NewFriendship(user, target, FRIENDSHIP_BLOCKED)
+		return f, exe.Insert(f)
+	}
+	f.Status = FRIENDSHIP_BLOCKED
+	_, err := exe.Update(f)
+	return f, err
+}
+
+// Removes the relationship from the user to the other: ends the friendship,
+// cancels the request or unblocks the other. Returns the removed rows.
+func Unfollow(exe gorp.SqlExecutor, userId, otherId uint64) (int64, error) {
+	count, err := execAffected(exe, deleteFriendshipSql, userId, otherId)
+	if err != nil {
+		return 0, err
+	}
+	reverse, err := execAffected(exe, deleteAcceptedSql, otherId, userId, FRIENDSHIP_ACCEPTED)
+	return count + reverse, err
+}
+
+// Returns the page friends of the user, the latest first.
+func FindFriends(exe gorp.SqlExecutor, userId uint64, pageable *util.Pageable) *util.Page {
+	return findFriendshipPage(exe, pageable, friendsSql, countFriendsSql,
+		userId, FRIENDSHIP_ACCEPTED)
+}
+
+// Returns the page pending friend requests to the user, the latest first.
+func FindFriendRequests(exe gorp.SqlExecutor, userId uint64, pageable *util.Pageable) *util.Page {
+	return findFriendshipPage(exe, pageable, friendRequestsSql, countFriendRequestsSql,
+		userId, FRIENDSHIP_PENDING)
+}
+
+// Returns the page friends of the user who are also friends of the other.
+func FindMutualFriends(exe gorp.SqlExecutor, userId, otherId uint64, pageable *util.Pageable) *util.Page {
+	return findFriendshipPage(exe, pageable, friendsSql+mutualCondition, countFriendsSql+mutualCondition,
+		userId, FRIENDSHIP_ACCEPTED, otherId, FRIENDSHIP_ACCEPTED)
+}
+
+func findFriendshipPage(exe gorp.SqlExecutor, pageable *util.Pageable, sql, countSql string,
+	args ...interface{}) *util.Page {
+	total, err := exe.SelectInt(countSql, args...)
+	if err != nil {
+		panic(err)
+	}
+	if total == 0 {
+		return util.NewPage(nil, pageable, total)
+	}
+	sql = query.NewSqlBuilder(sql).
+		PageOrderBy(pageable, util.DescendingSort([]string{F_LAST_MODIFIED_TIME})).
+		ToSqlString()
+	content, err := exe.Select(Friendship{}, sql, args...)
+	if err != nil {
+		panic(err)
+	}
+	return util.NewPage(content, pageable, total)
+}
+
+func ToFriendship(i interface{}, err error) *Friendship {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*Friendship)
+}
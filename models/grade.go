@@ -0,0 +1,227 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GRADE_RULE_TABLE = "sk_grade_rule"
+)
+
+// sk_grade_rule fields constants
+const (
+	F_GRADE_NAME      = "grade_name"
+	F_MIN_TOTAL_SCORE = "min_total_score"
+)
+
+var (
+	GradeRuleFields = strings.Join([]string{
+		F_ID, F_GRADE, F_GRADE_NAME, F_MIN_TOTAL_SCORE, F_CREATED_TIME,
+		F_LAST_MODIFIED_TIME,
+	}, ", ")
+
+	gradeRulesSql = fmt.Sprintf("SELECT %s FROM %s ORDER BY %s ASC",
+		GradeRuleFields, GRADE_RULE_TABLE, F_GRADE)
+	updateGradeSql = fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?",
+		USER_DIGITAL_TABLE, F_GRADE, F_USER_ID)
+
+	GradeRuleOrderError = errors.New("GradeRule.order")
+
+	currentGradeRules GradeRules
+	gradeRulesLock    sync.RWMutex
+	gradeChangedHooks []func(GradeChange)
+)
+
+// The user reaches the Grade when the TotalScore is not less than MinTotalScore.
+type GradeRule struct {
+	Id               uint           `db:"id" json:"-"`
+	Grade            uint           `db:"user_grade" json:"grade"`
+	Name             string         `db:"grade_name" json:"name"`
+	MinTotalScore    uint64         `db:"min_total_score" json:"minTotalScore"`
+	CreatedTime      mysql.NullTime `db:"created_time" json:"-"`
+	LastModifiedTime mysql.NullTime `db:"last_modified_time" json:"-"`
+}
+
+func (g GradeRule) String() string {
+	return fmt.Sprintf("GradeRule{Id=%d, Grade=%d, Name=%s, MinTotalScore=%d}",
+		g.Id, g.Grade, g.Name, g.MinTotalScore)
+}
+
+func (g *GradeRule) PreInsert(_ gorp.SqlExecutor) error {
+	timeNow := time.Now()
+	g.CreatedTime = mysql.NullTime{timeNow, true}
+	g.LastModifiedTime = mysql.NullTime{timeNow, true}
+	return nil
+}
+
+func (g *GradeRule) PreUpdate(_ gorp.SqlExecutor) error {
+	g.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+// Grade rules sorted by grade.
+type GradeRules []*GradeRule
+
+func (g GradeRules) Len() int           { return len(g) }
+func (g GradeRules) Less(i, j int) bool { return g[i].Grade < g[j].Grade }
+func (g GradeRules) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+
+// Returns the highest grade which MinTotalScore is reached by totalScore,
+// or 0 if no rule is reached.
+func (g GradeRules) GradeFor(totalScore uint64) uint {
+	grade := uint(0)
+	for _, rule := range g {
+		if totalScore >= rule.MinTotalScore && rule.Grade > grade {
+			grade = rule.Grade
+		}
+	}
+	return grade
+}
+
+// Returns the rule of the specified grade, or nil if not exists.
+func (g GradeRules) RuleOf(grade uint) *GradeRule {
+	for _, rule := range g {
+		if rule.Grade == grade {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Returns GradeRuleOrderError unless a higher grade requires a higher
+// MinTotalScore.
+func (g GradeRules) Validate() error {
+	sorted := make(GradeRules, len(g))
+	copy(sorted, g)
+	sort.Sort(sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Grade == sorted[i-1].Grade ||
+			sorted[i].MinTotalScore <= sorted[i-1].MinTotalScore {
+			return GradeRuleOrderError
+		}
+	}
+	return nil
+}
+
+// The grade change of a user, the grade may jump multiple levels.
+type GradeChange struct {
+	UserId   uint64     `json:"uid"`
+	UserName string     `json:"name"`
+	OldGrade uint       `json:"oldGrade"`
+	Grade    uint       `json:"grade"`
+	Rule     *GradeRule `json:"rule"`
+}
+
+// Returns true if the grade is raised.
+func (g GradeChange) IsUpgrade() bool {
+	return g.Grade > g.OldGrade
+}
+
+// Returns the grade rules stored in the table, sorted by grade.
+func LoadGradeRules(exe gorp.SqlExecutor) GradeRules {
+	results, err := exe.Select(GradeRule{}, gradeRulesSql)
+	if err != nil {
+		panic(err)
+	}
+	rules := make(GradeRules, len(results))
+	for i, r := range results {
+		rules[i] = r.(*GradeRule)
+	}
+	return rules
+}
+
+// Replaces the grade rules used by ApplyScore.
+func SetGradeRules(rules GradeRules) {
+	gradeRulesLock.Lock()
+	defer gradeRulesLock.Unlock()
+	currentGradeRules = rules
+}
+
+// Returns the grade rules used by ApplyScore.
+func CurrentGradeRules() GradeRules {
+	gradeRulesLock.RLock()
+	defer gradeRulesLock.RUnlock()
+	return currentGradeRules
+}
+
+// Registers a hook called by NotifyGradeChanged after the grade of a user is
+// changed and committed, the hooks are called in the caller's goroutine, they
+// must not block.
+func OnGradeChanged(hook func(GradeChange)) {
+	gradeRulesLock.Lock()
+	defer gradeRulesLock.Unlock()
+	gradeChangedHooks = append(gradeChangedHooks, hook)
+}
+
+// Re-evaluates the grade of the digital by the current grade rules, updates
+// the stored grade if it is changed. Returns nil if the grade is not changed,
+// the caller calls NotifyGradeChanged with the returned change after the
+// executor commits.
+func EvaluateGrade(exe gorp.SqlExecutor, digital *UserDigital) (*GradeChange, error) {
+	rules := CurrentGradeRules()
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	grade := rules.GradeFor(digital.TotalScore)
+	if grade == digital.Grade {
+		return nil, nil
+	}
+	if _, err := exe.Exec(updateGradeSql, grade, digital.UserId); err != nil {
+		return nil, err
+	}
+	change := &GradeChange{
+		UserId: digital.UserId, UserName: digital.UserName,
+		OldGrade: digital.Grade, Grade: grade, Rule: rules.RuleOf(grade),
+	}
+	digital.Grade = grade
+	return change, nil
+}
+
+// Calls the grade changed hooks with the committed change, does nothing if
+// the change is nil.
+func NotifyGradeChanged(change *GradeChange) {
+	if change == nil {
+		return
+	}
+	gradeRulesLock.RLock()
+	hooks := gradeChangedHooks
+	gradeRulesLock.RUnlock()
+	for _, hook := range hooks {
+		hook(*change)
+	}
+}
+
+func ToGradeRule(i interface{}, err error) *GradeRule {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*GradeRule)
+}
@@ -27,6 +27,15 @@ const (
 	FORUM_FIELD_TABLE       = "sk_forum_field"
 	FORUM_FIELD_VALUE_TABLE = "sk_forum_field_value"
 	FORUM_THREAD_TABLE      = "sk_forum_thread"
+	FORUM_POSTS_TABLE       = "sk_forum_posts"
+	FORUM_POSTS_REPLY_TABLE = "sk_forum_posts_reply"
+)
+
+// photo module table name constants
+const (
+	PHOTO_ALBUM_TABLE   = "sk_photo_album"
+	PHOTO_TABLE         = "sk_photo"
+	PHOTO_COMMENT_TABLE = "sk_photo_comment"
 )
 
 // model's shared field name constants
@@ -52,6 +61,7 @@ const (
 const (
 	PARAM_CLIENT_ID     = "client_id"
 	PARAM_CLIENT_SECRET = "client_secret"
+	PARAM_SESSION_TOKEN = "session_token"
 )
 
 var (
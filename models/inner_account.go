@@ -0,0 +1,239 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	INNER_ACCOUNT_TABLE = "sk_inner_account"
+
+	// The user ids from 1 to MAX_INNER_USER_ID are reserved for the inner
+	// accounts, the public registration starts from MAX_INNER_USER_ID + 1.
+	MAX_INNER_USER_ID = uint64(10000)
+)
+
+// InnerAccount table fields
+const (
+	F_KIND_CODE = "kind_code"
+)
+
+var (
+	InnerUserIdsExhaustedError = errors.New("InnerUser.idsExhausted")
+	InnerUserIdError           = errors.New("InnerUser.invalidId")
+
+	InnerAccountFields = strings.Join([]string{
+		F_USER_ID, F_USER_NAME, F_KIND_CODE, F_TITLE, F_IS_ACTIVE, F_OPERATOR_ID,
+		F_OPERATOR_NAME, F_LAST_MODIFIED_BY_ID, F_LAST_MODIFIED_BY_NAME,
+		F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+	}, ", ")
+
+	autoIncrementSql = "SELECT AUTO_INCREMENT FROM information_schema.TABLES " +
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+	reserveInnerUserIdsSql = fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d",
+		USER_TABLE, MAX_INNER_USER_ID+1)
+	maxInnerUserIdSql = fmt.Sprintf("SELECT COALESCE(MAX(%s), 0) FROM %s WHERE %s <= ?",
+		F_USER_ID, USER_TABLE, F_USER_ID)
+	setInnerUserActivatedSql = fmt.Sprintf("UPDATE %s SET %s = ?, %s = ? WHERE %s = ? AND %s <= ?",
+		USER_TABLE, F_IS_ACTIVATED, F_LAST_MODIFIED_TIME, F_USER_ID, F_USER_ID)
+)
+
+// The kinds of the inner accounts.
+type InnerKind struct {
+	Code uint16 `json:"code"`
+	Name string `json:"name"`
+}
+
+var (
+	K_OFFICIAL  = &InnerKind{uint16(1), "官方账号"}
+	K_ANNOUNCER = &InnerKind{uint16(2), "公告机器人"}
+	K_MODERATOR = &InnerKind{uint16(3), "版主"}
+	InnerKinds  = []*InnerKind{K_OFFICIAL, K_ANNOUNCER, K_MODERATOR}
+)
+
+// Returns the InnerKind of the specified code, or nil if not exists.
+func InnerKindOf(code uint16) *InnerKind {
+	for _, k := range InnerKinds {
+		if k.Code == code {
+			return k
+		}
+	}
+	return nil
+}
+
+// InnerAccount struct
+// ----------------------------------------------------------------------------
+
+// InnerAccount is the management record of an inner user, such as the
+// announcement bot and the moderator, which is created in ruler.
+type InnerAccount struct {
+	UserId             uint64         `db:"user_id"`
+	UserName           string         `db:"user_name"`
+	KindCode           uint16         `db:"kind_code"`
+	Title              string         `db:"title"`     // the badge title, e.g. "官方公告"
+	IsActive           bool           `db:"is_active"` // false if disabled
+	OperatorId         int            `db:"operator_id"`
+	OperatorName       string         `db:"operator_name"`
+	LastModifiedById   int            `db:"last_modified_by_id"`
+	LastModifiedByName string         `db:"last_modified_by_name"`
+	CreatedTime        mysql.NullTime `db:"created_time"`
+	LastModifiedTime   mysql.NullTime `db:"last_modified_time"`
+
+	// Transient property
+	Kind *InnerKind `db:"-"`
+}
+
+func (a InnerAccount) String() string {
+	return fmt.Sprintf("InnerAccount{UserId=%d,UserName=%s,Kind=%d,Title=%s,Active=%v}",
+		a.UserId, a.UserName, a.KindCode, a.Title, a.IsActive)
+}
+
+func (a *InnerAccount) PreInsert(_ gorp.SqlExecutor) error {
+	if a.Kind != nil {
+		a.KindCode = a.Kind.Code
+	}
+	timeNow := time.Now()
+	a.CreatedTime = mysql.NullTime{timeNow, true}
+	a.LastModifiedTime = mysql.NullTime{timeNow, true}
+	return nil
+}
+
+func (a *InnerAccount) PreUpdate(_ gorp.SqlExecutor) error {
+	if a.Kind != nil {
+		a.KindCode = a.Kind.Code
+	}
+	a.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+func (a *InnerAccount) PostGet(_ gorp.SqlExecutor) error {
+	a.Kind = InnerKindOf(a.KindCode)
+	return nil
+}
+
+// Raises the AUTO_INCREMENT of the user table above MAX_INNER_USER_ID, so the
+// registered users never receive the reserved ids. Returns true if raised.
+func ReserveInnerUserIds(exe gorp.SqlExecutor) (bool, error) {
+	next, err := exe.SelectInt(autoIncrementSql, USER_TABLE)
+	if err != nil {
+		return false, err
+	}
+	if uint64(next) > MAX_INNER_USER_ID {
+		return false, nil
+	}
+	if _, err = exe.Exec(reserveInnerUserIdsSql); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns the next free inner user id, or InnerUserIdsExhaustedError if all
+// reserved ids are used.
+func NextInnerUserId(exe gorp.SqlExecutor) (uint64, error) {
+	maxId, err := exe.SelectInt(maxInnerUserIdSql, MAX_INNER_USER_ID)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(maxId) >= MAX_INNER_USER_ID {
+		return 0, InnerUserIdsExhaustedError
+	}
+	return uint64(maxId) + 1, nil
+}
+
+// Creates the activated inner user with the next reserved id, and its
+// UserDigital, UserInfo and InnerAccount records. The executor must map the
+// User with the assigned (not auto-increment) key, as ruler does.
+func CreateInnerAccount(exe gorp.SqlExecutor, user *User, kind *InnerKind, title string,
+	operatorId int, operatorName string) (*InnerAccount, error) {
+	userId, err := NextInnerUserId(exe)
+	if err != nil {
+		return nil, err
+	}
+	user.UserId, user.IsActivated = userId, true
+	if user.Gender == nil {
+		user.Gender = GenderOf(user.GenderCode)
+	}
+	if err = exe.Insert(user.EncodePassword()); err != nil {
+		return nil, err
+	}
+	if user.UserId != userId {
+		return nil, InnerUserIdError
+	}
+	account := &InnerAccount{
+		UserId: user.UserId, UserName: user.UserName, Kind: kind, Title: title,
+		IsActive: true, OperatorId: operatorId, OperatorName: operatorName,
+		LastModifiedById: operatorId, LastModifiedByName: operatorName,
+	}
+	userInfo := NewUserInfoBuilder(nil).User(user).Builder()
+	if err = exe.Insert(NewDigital(user), userInfo, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Enables or disables the inner account by the operator, the disabled user
+// can not login and its login sessions are removed.
+func SetInnerAccountActive(exe gorp.SqlExecutor, account *InnerAccount, active bool,
+	operatorId int, operatorName string) error {
+	account.IsActive = active
+	account.LastModifiedById, account.LastModifiedByName = operatorId, operatorName
+	if _, err := exe.Update(account); err != nil {
+		return err
+	}
+	if _, err := exe.Exec(setInnerUserActivatedSql, active, time.Now(), account.UserId,
+		MAX_INNER_USER_ID); err != nil {
+		return err
+	}
+	if !active {
+		if _, err := DeleteUserSessions(exe, account.UserId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ToInnerAccount(i interface{}, err error) *InnerAccount {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*InnerAccount)
+}
+
+func ToInnerAccounts(results []interface{}, err error) []*InnerAccount {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	accounts := make([]*InnerAccount, size)
+	if size == 0 {
+		return accounts
+	}
+	for i, r := range results {
+		accounts[i] = r.(*InnerAccount)
+	}
+	return accounts
+}
@@ -21,9 +21,41 @@ import (
 	_ "fmt"
 	"github.com/coopernurse/gorp"
 	_ "github.com/go-sql-driver/mysql"
+	"strings"
 	"time"
 )
 
+// Photo module fields
+const (
+	F_ALBUM_NAME    = "album_name"
+	F_ALBUM_ID      = "album_id"
+	F_PHOTO_ID      = "photo_id"
+	F_TAGS          = "tags"
+	F_PHOTO_COUNT   = "photo_count"
+	F_VIEW_COUNT    = "view_count"
+	F_FRONT_COVER   = "front_cover"
+	F_V_CODE        = "v_code"
+	F_DESCRIPTION   = "description"
+	F_SOURCE_URL    = "source_url"
+	F_MEDIUM_URL    = "medium_url"
+	F_SMALL_URL     = "small_url"
+	F_THUMB_URL     = "thumb_url"
+	F_COMMENT_COUNT = "comment_count"
+)
+
+var (
+	PhotoAlbumFields = strings.Join([]string{
+		F_ID, F_ALBUM_NAME, F_USER_ID, F_TAGS, F_PHOTO_COUNT, F_VIEW_COUNT,
+		F_FRONT_COVER, F_V_CODE, F_CREATED_TIME,
+	}, ", ")
+	PhotoFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_ALBUM_ID, F_TAGS, F_DESCRIPTION, F_SOURCE_URL,
+		F_MEDIUM_URL, F_SMALL_URL, F_THUMB_URL, F_VIEW_COUNT, F_COMMENT_COUNT,
+		F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+	}, ", ")
+	PhotoCommentFields = BaseCommentFields + ", " + F_PHOTO_ID
+)
+
 type PhotoAlbum struct {
 	Id          uint64         `db:"id"`
 	Name        string         `db:"album_name"`
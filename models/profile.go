@@ -0,0 +1,116 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"smart-kids/lunar"
+	"time"
+)
+
+// The visibilities of the profile sections, V_PASSWORD is not supported.
+var (
+	ProfileVisibilities = []*Visibility{V_ALL, V_FRIENDS, V_SELF}
+)
+
+// Returns true if the code is a Visibility of the profile sections.
+func IsProfileVisibility(code uint16) bool {
+	for _, v := range ProfileVisibilities {
+		if v.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the section of the visibility code can be viewed. The
+// unset code 0 is the default V_ALL, and the unknown code is treated as V_SELF.
+func CanView(code uint16, isOwner, isFriend bool) bool {
+	switch code {
+	case 0, V_ALL.Code:
+		return true
+	case V_FRIENDS.Code:
+		return isOwner || isFriend
+	}
+	return isOwner
+}
+
+// Sets the unset visibilities of the sections to V_ALL.
+func (u *UserInfo) defaultVisibilities() {
+	for _, code := range []*uint16{&u.BirthdayVCode, &u.HometownVCode, &u.ResidenceVCode,
+		&u.EduVCode, &u.FeelingVCode, &u.BloodTypeVCode} {
+		if *code == 0 {
+			*code = V_ALL.Code
+		}
+	}
+}
+
+// ProfileView is the JSON of UserInfo viewed by the other users, the
+// sections which the viewer can not see are omitted.
+type ProfileView struct {
+	UserId           uint64         `json:"uid"`
+	UserName         string         `json:"userName"`
+	Nickname         string         `json:"nickname,omitempty"`
+	User             *User          `json:"user,omitempty"`
+	DateOfBirth      string         `json:"dateOfBirth,omitempty"`
+	LunarDateOfBirth *lunar.Date    `json:"lunarDateOfBirth,omitempty"`
+	Age              *int           `json:"age,omitempty"`
+	Constellation    *Constellation `json:"constellation,omitempty"`
+	Hometown         *Location      `json:"hometown,omitempty"`
+	PlaceOfResidence *Location      `json:"placeOfResidence,omitempty"`
+	OtherState       string         `json:"otherState,omitempty"`
+	Education        *Education     `json:"education,omitempty"`
+	Feeling          *Feeling       `json:"feeling,omitempty"`
+	BloodType        *BloodType     `json:"bloodType,omitempty"`
+}
+
+// Returns the ProfileView of the UserInfo viewed by the specified user, the
+// viewerId is 0 if not logged in. isFriend is true if the viewer is a friend
+// of the owner.
+func NewProfileView(u *UserInfo, viewerId uint64, isFriend bool) *ProfileView {
+	isOwner := viewerId > 0 && viewerId == u.UserId
+	view := &ProfileView{
+		UserId:   u.UserId,
+		UserName: u.UserName,
+		Nickname: u.Nickname.String,
+		User:     u.User,
+	}
+	if CanView(u.BirthdayVCode, isOwner, isFriend) && !u.DateOfBirth.IsZero() {
+		view.DateOfBirth = u.DateOfBirth.Format(DATE_OF_BIRTH_LAYOUT)
+		view.LunarDateOfBirth = u.LunarDateOfBirth
+		age := AgeAt(u.DateOfBirth, time.Now())
+		view.Age = &age
+		view.Constellation = u.Constellation
+	}
+	if CanView(u.HometownVCode, isOwner, isFriend) {
+		view.Hometown = u.Hometown
+	}
+	if CanView(u.ResidenceVCode, isOwner, isFriend) {
+		view.PlaceOfResidence = u.PlaceOfResidence
+		view.OtherState = u.OtherState.String
+	}
+	if CanView(u.EduVCode, isOwner, isFriend) {
+		view.Education = u.Education
+	}
+	if CanView(u.FeelingVCode, isOwner, isFriend) {
+		view.Feeling = u.Feeling
+	}
+	if CanView(u.BloodTypeVCode, isOwner, isFriend) {
+		view.BloodType = u.BloodType
+	}
+	return view
+}
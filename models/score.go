@@ -0,0 +1,241 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	SCORE_LEDGER_TABLE = "sk_score_ledger"
+)
+
+// sk_score_ledger fields constants
+const (
+	F_DELTA       = "delta"
+	F_REASON_CODE = "reason_code"
+	F_REF_ID      = "ref_id"
+)
+
+var (
+	ScoreLedgerFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_USER_NAME, F_DELTA, F_REASON_CODE, F_REF_ID,
+		F_OPERATOR_ID, F_OPERATOR_NAME, F_TOTAL_SCORE, F_SCORE, F_CREATED_TIME,
+	}, ", ")
+
+	addScoreSql = fmt.Sprintf("UPDATE %s SET %s = %s + ?, %s = %s + ?, %s = ? WHERE %s = ?",
+		USER_DIGITAL_TABLE, F_TOTAL_SCORE, F_TOTAL_SCORE, F_SCORE, F_SCORE,
+		F_LAST_MODIFIED_TIME, F_USER_ID)
+	subtractScoreSql = fmt.Sprintf("UPDATE %s SET %s = %s - ?, %s = ? WHERE %s = ? AND %s >= ?",
+		USER_DIGITAL_TABLE, F_SCORE, F_SCORE, F_LAST_MODIFIED_TIME, F_USER_ID, F_SCORE)
+	countUserDigitalSql = fmt.Sprintf("SELECT count(%s) FROM %s WHERE %s = ?",
+		F_USER_ID, USER_DIGITAL_TABLE, F_USER_ID)
+	scoreLedgersSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? ORDER BY %s DESC LIMIT ?, ?",
+		ScoreLedgerFields, SCORE_LEDGER_TABLE, F_USER_ID, F_ID)
+	countScoreLedgersSql = fmt.Sprintf("SELECT count(%s) FROM %s WHERE %s = ?",
+		F_ID, SCORE_LEDGER_TABLE, F_USER_ID)
+
+	zeroScoreDeltaError = errors.New("The delta of score must not be zero.")
+)
+
+type ScoreReason struct {
+	Code uint16 `json:"code"`
+	Name string `json:"name"`
+}
+
+// 积分变动原因
+var (
+	UNKNOWN_SCORE_REASON = &ScoreReason{uint16(0), "未知"}
+	SCORE_REGISTER       = &ScoreReason{uint16(1), "注册奖励"}
+	SCORE_DAILY_LOGIN    = &ScoreReason{uint16(2), "每日登录"}
+	SCORE_THREAD         = &ScoreReason{uint16(3), "发表主题"}
+	SCORE_POST           = &ScoreReason{uint16(4), "发表回复"}
+	SCORE_COMMENT        = &ScoreReason{uint16(5), "发表评论"}
+	SCORE_EXCHANGE       = &ScoreReason{uint16(6), "积分兑换"}
+	SCORE_ADJUSTMENT     = &ScoreReason{uint16(7), "管理员调整"}
+	scoreReasons         = map[uint16]*ScoreReason{
+		SCORE_REGISTER.Code:    SCORE_REGISTER,
+		SCORE_DAILY_LOGIN.Code: SCORE_DAILY_LOGIN,
+		SCORE_THREAD.Code:      SCORE_THREAD,
+		SCORE_POST.Code:        SCORE_POST,
+		SCORE_COMMENT.Code:     SCORE_COMMENT,
+		SCORE_EXCHANGE.Code:    SCORE_EXCHANGE,
+		SCORE_ADJUSTMENT.Code:  SCORE_ADJUSTMENT,
+	}
+)
+
+func ScoreReasonOf(code uint16, def *ScoreReason) *ScoreReason {
+	if reason, exists := scoreReasons[code]; exists {
+		return reason
+	}
+	return def
+}
+
+// Append-only entry of the score changes of a user, TotalScore and Score
+// are the values after this change.
+type ScoreLedger struct {
+	Id           uint64         `db:"id" json:"id"`
+	UserId       uint64         `db:"user_id" json:"uid"`
+	UserName     string         `db:"user_name" json:"name"`
+	Delta        int64          `db:"delta" json:"delta"`
+	ReasonCode   uint16         `db:"reason_code" json:"-"`
+	RefId        string         `db:"ref_id" json:"refId"` // the id of the thread, order, etc.
+	OperatorId   int            `db:"operator_id" json:"-"`
+	OperatorName string         `db:"operator_name" json:"-"`
+	TotalScore   uint64         `db:"total_score" json:"totalScore"`
+	Score        uint64         `db:"user_score" json:"score"`
+	CreatedTime  mysql.NullTime `db:"created_time" json:"-"`
+
+	// Transient property
+	Reason      *ScoreReason `db:"-" json:"reason"`
+	GradeChange *GradeChange `db:"-" json:"gradeChange,omitempty"` // set by ApplyScore
+}
+
+// Returns a new ScoreLedger of the specified user, a positive delta adds
+// both the total and current score, a negative one only subtracts the
+// current score.
+func NewScoreLedger(userId uint64, userName string, delta int64, reason *ScoreReason,
+	refId string) *ScoreLedger {
+	return &ScoreLedger{
+		UserId: userId, UserName: userName, Delta: delta,
+		ReasonCode: reason.Code, RefId: refId, Reason: reason,
+	}
+}
+
+// Sets the admin who made this change, the system is the operator by default.
+func (s *ScoreLedger) OperatedBy(operatorId int, operatorName string) *ScoreLedger {
+	s.OperatorId, s.OperatorName = operatorId, operatorName
+	return s
+}
+
+func (s ScoreLedger) String() string {
+	return fmt.Sprintf("ScoreLedger{Id=%d, User=(%d, %s), Delta=%d, ReasonCode=%d, "+
+		"RefId=%s, Operator=(%d, %s), TotalScore=%d, Score=%d}", s.Id, s.UserId,
+		s.UserName, s.Delta, s.ReasonCode, s.RefId, s.OperatorId, s.OperatorName,
+		s.TotalScore, s.Score)
+}
+
+func (s *ScoreLedger) PreInsert(_ gorp.SqlExecutor) error {
+	s.CreatedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+func (s *ScoreLedger) PostGet(_ gorp.SqlExecutor) error {
+	s.Reason = ScoreReasonOf(s.ReasonCode, UNKNOWN_SCORE_REASON)
+	return nil
+}
+
+// Applies the score change of the entry to the user's digital and appends
+// the entry in the same executor, the update is done by a single SQL
+// statement, so concurrent changes never lose one another. Returns
+// InsufficientScoreError if the current score is less than the subtrahend,
+// the executor should be a transaction and rolled back on error.
+// The grade is re-evaluated when the total score is changed, the entry's
+// GradeChange is set if the grade is changed, and should be passed to
+// NotifyGradeChanged after the executor commits.
+func ApplyScore(exe gorp.SqlExecutor, entry *ScoreLedger) error {
+	var (
+		affected int64
+		err      error
+	)
+	timeNow := time.Now()
+	switch {
+	case entry.Delta > 0:
+		affected, err = execAffected(exe, addScoreSql, entry.Delta, entry.Delta, timeNow,
+			entry.UserId)
+	case entry.Delta < 0:
+		affected, err = execAffected(exe, subtractScoreSql, -entry.Delta, timeNow,
+			entry.UserId, -entry.Delta)
+	default:
+		return zeroScoreDeltaError
+	}
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		count, err := exe.SelectInt(countUserDigitalSql, entry.UserId)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return DigitalNotFoundError
+		}
+		return InsufficientScoreError
+	}
+	digital := FindUserDigital(exe, entry.UserId)
+	entry.TotalScore, entry.Score = digital.TotalScore, digital.Score
+	if entry.Delta > 0 {
+		if entry.GradeChange, err = EvaluateGrade(exe, digital); err != nil {
+			return err
+		}
+	}
+	return exe.Insert(entry)
+}
+
+// Returns the score ledgers of the specified user, the newest first, and the
+// count of all ledgers of the user.
+func FindScoreLedgers(exe gorp.SqlExecutor, userId uint64, offset, limit int) ([]*ScoreLedger, int64) {
+	total, err := exe.SelectInt(countScoreLedgersSql, userId)
+	if err != nil {
+		panic(err)
+	}
+	if total == 0 {
+		return []*ScoreLedger{}, 0
+	}
+	return ToScoreLedgers(exe.Select(ScoreLedger{}, scoreLedgersSql, userId, offset, limit)), total
+}
+
+// Executes the statement and returns the count of affected rows.
+func execAffected(exe gorp.SqlExecutor, query string, args ...interface{}) (int64, error) {
+	res, err := exe.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func ToScoreLedger(i interface{}, err error) *ScoreLedger {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*ScoreLedger)
+}
+
+func ToScoreLedgers(results []interface{}, err error) []*ScoreLedger {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	scoreLedgers := make([]*ScoreLedger, size)
+	if size == 0 {
+		return scoreLedgers
+	}
+	for i, r := range results {
+		scoreLedgers[i] = r.(*ScoreLedger)
+	}
+	return scoreLedgers
+}
@@ -19,6 +19,7 @@ package models
 import (
 	"database/sql"
 	_ "encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/coopernurse/gorp"
@@ -26,6 +27,10 @@ import (
 	"github.com/robfig/revel"
 	"reflect"
 	"regexp"
+	"smart-kids/crypt"
+	"smart-kids/lunar"
+	"smart-kids/passwd"
+	"smart-kids/util"
 	"strings"
 	"time"
 )
@@ -44,6 +49,7 @@ var (
 
 	InsufficientBalanceError = errors.New("Insufficient.balance")
 	InsufficientScoreError   = errors.New("Insufficient.score")
+	DigitalNotFoundError     = errors.New("Digital.notFound")
 )
 
 // User table fields
@@ -52,6 +58,7 @@ const (
 	F_PASSWORD_SALT    = "password_salt"
 	F_EMAIL            = "email"
 	F_SPARE_EMAIL      = "spare_email"
+	F_SPARE_VERIFIED   = "is_spare_email_verified"
 	F_GENDER_CODE      = "gender_code"
 	F_AVATAR_URI       = "avatar_uri"
 	F_SMALL_AVATAR_URI = "small_avatar_uri"
@@ -63,10 +70,12 @@ var (
 	UserFields = strings.Join([]string{
 		F_USER_ID, F_EMAIL, F_USER_NAME, F_HASH_PASSWORD, F_PASSWORD_SALT,
 		F_GENDER_CODE, F_AVATAR_URI, F_SMALL_AVATAR_URI, F_THUMB_AVATAR_URI,
-		F_SPARE_EMAIL, F_IS_ACTIVATED, F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+		F_SPARE_EMAIL, F_SPARE_VERIFIED, F_IS_ACTIVATED, F_CREATED_TIME,
+		F_LAST_MODIFIED_TIME,
 	}, ", ")
 
 	userNameRegexp = regexp.MustCompile("^\\w+$")
+	emailRegexp    = regexp.MustCompile("^[\\w.%+\\-]+@[\\w.\\-]+\\.[a-zA-Z]{2,}$")
 )
 
 // User model for mapping table `sk_user`
@@ -81,6 +90,7 @@ type User struct {
 	SmallAvatarUri   sql.NullString `db:"small_avatar_uri"` // 50 x 50
 	ThumbAvatarUri   sql.NullString `db:"thumb_avatar_uri"` // 25 x 25
 	SpareEmail       sql.NullString `db:"spare_email" json:"-"`
+	IsSpareVerified  bool           `db:"is_spare_email_verified" json:"-"` // only the verified one is used to recover
 	IsActivated      bool           `db:"is_activated"`
 	CreatedTime      time.Time      `db:"created_time" json:"created"`
 	LastModifiedTime time.Time      `db:"last_modified_time" json:"-"`
@@ -92,7 +102,16 @@ type User struct {
 
 // Returns true if this user is inner account, otherwise false.
 func (u User) IsInnerUser() bool {
-	return u.UserId <= uint64(10000)
+	return u.UserId > 0 && u.UserId <= MAX_INNER_USER_ID
+}
+
+// The JSON output has the "official" badge of the inner account.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	return json.Marshal(struct {
+		user
+		Official bool `json:"official"`
+	}{user(u), u.IsInnerUser()})
 }
 
 func (u User) String() string {
@@ -108,9 +127,19 @@ func (user *User) Validate(v *revel.Validation) {
 		revel.Match{userNameRegexp},
 	)
 
+	ValidateEmail(v, user.Email).Key("user.Email")
+
 	ValidatePassword(v, user.Password).Key("user.Password")
 }
 
+func ValidateEmail(v *revel.Validation, email string) *revel.ValidationResult {
+	return v.Check(email,
+		revel.Required{},
+		revel.MaxSize{50},
+		revel.Match{emailRegexp},
+	)
+}
+
 func ValidatePassword(v *revel.Validation, password string) *revel.ValidationResult {
 	return v.Check(password,
 		revel.Required{},
@@ -119,6 +148,29 @@ func ValidatePassword(v *revel.Validation, password string) *revel.ValidationRes
 	)
 }
 
+// Hashes the transient Password with the current password hasher into
+// HashPassword, the salt is a part of the hash.
+func (u *User) EncodePassword() *User {
+	hashPwd, err := passwd.Hash(u.Password)
+	if err != nil {
+		panic(err)
+	}
+	u.HashPassword, u.PasswordSalt = hashPwd, ""
+	return u
+}
+
+// Returns true if the given password matches this user's HashPassword.
+// A legacy or outdated hash is re-hashed with the current hasher at the
+// same time, upgraded is true if the user should be updated.
+func (u *User) MatchPassword(password string) (matched bool, upgraded bool) {
+	matched, rehashed := passwd.Check(password, u.HashPassword, u.PasswordSalt)
+	if matched && len(rehashed) > 0 {
+		u.HashPassword, u.PasswordSalt = rehashed, ""
+		return true, true
+	}
+	return matched, false
+}
+
 func (u *User) PreInsert(_ gorp.SqlExecutor) error {
 	if u.Gender != nil {
 		u.GenderCode = u.Gender.Code
@@ -129,6 +181,11 @@ func (u *User) PreInsert(_ gorp.SqlExecutor) error {
 	return nil
 }
 
+func (u *User) PreUpdate(_ gorp.SqlExecutor) error {
+	u.LastModifiedTime = time.Now()
+	return nil
+}
+
 func (u *User) PostGet() error {
 	u.Gender = GenderOf(u.GenderCode)
 	return nil
@@ -183,6 +240,9 @@ var (
 		F_NEWS_COMMENTS, F_IMAGE_COMMENTS, F_IMAGE_COMMENT_REPLIES,
 		F_LAST_MODIFIED_TIME,
 	}, ", ")
+
+	userDigitalByIdSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		UserDigitalFields, USER_DIGITAL_TABLE, F_USER_ID)
 )
 
 type UserDigital struct {
@@ -208,7 +268,8 @@ func NewDigital(user *User) *UserDigital {
 	return digital
 }
 
-// Add the score, and the total score.
+// Add the score, and the total score. Only changes this struct, the stored
+// score is changed by ApplyScore.
 func (u *UserDigital) AddScore(score uint64) *UserDigital {
 	u.TotalScore = u.TotalScore + score
 	u.Score = u.Score + score
@@ -223,7 +284,8 @@ func (u *UserDigital) AddAndGetOldScore(score uint64) (oldTotalScore uint64, old
 	return oldTotalScore, oldScore
 }
 
-// Subtract the score, but not change total score.
+// Subtract the score, but not change total score. Only changes this struct,
+// the stored score is changed by ApplyScore.
 func (u *UserDigital) SubtractScore(score uint64) (uint64, error) {
 	oldScore := u.Score
 	if u.Score < score {
@@ -262,6 +324,40 @@ func (u UserDigital) String() string {
 		u.NewsComments, u.ImageComments, u.ImageCommentReplies, u.LastModifiedTime)
 }
 
+// Returns the stored UserDigital of the specified user, or nil if not exists.
+func FindUserDigital(exe gorp.SqlExecutor, userId uint64) *UserDigital {
+	digitals := ToUserDigitals(exe.Select(UserDigital{}, userDigitalByIdSql, userId))
+	if len(digitals) == 0 {
+		return nil
+	}
+	return digitals[0]
+}
+
+func ToUserDigital(i interface{}, err error) *UserDigital {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*UserDigital)
+}
+
+func ToUserDigitals(results []interface{}, err error) []*UserDigital {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	digitals := make([]*UserDigital, size)
+	if size == 0 {
+		return digitals
+	}
+	for i, r := range results {
+		digitals[i] = r.(*UserDigital)
+	}
+	return digitals
+}
+
 // UserIdentity struct
 // ----------------------------------------------------------------------------
 
@@ -269,29 +365,54 @@ func (u UserDigital) String() string {
 const (
 	F_READ_NAME     = "real_name"
 	F_IDCARD        = "idcard"
+	F_IDCARD_HASH   = "idcard_hash"
 	F_IS_VARIFIED   = "is_varified"
 	F_ID_IMG_DOMAIN = "id_img_domain"
 	F_ID_IMG_PATH   = "id_img_path"
 	F_VARIFIED_DATE = "varified_date"
+	F_VERIFY_STATUS = "verify_status"
+	F_REJECT_CAUSE  = "reject_cause"
+	F_REVIEWER_ID   = "reviewer_id"
+	F_REVIEWER_NAME = "reviewer_name"
+	F_SUBMIT_TIME   = "submit_time"
+)
+
+// UserIdentity verify status constants
+const (
+	IDENTITY_NONE     = uint16(0) // 未提交
+	IDENTITY_PENDING  = uint16(1) // 待审核
+	IDENTITY_APPROVED = uint16(2) // 已通过
+	IDENTITY_REJECTED = uint16(3) // 已拒绝
 )
 
 // UserIdentity variables
 var (
 	UserIdentityFields = strings.Join([]string{
-		F_USER_ID, F_READ_NAME, F_GENDER_CODE, F_IDCARD, F_IS_VARIFIED,
-		F_ID_IMG_DOMAIN, F_ID_IMG_PATH, F_VARIFIED_DATE, F_LAST_MODIFIED_TIME,
+		F_USER_ID, F_READ_NAME, F_GENDER_CODE, F_IDCARD, F_IDCARD_HASH, F_IS_VARIFIED,
+		F_ID_IMG_DOMAIN, F_ID_IMG_PATH, F_VARIFIED_DATE, F_VERIFY_STATUS,
+		F_REJECT_CAUSE, F_REVIEWER_ID, F_REVIEWER_NAME, F_SUBMIT_TIME,
+		F_LAST_MODIFIED_TIME,
 	}, ", ")
 )
 
+// RealName and Idcard are encrypted in the table by the crypt default keyring,
+// they are decrypted after loaded or saved. IdcardHash is the blind index of
+// Idcard for lookup.
 type UserIdentity struct {
 	UserId           uint64         `db:"user_id"`
 	RealName         string         `db:"real_name"` // user real name
 	GenderCode       uint16         `db:"gender_code"`
-	Idcard           string         `db:"idcard"`      // identity card number
-	IsVarified       bool           `db:"is_varified"` // 0 or 1 in db
-	IdImgDomain      sql.NullString `db:"id_img_domain"`
-	IdImgPath        sql.NullString `db:"id_img_path"`
-	VarifiedDate     mysql.NullTime `db:"varified_date"` // 0000-00-00 in db
+	Idcard           string         `db:"idcard"`        // identity card number
+	IdcardHash       string         `db:"idcard_hash"`   // Index
+	IsVarified       bool           `db:"is_varified"`   // 0 or 1 in db, true only if approved
+	IdImgDomain      sql.NullString `db:"id_img_domain"` // null since the image is saved by the storage
+	IdImgPath        sql.NullString `db:"id_img_path"`   // the storage uri of the image
+	VarifiedDate     mysql.NullTime `db:"varified_date"` // 0000-00-00 in db, set on approval
+	Status           uint16         `db:"verify_status"`
+	RejectCause      string         `db:"reject_cause"`
+	ReviewerId       int            `db:"reviewer_id"`
+	ReviewerName     string         `db:"reviewer_name"`
+	SubmitTime       mysql.NullTime `db:"submit_time"`
 	LastModifiedTime mysql.NullTime `db:"last_modified_time"`
 
 	Gender *Gender `db:"-"`
@@ -299,37 +420,146 @@ type UserIdentity struct {
 
 func (u *UserIdentity) String() string {
 	return fmt.Sprintf("UserIdentity{UserId=%d, RealName=%s, Idcard=%s, "+
-		"IsVarified=%v, IdImgDomain=%v, IdImgPath=%v, VarifiedDate=%v, LastModifiedTime=%v}",
-		u.UserId, u.RealName, u.Idcard, u.IsVarified, u.IdImgDomain, u.IdImgPath,
-		u.VarifiedDate, u.LastModifiedTime)
+		"IsVarified=%v, IdImgDomain=%v, IdImgPath=%v, VarifiedDate=%v, Status=%d, "+
+		"Reviewer=(%d, %s), LastModifiedTime=%v}",
+		u.UserId, u.MaskedRealName(), u.MaskedIdcard(), u.IsVarified, u.IdImgDomain,
+		u.IdImgPath, u.VarifiedDate, u.Status, u.ReviewerId, u.ReviewerName, u.LastModifiedTime)
 }
 
-func (u *UserIdentity) PreInsert(_ gorp.SqlExecutor) error {
-	timeNow := time.Now()
-	if u.IdImgDomain.Valid && u.IdImgPath.Valid && len(u.Idcard) > 0 &&
-		len(u.RealName) > 0 {
-		u.IsVarified = true
-		u.VarifiedDate = mysql.NullTime{timeNow, true}
+// Returns the real name masked except the first character.
+func (u UserIdentity) MaskedRealName() string {
+	return util.MaskName(u.RealName)
+}
+
+// Returns the idcard number masked except the first and last 4 characters.
+func (u UserIdentity) MaskedIdcard() string {
+	return util.MaskIdcard(u.Idcard)
+}
+
+// The JSON output only has the masked real name and idcard number.
+func (u UserIdentity) MarshalJSON() ([]byte, error) {
+	type identity UserIdentity
+	return json.Marshal(struct {
+		identity
+		RealName   string
+		Idcard     string
+		IdcardHash string `json:"-"`
+	}{identity(u), u.MaskedRealName(), u.MaskedIdcard(), ""})
+}
+
+// Sets IdcardHash and encrypts RealName and Idcard before saved.
+func (u *UserIdentity) encrypt() (err error) {
+	if crypt.IsEnvelope(u.Idcard) || crypt.IsEnvelope(u.RealName) {
+		return errors.New("The UserIdentity is already encrypted.")
+	}
+	if u.IdcardHash, err = crypt.BlindIndex(u.Idcard); err != nil {
+		return err
+	}
+	if u.RealName, err = crypt.Encrypt(u.RealName); err != nil {
+		return err
 	}
-	if u.Gender.Code > uint16(0) {
+	u.Idcard, err = crypt.Encrypt(u.Idcard)
+	return err
+}
+
+// Decrypts RealName and Idcard after loaded or saved.
+func (u *UserIdentity) decrypt() (err error) {
+	if u.RealName, err = crypt.Decrypt(u.RealName); err != nil {
+		return err
+	}
+	u.Idcard, err = crypt.Decrypt(u.Idcard)
+	return err
+}
+
+// Returns true if RealName or Idcard is stored as legacy plaintext or not
+// encrypted by the current key, must be called before decrypted.
+func (u UserIdentity) NeedsReencrypt(k *crypt.Keyring) bool {
+	return k.NeedsReencrypt(u.RealName) || k.NeedsReencrypt(u.Idcard)
+}
+
+// Returns the blind index of the idcard number for lookup by IdcardHash.
+func IdcardHashOf(idcard string) string {
+	hash, err := crypt.BlindIndex(idcard)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// Marks this identity pending for review, the previous review result is cleared.
+func (u *UserIdentity) Submit() *UserIdentity {
+	u.Status, u.IsVarified, u.RejectCause = IDENTITY_PENDING, false, ""
+	u.ReviewerId, u.ReviewerName = 0, ""
+	u.VarifiedDate = mysql.NullTime{}
+	u.SubmitTime = mysql.NullTime{time.Now(), true}
+	return u
+}
+
+// Approves this identity by the reviewer, VarifiedDate is set.
+func (u *UserIdentity) Approve(reviewerId int, reviewerName string) *UserIdentity {
+	u.Status, u.IsVarified, u.RejectCause = IDENTITY_APPROVED, true, ""
+	u.ReviewerId, u.ReviewerName = reviewerId, reviewerName
+	u.VarifiedDate = mysql.NullTime{time.Now(), true}
+	return u
+}
+
+// Rejects this identity by the reviewer with the cause.
+func (u *UserIdentity) Reject(reviewerId int, reviewerName, cause string) *UserIdentity {
+	u.Status, u.IsVarified, u.RejectCause = IDENTITY_REJECTED, false, cause
+	u.ReviewerId, u.ReviewerName = reviewerId, reviewerName
+	u.VarifiedDate = mysql.NullTime{}
+	return u
+}
+
+// Returns true if this identity is waiting for review.
+func (u UserIdentity) IsPending() bool {
+	return u.Status == IDENTITY_PENDING
+}
+
+func (u *UserIdentity) PreInsert(_ gorp.SqlExecutor) error {
+	if u.Gender != nil && u.Gender.Code > uint16(0) {
 		u.GenderCode = u.Gender.Code
 	}
-	u.LastModifiedTime = mysql.NullTime{timeNow, true}
-	return nil
+	u.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return u.encrypt()
+}
+
+func (u *UserIdentity) PostInsert(_ gorp.SqlExecutor) error {
+	return u.decrypt()
+}
+
+func (u *UserIdentity) PreUpdate(_ gorp.SqlExecutor) error {
+	u.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return u.encrypt()
+}
+
+func (u *UserIdentity) PostUpdate(_ gorp.SqlExecutor) error {
+	return u.decrypt()
 }
 
 func (u *UserIdentity) PostGet(_ gorp.SqlExecutor) error {
 	if u.GenderCode > 0 {
 		u.Gender = GenderOf(u.GenderCode)
 	}
-	return nil
+	return u.decrypt()
 }
 
+// Returns the url of the submitted ID image, or empty if no image.
 func (u UserIdentity) IdImageUrl() string {
-	if !u.IsVarified {
+	if !u.IdImgPath.Valid || len(u.IdImgPath.String) == 0 {
 		return ""
 	}
-	return fmt.Sprintf("%s%s", u.IdImgDomain, u.IdImgPath)
+	return fmt.Sprintf("%s%s", u.IdImgDomain.String, u.IdImgPath.String)
+}
+
+func ToUserIdentity(i interface{}, err error) *UserIdentity {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*UserIdentity)
 }
 
 // sk_user_info fields constant
@@ -337,12 +567,13 @@ const (
 	F_NICKNAME         = "nickname"
 	F_CALENDAR_MODE    = "calendar_mode"
 	F_DATE_OF_BIRTH    = "date_of_birth"
+	F_LUNAR_BIRTH      = "lunar_date_of_birth"
 	F_HT_COUNTRY_ID    = "ht_country_id"
-	F_HT_STATE_ID      = "ht_state_id"
+	F_HT_STATE_ID      = "ht_province_id"
 	F_HT_CITY_ID       = "ht_city_id"
 	F_HT_DIST_ID       = "ht_dist_id"
 	F_POR_COUNTRY_ID   = "por_country_id"
-	F_POR_STATE_ID     = "por_state_id"
+	F_POR_STATE_ID     = "por_province_id"
 	F_POR_CITY_ID      = "por_city_id"
 	F_POR_DIST_ID      = "por_dist_id"
 	F_OTHER_STATE      = "other_state"
@@ -350,14 +581,32 @@ const (
 	F_FEELING_ID       = "feeling_id"
 	F_BLOOD_TYPE_ID    = "blood_type_id"
 	F_CONSTELLATION_ID = "constellation_id"
+	F_BIRTHDAY_V_CODE  = "birthday_v_code"
+	F_HOMETOWN_V_CODE  = "hometown_v_code"
+	F_RESIDENCE_V_CODE = "residence_v_code"
+	F_EDU_V_CODE       = "edu_v_code"
+	F_FEELING_V_CODE   = "feeling_v_code"
+	F_BLOOD_V_CODE     = "blood_type_v_code"
+)
+
+// UserInfo calendar modes of DateOfBirth
+const (
+	CALENDAR_SOLAR = int16(0)
+	CALENDAR_LUNAR = int16(1) // Chinese lunar calendar
+)
+
+const (
+	DATE_OF_BIRTH_LAYOUT = "2006-01-02"
 )
 
 var (
 	UserInfoFields = strings.Join([]string{
 		F_USER_ID, F_USER_NAME, F_NICKNAME, F_GENDER_CODE, F_CALENDAR_MODE,
-		F_DATE_OF_BIRTH, F_HT_COUNTRY_ID, F_HT_STATE_ID, F_HT_CITY_ID,
+		F_DATE_OF_BIRTH, F_LUNAR_BIRTH, F_HT_COUNTRY_ID, F_HT_STATE_ID, F_HT_CITY_ID,
 		F_HT_DIST_ID, F_POR_COUNTRY_ID, F_POR_STATE_ID, F_POR_CITY_ID, F_POR_DIST_ID,
 		F_OTHER_STATE, F_EDU_ID, F_FEELING_ID, F_BLOOD_TYPE_ID, F_CONSTELLATION_ID,
+		F_BIRTHDAY_V_CODE, F_HOMETOWN_V_CODE, F_RESIDENCE_V_CODE, F_EDU_V_CODE,
+		F_FEELING_V_CODE, F_BLOOD_V_CODE,
 	}, ", ")
 )
 
@@ -370,6 +619,7 @@ type UserInfo struct {
 	Nickname         sql.NullString `db:"nickname" json:"nickname,omitempty"`
 	CalendarMode     int16          `db:"calendar_mode"`
 	DateOfBirthStr   sql.NullString `db:"date_of_birth" json:"-"`
+	LunarBirthStr    sql.NullString `db:"lunar_date_of_birth" json:"-"` // if CalendarMode is lunar
 	HtCountryId      uint           `db:"ht_country_id" json:"-"`
 	HtStateId        uint           `db:"ht_province_id" json:"-"`
 	HtCityId         uint           `db:"ht_city_id" json:"-"`
@@ -383,11 +633,18 @@ type UserInfo struct {
 	FeelingId        uint16         `db:"feeling_id" json:"-"`
 	BloodTypeId      uint16         `db:"blood_type_id" json:"-"`
 	ConstellationId  uint16         `db:"constellation_id" json:"-"`
+	BirthdayVCode    uint16         `db:"birthday_v_code" json:"birthdayVCode"` // Visibility of the sections
+	HometownVCode    uint16         `db:"hometown_v_code" json:"hometownVCode"`
+	ResidenceVCode   uint16         `db:"residence_v_code" json:"residenceVCode"`
+	EduVCode         uint16         `db:"edu_v_code" json:"eduVCode"`
+	FeelingVCode     uint16         `db:"feeling_v_code" json:"feelingVCode"`
+	BloodTypeVCode   uint16         `db:"blood_type_v_code" json:"bloodTypeVCode"`
 	CreatedTime      mysql.NullTime `db:"created_time"`
 	LastModifiedTime mysql.NullTime `db:"last_modified_time"`
 
 	// Transient
 	DateOfBirth      time.Time      `db:"-" json:"dateOfBirth,omitempty"`
+	LunarDateOfBirth *lunar.Date    `db:"-" json:"lunarDateOfBirth,omitempty"`
 	User             *User          `db:"-" json:"user,omitempty"`
 	Hometown         *Location      `db:"-" json:"hometown,omitempty"`
 	PlaceOfResidence *Location      `db:"-" json:"placeOfResidence,omitempty"`
@@ -399,13 +656,22 @@ type UserInfo struct {
 
 // UserInfo struct builder
 // Examples:
-// builder := &UserInfoBuilder{&UserInfo{}}
+// builder := NewUserInfoBuilder(nil)
 // userInfo := builder.User(user).Nickname("MyName")
 // .Education(EducationOf(2))...Feeling(FeelingOf(2)).Builder()
 type UserInfoBuilder struct {
 	userInfo *UserInfo
 }
 
+// Returns a new UserInfoBuilder of the specified userInfo,
+// or an empty UserInfo if userInfo is nil.
+func NewUserInfoBuilder(userInfo *UserInfo) *UserInfoBuilder {
+	if userInfo == nil {
+		userInfo = &UserInfo{}
+	}
+	return &UserInfoBuilder{userInfo}
+}
+
 // Set a pointer to user for this builder
 func (u *UserInfoBuilder) User(user *User) *UserInfoBuilder {
 	u.userInfo.User = user
@@ -422,6 +688,16 @@ func (u *UserInfoBuilder) Nickname(nickname string) *UserInfoBuilder {
 	return u
 }
 
+// Set other state for this builder
+func (u *UserInfoBuilder) OtherState(otherState string) *UserInfoBuilder {
+	if len(otherState) == 0 {
+		u.userInfo.OtherState = sql.NullString{"", false}
+	} else {
+		u.userInfo.OtherState = sql.NullString{otherState, true}
+	}
+	return u
+}
+
 // Set a pointer to Location for this builder
 func (u *UserInfoBuilder) Hometown(hometown *Location) *UserInfoBuilder {
 	u.userInfo.Hometown = hometown
@@ -452,7 +728,8 @@ func (u *UserInfoBuilder) BloodType(bloodType *BloodType) *UserInfoBuilder {
 	return u
 }
 
-// Set a pointer to Constellation for this builder
+// Set a pointer to Constellation for this builder, it's replaced by the
+// constellation of the date of birth when saved.
 func (u *UserInfoBuilder) Constellation(constellation *Constellation) *UserInfoBuilder {
 	u.userInfo.Constellation = constellation
 	return u
@@ -462,6 +739,15 @@ func (u *UserInfoBuilder) Constellation(constellation *Constellation) *UserInfoB
 func (u *UserInfoBuilder) DateOfBirth(dateOfBirth time.Time, mode int16) *UserInfoBuilder {
 	u.userInfo.DateOfBirth = dateOfBirth
 	u.userInfo.CalendarMode = mode
+	u.userInfo.LunarDateOfBirth = nil
+	return u
+}
+
+// Set the valid lunar date of birth and its solar date for this builder
+func (u *UserInfoBuilder) LunarDateOfBirth(dateOfBirth lunar.Date) *UserInfoBuilder {
+	u.userInfo.DateOfBirth, _ = dateOfBirth.ToSolar()
+	u.userInfo.CalendarMode = CALENDAR_LUNAR
+	u.userInfo.LunarDateOfBirth = &dateOfBirth
 	return u
 }
 
@@ -470,40 +756,43 @@ func (u *UserInfoBuilder) Builder() *UserInfo {
 	return u.userInfo
 }
 
+// Returns the full years of age now, or -1 if the date of birth is not set.
+func (u UserInfo) Age() int {
+	return AgeAt(u.DateOfBirth, time.Now())
+}
+
+// Returns the AgeBand of the age now, or AGE_Unknown if the date of birth
+// is not set.
+func (u UserInfo) AgeBand() *AgeBand {
+	return AgeBandOf(u.Age())
+}
+
 // Gorp's lack of support for loading relations automatically.
 func (u *UserInfo) PreInsert(_ gorp.SqlExecutor) error {
+	timeNow := time.Now()
+	u.CreatedTime = mysql.NullTime{timeNow, true}
+	u.LastModifiedTime = mysql.NullTime{timeNow, true}
+	u.defaultVisibilities()
+	return u.syncRelationIds()
+}
+
+func (u *UserInfo) PreUpdate(_ gorp.SqlExecutor) error {
+	u.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return u.syncRelationIds()
+}
+
+// Sets the ids and dates of birth of the transient relations before saved,
+// the id of nil relation is zero which means not selected. DateOfBirthStr is
+// always the solar date, and LunarBirthStr is the lunar date if CalendarMode
+// is lunar.
+func (u *UserInfo) syncRelationIds() error {
 	if u.User != nil {
 		u.UserId = u.User.UserId
 		u.UserName = u.User.UserName
 	}
-	if u.Hometown != nil {
-		if u.Hometown.Country != nil {
-			u.HtCountryId = u.Hometown.Country.Id
-		}
-		if u.Hometown.Province != nil {
-			u.HtStateId = u.Hometown.Province.Id
-		}
-		if u.Hometown.City != nil {
-			u.HtCityId = u.Hometown.City.Id
-		}
-		if u.Hometown.District != nil {
-			u.HtDistId = u.Hometown.District.Id
-		}
-	}
-	if u.PlaceOfResidence != nil {
-		if u.PlaceOfResidence.Country != nil {
-			u.PorCountryId = u.PlaceOfResidence.Country.Id
-		}
-		if u.PlaceOfResidence.Province != nil {
-			u.PorStateId = u.PlaceOfResidence.Province.Id
-		}
-		if u.PlaceOfResidence.City != nil {
-			u.PorCityId = u.PlaceOfResidence.City.Id
-		}
-		if u.PlaceOfResidence.District != nil {
-			u.PorDistId = u.PlaceOfResidence.District.Id
-		}
-	}
+	u.HtCountryId, u.HtStateId, u.HtCityId, u.HtDistId = u.Hometown.Ids()
+	u.PorCountryId, u.PorStateId, u.PorCityId, u.PorDistId = u.PlaceOfResidence.Ids()
+	u.EduId, u.FeelingId, u.BloodTypeId, u.ConstellationId = 0, 0, 0, 0
 	if u.Education != nil {
 		u.EduId = u.Education.Id
 	}
@@ -511,11 +800,33 @@ func (u *UserInfo) PreInsert(_ gorp.SqlExecutor) error {
 		u.FeelingId = u.Feeling.Id
 	}
 	if u.BloodType != nil {
-		u.BloodTypeId = u.BloodTypeId
+		u.BloodTypeId = u.BloodType.Id
 	}
-	if u.Constellation != nil {
+	// The constellation is always derived from the solar date of birth.
+	u.Constellation = nil
+	if !u.DateOfBirth.IsZero() {
+		u.Constellation = ConstellationFor(u.DateOfBirth)
 		u.ConstellationId = u.Constellation.Id
 	}
+	u.DateOfBirthStr = sql.NullString{"", false}
+	u.LunarBirthStr = sql.NullString{"", false}
+	if u.DateOfBirth.IsZero() {
+		u.LunarDateOfBirth = nil
+		return nil
+	}
+	u.DateOfBirthStr = sql.NullString{u.DateOfBirth.Format(DATE_OF_BIRTH_LAYOUT), true}
+	if u.CalendarMode != CALENDAR_LUNAR {
+		u.LunarDateOfBirth = nil
+		return nil
+	}
+	if u.LunarDateOfBirth == nil {
+		dateOfBirth, err := lunar.FromSolar(u.DateOfBirth)
+		if err != nil {
+			return err
+		}
+		u.LunarDateOfBirth = &dateOfBirth
+	}
+	u.LunarBirthStr = sql.NullString{u.LunarDateOfBirth.String(), true}
 	return nil
 }
 
@@ -527,6 +838,13 @@ func (u *UserInfo) PostGet(exe gorp.SqlExecutor) error {
 		return fmt.Errorf("Error loading a UserInfo's User(%d) %s", u.UserId, err)
 	}
 	u.User = obj.(*User)
+	if u.Hometown, err = LoadLocation(exe, u.HtCountryId, u.HtStateId, u.HtCityId, u.HtDistId); err != nil {
+		return fmt.Errorf("Error loading a UserInfo's Hometown %s", err)
+	}
+	if u.PlaceOfResidence, err = LoadLocation(exe, u.PorCountryId, u.PorStateId,
+		u.PorCityId, u.PorDistId); err != nil {
+		return fmt.Errorf("Error loading a UserInfo's PlaceOfResidence %s", err)
+	}
 	if u.EduId > 0 {
 		if u.Education = EducationOf(u.EduId, nil); u.Education == EDU_Unknown {
 			return fmt.Errorf("Error EduId => %d", u.EduId)
@@ -548,13 +866,53 @@ func (u *UserInfo) PostGet(exe gorp.SqlExecutor) error {
 		}
 	}
 	if u.DateOfBirthStr.Valid {
-		if u.DateOfBirth, err = time.Parse("2006-01-02", u.DateOfBirthStr.String); err != nil {
+		if u.DateOfBirth, err = time.Parse(DATE_OF_BIRTH_LAYOUT, u.DateOfBirthStr.String); err != nil {
 			return fmt.Errorf("Error parsing date of birth '%v'", u.DateOfBirthStr)
 		}
 	}
+	if u.LunarBirthStr.Valid {
+		dateOfBirth, err := lunar.Parse(u.LunarBirthStr.String)
+		if err != nil {
+			return fmt.Errorf("Error parsing lunar date of birth '%v'", u.LunarBirthStr)
+		}
+		u.LunarDateOfBirth = &dateOfBirth
+	}
 	return nil
 }
 
+// Returns the UserInfos whose birthday is the date t, the solar birthday of
+// February 29 is on February 28 in common years like AgeAt, and the lunar birthdays
+// are resolved by lunar.BirthdaysOn.
+func FindBirthdayUserInfos(exe gorp.SqlExecutor, t time.Time) []*UserInfo {
+	solarArgs := []interface{}{CALENDAR_SOLAR, t.Format("%-01-02")}
+	if t.Month() == time.February && t.Day() == 28 && !isLeapYear(t.Year()) {
+		solarArgs = append(solarArgs, "%-02-29")
+	}
+	births, err := lunar.BirthdaysOn(t)
+	if err != nil {
+		panic(err)
+	}
+	lunarArgs := []interface{}{CALENDAR_LUNAR}
+	for _, birth := range births {
+		// Matches the month and day of Date.String() of any year.
+		lunarArgs = append(lunarArgs, "%"+birth.String()[4:])
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE (%s = ? AND (%s)) OR (%s = ? AND (%s))",
+		UserInfoFields, USER_INFO_TABLE,
+		F_CALENDAR_MODE, likeAny(F_DATE_OF_BIRTH, len(solarArgs)-1),
+		F_CALENDAR_MODE, likeAny(F_LUNAR_BIRTH, len(lunarArgs)-1))
+	return ToUserInfos(exe.Select(UserInfo{}, sql, append(solarArgs, lunarArgs...)...))
+}
+
+// Returns the condition "field LIKE ? OR field LIKE ? ..." of n patterns.
+func likeAny(field string, n int) string {
+	conds := make([]string, n)
+	for i := range conds {
+		conds[i] = field + " LIKE ?"
+	}
+	return strings.Join(conds, " OR ")
+}
+
 func ToUserInfo(i interface{}, err error) *UserInfo {
 	if err != nil {
 		panic(err)
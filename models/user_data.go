@@ -0,0 +1,377 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"smart-kids/archive"
+	"strings"
+	"time"
+)
+
+const (
+	USER_DATA_REQUEST_TABLE = "sk_user_data_request"
+
+	// The author name of the forum threads, posts and comments of the deleted
+	// users, their user ids are 0.
+	DELETED_USER_NAME = "已注销用户"
+)
+
+// UserDataRequest kind constants
+const (
+	DATA_EXPORT   = int16(1) // 导出个人数据
+	DATA_DELETION = int16(2) // 注销账号
+)
+
+// UserDataRequest status constants
+const (
+	DATA_REQUEST_PENDING = int16(1)
+	DATA_REQUEST_DONE    = int16(2)
+	DATA_REQUEST_FAILED  = int16(3)
+	DATA_REQUEST_EXPIRED = int16(4) // the export archive is removed
+)
+
+// sk_user_data_request fields constants
+const (
+	F_REQUEST_KIND   = "request_kind"
+	F_ARCHIVE_KEY    = "archive_key"
+	F_ARCHIVE_URI    = "archive_uri"
+	F_FAILED_CAUSE   = "failed_cause"
+	F_EXPIRES_TIME   = "expires_time"
+	F_COMPLETED_TIME = "completed_time"
+)
+
+var (
+	InnerUserDeletionError = errors.New("UserData.innerUserDeletion")
+
+	UserDataRequestFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_USER_NAME, F_REQUEST_KIND, F_STATUS, F_ARCHIVE_KEY,
+		F_ARCHIVE_URI, F_FAILED_CAUSE, F_EXPIRES_TIME, F_COMPLETED_TIME,
+		F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+	}, ", ")
+
+	userDataRequestsSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? ORDER BY %s DESC LIMIT 20",
+		UserDataRequestFields, USER_DATA_REQUEST_TABLE, F_USER_ID, F_ID)
+	pendingDataRequestSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ? AND %s = ?",
+		UserDataRequestFields, USER_DATA_REQUEST_TABLE, F_USER_ID, F_REQUEST_KIND, F_STATUS)
+	pendingDataRequestsSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ? ORDER BY %s LIMIT ?",
+		UserDataRequestFields, USER_DATA_REQUEST_TABLE, F_REQUEST_KIND, F_STATUS, F_ID)
+	expiredDataExportsSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = ? AND %s <= ?",
+		UserDataRequestFields, USER_DATA_REQUEST_TABLE, F_REQUEST_KIND, F_STATUS, F_EXPIRES_TIME)
+	archiveKeysSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s <> ''",
+		F_ARCHIVE_KEY, USER_DATA_REQUEST_TABLE, F_USER_ID, F_ARCHIVE_KEY)
+
+	userSelectSql = func(fields, table, column string) string {
+		return fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? ORDER BY %s", fields, table, column, F_ID)
+	}
+	userAlbumsSql   = userSelectSql(PhotoAlbumFields, PHOTO_ALBUM_TABLE, F_USER_ID)
+	userPhotosSql   = userSelectSql(PhotoFields, PHOTO_TABLE, F_USER_ID)
+	userCommentsSql = userSelectSql(PhotoCommentFields, PHOTO_COMMENT_TABLE, F_USER_ID)
+	userThreadsSql  = userSelectSql(ThreadFields, FORUM_THREAD_TABLE, F_USER_ID)
+	userPostsSql    = userSelectSql(PostsFields, FORUM_POSTS_TABLE, F_USER_ID)
+	userRepliesSql  = userSelectSql(PostsReplyFields, FORUM_POSTS_REPLY_TABLE, F_USER_ID)
+
+	// The statements of DeleteUserData in order, each has the user id as
+	// the only arg. The forum threads, posts and comments are kept with the
+	// placeholder author, the other records are removed.
+	deleteUserDataSqls = []string{
+		fmt.Sprintf("UPDATE %s SET %s = 0, %s = '' WHERE %s = ?", FORUM_THREAD_TABLE,
+			F_USER_ID, F_CLIENT_IP, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = 0 WHERE %s = ?", FORUM_THREAD_TABLE,
+			F_LAST_POST_USER_ID, F_LAST_POST_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = 0, %s = '%s', %s = NULL, %s = NULL, %s = '' WHERE %s = ?",
+			FORUM_POSTS_TABLE, F_USER_ID, F_USER_NAME, DELETED_USER_NAME, F_USER_EMAIL,
+			F_USER_URL, F_CLIENT_IP, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = 0, %s = '%s', %s = NULL, %s = NULL WHERE %s = ?",
+			FORUM_POSTS_REPLY_TABLE, F_USER_ID, F_USER_NAME, DELETED_USER_NAME, F_USER_EMAIL,
+			F_USER_URL, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s IN (SELECT %s FROM %s WHERE %s = ?)",
+			PHOTO_COMMENT_TABLE, F_PHOTO_ID, F_ID, PHOTO_TABLE, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = 0, %s = '%s', %s = '', %s = '', %s = '' WHERE %s = ?",
+			PHOTO_COMMENT_TABLE, F_USER_ID, F_USER_NAME, DELETED_USER_NAME, F_USER_EMAIL,
+			F_USER_URL, F_CLIENT_IP, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", PHOTO_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", PHOTO_ALBUM_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", FRIENDSHIP_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", FRIENDSHIP_TABLE, F_FRIEND_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", USER_TOKEN_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", USER_SESSION_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", EMAIL_CHANGE_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", APP_GRANT_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", USER_IDENTITY_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", USER_INFO_TABLE, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", USER_DIGITAL_TABLE, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = '%s' WHERE %s = ?", SCORE_LEDGER_TABLE,
+			F_USER_NAME, DELETED_USER_NAME, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = '%s' WHERE %s = ?", WALLET_TXN_TABLE,
+			F_USER_NAME, DELETED_USER_NAME, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = '%s' WHERE %s = ?", BANNED_USER_TABLE,
+			F_USER_NAME, DELETED_USER_NAME, F_USER_ID),
+		fmt.Sprintf("UPDATE %s SET %s = '%s' WHERE %s = ?", USER_DATA_REQUEST_TABLE,
+			F_USER_NAME, DELETED_USER_NAME, F_USER_ID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", USER_TABLE, F_USER_ID),
+	}
+)
+
+// UserDataRequest struct
+// ----------------------------------------------------------------------------
+
+// UserDataRequest is a request of a user to export the personal data or to
+// delete the account, which is processed by the background jobs.
+type UserDataRequest struct {
+	Id               uint64         `db:"id" json:"id"`
+	UserId           uint64         `db:"user_id" json:"-"`
+	UserName         string         `db:"user_name" json:"-"`
+	Kind             int16          `db:"request_kind" json:"kind"`
+	Status           int16          `db:"status" json:"status"`
+	ArchiveKey       string         `db:"archive_key" json:"-"` // the storage key of the export archive
+	ArchiveUri       string         `db:"archive_uri" json:"archiveUri,omitempty"`
+	FailedCause      string         `db:"failed_cause" json:"-"`
+	ExpiresTime      mysql.NullTime `db:"expires_time" json:"expires"` // the archive is removed after it
+	CompletedTime    mysql.NullTime `db:"completed_time" json:"completed"`
+	CreatedTime      mysql.NullTime `db:"created_time" json:"created"`
+	LastModifiedTime mysql.NullTime `db:"last_modified_time" json:"-"`
+}
+
+// Returns a new pending UserDataRequest of the user.
+func NewUserDataRequest(user *User, kind int16) *UserDataRequest {
+	return &UserDataRequest{UserId: user.UserId, UserName: user.UserName, Kind: kind,
+		Status: DATA_REQUEST_PENDING}
+}
+
+func (r UserDataRequest) String() string {
+	return fmt.Sprintf("UserDataRequest{Id=%d,User=(%d, %s),Kind=%d,Status=%d,Archive=%s}",
+		r.Id, r.UserId, r.UserName, r.Kind, r.Status, r.ArchiveKey)
+}
+
+// Marks this request done, the archive of the export is removed after expires.
+func (r *UserDataRequest) Done(archiveKey, archiveUri string, expires time.Duration) *UserDataRequest {
+	timeNow := time.Now()
+	r.Status, r.CompletedTime = DATA_REQUEST_DONE, mysql.NullTime{timeNow, true}
+	if len(archiveKey) > 0 {
+		r.ArchiveKey, r.ArchiveUri = archiveKey, archiveUri
+		r.ExpiresTime = mysql.NullTime{timeNow.Add(expires), true}
+	}
+	return r
+}
+
+// Marks this request failed by the cause, which is cut to 500 characters.
+func (r *UserDataRequest) Fail(cause string) *UserDataRequest {
+	if runes := []rune(cause); len(runes) > 500 {
+		cause = string(runes[:500])
+	}
+	r.Status, r.FailedCause = DATA_REQUEST_FAILED, cause
+	r.CompletedTime = mysql.NullTime{time.Now(), true}
+	return r
+}
+
+// Marks this export expired, the archive must be removed from the storage.
+func (r *UserDataRequest) Expire() *UserDataRequest {
+	r.Status, r.ArchiveUri = DATA_REQUEST_EXPIRED, ""
+	return r
+}
+
+func (r *UserDataRequest) PreInsert(_ gorp.SqlExecutor) error {
+	timeNow := time.Now()
+	r.CreatedTime = mysql.NullTime{timeNow, true}
+	r.LastModifiedTime = mysql.NullTime{timeNow, true}
+	return nil
+}
+
+func (r *UserDataRequest) PreUpdate(_ gorp.SqlExecutor) error {
+	r.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+// Returns the latest data requests of the user, the newest first.
+func FindUserDataRequests(exe gorp.SqlExecutor, userId uint64) []*UserDataRequest {
+	return ToUserDataRequests(exe.Select(UserDataRequest{}, userDataRequestsSql, userId))
+}
+
+// Returns the pending request of the user and the kind, or nil if not exists.
+func FindPendingDataRequest(exe gorp.SqlExecutor, userId uint64, kind int16) *UserDataRequest {
+	requests := ToUserDataRequests(exe.Select(UserDataRequest{}, pendingDataRequestSql,
+		userId, kind, DATA_REQUEST_PENDING))
+	if len(requests) == 0 {
+		return nil
+	}
+	return requests[0]
+}
+
+// Returns at most limit pending requests of the kind, the oldest first.
+func PendingDataRequests(exe gorp.SqlExecutor, kind int16, limit int) []*UserDataRequest {
+	return ToUserDataRequests(exe.Select(UserDataRequest{}, pendingDataRequestsSql,
+		kind, DATA_REQUEST_PENDING, limit))
+}
+
+// Returns the done exports which archive expires at the time t.
+func ExpiredDataExports(exe gorp.SqlExecutor, t time.Time) []*UserDataRequest {
+	return ToUserDataRequests(exe.Select(UserDataRequest{}, expiredDataExportsSql,
+		DATA_EXPORT, DATA_REQUEST_DONE, t))
+}
+
+// UserData struct
+// ----------------------------------------------------------------------------
+
+// Account is the exported User with the emails which are hidden in the User JSON.
+type Account struct {
+	User       *User  `json:"user"`
+	Email      string `json:"email"`
+	SpareEmail string `json:"spareEmail,omitempty"`
+}
+
+// UserData is the personal data of a user. The Identity only has the masked
+// real name and idcard number, as the archive is downloaded by a link.
+type UserData struct {
+	Account  *Account
+	Info     *UserInfo
+	Digital  *UserDigital
+	Identity *UserIdentity
+	Albums   []interface{}
+	Photos   []interface{}
+	Threads  []interface{}
+	Posts    []interface{}
+	Replies  []interface{}
+	Comments []interface{}
+}
+
+// Collects the personal data of the user.
+func CollectUserData(exe gorp.SqlExecutor, user *User) (*UserData, error) {
+	data := &UserData{Account: &Account{User: user, Email: user.Email}}
+	if user.SpareEmail.Valid {
+		data.Account.SpareEmail = user.SpareEmail.String
+	}
+	var err error
+	if data.Info, err = getUserInfo(exe, user.UserId); err != nil {
+		return nil, err
+	}
+	if data.Digital, err = getUserDigital(exe, user.UserId); err != nil {
+		return nil, err
+	}
+	if data.Identity, err = getUserIdentity(exe, user.UserId); err != nil {
+		return nil, err
+	}
+	for _, c := range []struct {
+		dest *[]interface{}
+		i    interface{}
+		sql  string
+	}{
+		{&data.Albums, PhotoAlbum{}, userAlbumsSql},
+		{&data.Photos, Photo{}, userPhotosSql},
+		{&data.Threads, Thread{}, userThreadsSql},
+		{&data.Posts, Posts{}, userPostsSql},
+		{&data.Replies, PostsReply{}, userRepliesSql},
+		{&data.Comments, PhotoComment{}, userCommentsSql},
+	} {
+		if *c.dest, err = exe.Select(c.i, c.sql, user.UserId); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Returns the zip archive of this data with a JSON manifest.
+func (d *UserData) Archive() ([]byte, error) {
+	a := archive.New(d.Account.User.UserName)
+	for _, doc := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"account.json", d.Account}, {"profile.json", d.Info}, {"digital.json", d.Digital},
+		{"identity.json", d.Identity}, {"albums.json", d.Albums}, {"photos.json", d.Photos},
+		{"threads.json", d.Threads}, {"posts.json", d.Posts}, {"replies.json", d.Replies},
+		{"comments.json", d.Comments},
+	} {
+		if err := a.AddJSON(doc.name, doc.v); err != nil {
+			return nil, err
+		}
+	}
+	return a.Bytes()
+}
+
+// Deletes the account and the personal data of the user. The forum threads,
+// posts and comments are kept for the thread structure, but their author is
+// replaced by the placeholder author of the user id 0 and DELETED_USER_NAME.
+// Returns the storage keys of the export archives which must be removed.
+func DeleteUserData(exe gorp.SqlExecutor, user *User) ([]string, error) {
+	if user.IsInnerUser() {
+		return nil, InnerUserDeletionError
+	}
+	var keys []string
+	if _, err := exe.Select(&keys, archiveKeysSql, user.UserId); err != nil {
+		return nil, err
+	}
+	for _, sql := range deleteUserDataSqls {
+		if _, err := exe.Exec(sql, user.UserId); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func getUserInfo(exe gorp.SqlExecutor, userId uint64) (*UserInfo, error) {
+	i, err := exe.Get(UserInfo{}, userId)
+	if i == nil || err != nil {
+		return nil, err
+	}
+	return i.(*UserInfo), nil
+}
+
+func getUserDigital(exe gorp.SqlExecutor, userId uint64) (*UserDigital, error) {
+	i, err := exe.Get(UserDigital{}, userId)
+	if i == nil || err != nil {
+		return nil, err
+	}
+	return i.(*UserDigital), nil
+}
+
+func getUserIdentity(exe gorp.SqlExecutor, userId uint64) (*UserIdentity, error) {
+	i, err := exe.Get(UserIdentity{}, userId)
+	if i == nil || err != nil {
+		return nil, err
+	}
+	return i.(*UserIdentity), nil
+}
+
+func ToUserDataRequest(i interface{}, err error) *UserDataRequest {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*UserDataRequest)
+}
+
+func ToUserDataRequests(results []interface{}, err error) []*UserDataRequest {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	requests := make([]*UserDataRequest, size)
+	if size == 0 {
+		return requests
+	}
+	for i, r := range results {
+		requests[i] = r.(*UserDataRequest)
+	}
+	return requests
+}
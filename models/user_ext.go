@@ -33,31 +33,79 @@ const (
 	F_IS_PERMANENT  = "is_permanent"
 	F_BANNED_TIME   = "banned_time"
 	F_UNBAN_TIME    = "unban_time"
+	F_IS_ACTIVE     = "is_active"
+	F_LIFTED_TIME   = "lifted_time"
+	F_LIFTED_CAUSE  = "lifted_cause"
+
+	F_LAST_MODIFIED_BY_ID   = "last_modified_by_id"
+	F_LAST_MODIFIED_BY_NAME = "last_modified_by_name"
 )
 
 var (
 	BannedUserFields = strings.Join([]string{
 		F_ID, F_USER_ID, F_USER_NAME, F_OPERATOR_ID, F_OPERATOR_NAME,
-		F_CAUSE, F_IS_PERMANENT, F_BANNED_TIME, F_UNBAN_TIME,
-		F_CREATED_TIME, F_LAST_MODIFIED_TIME,
+		F_CAUSE, F_IS_PERMANENT, F_BANNED_TIME, F_UNBAN_TIME, F_IS_ACTIVE,
+		F_LIFTED_TIME, F_LIFTED_CAUSE, F_LAST_MODIFIED_BY_ID,
+		F_LAST_MODIFIED_BY_NAME, F_CREATED_TIME, F_LAST_MODIFIED_TIME,
 	}, ", ")
+
+	activeBannedUsersSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? AND %s = 1 "+
+		"AND (%s = 1 OR %s > ?) ORDER BY %s DESC", BannedUserFields, BANNED_USER_TABLE,
+		F_USER_NAME, F_IS_ACTIVE, F_IS_PERMANENT, F_UNBAN_TIME, F_BANNED_TIME)
+	bannedHistorySql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ? ORDER BY %s DESC",
+		BannedUserFields, BANNED_USER_TABLE, F_USER_ID, F_BANNED_TIME)
+	liftBannedUsersSql = fmt.Sprintf("UPDATE %s SET %s = 0, %s = ?, %s = ?, %s = ?, %s = ?, "+
+		"%s = ? WHERE %s = ? AND %s = 1", BANNED_USER_TABLE, F_IS_ACTIVE, F_LIFTED_TIME,
+		F_LIFTED_CAUSE, F_LAST_MODIFIED_BY_ID, F_LAST_MODIFIED_BY_NAME, F_LAST_MODIFIED_TIME,
+		F_USER_ID, F_IS_ACTIVE)
+	expireBannedUsersSql = fmt.Sprintf("UPDATE %s SET %s = 0, %s = %s, %s = ? "+
+		"WHERE %s = 1 AND %s = 0 AND %s <= ?", BANNED_USER_TABLE, F_IS_ACTIVE,
+		F_LIFTED_TIME, F_UNBAN_TIME, F_LAST_MODIFIED_TIME, F_IS_ACTIVE, F_IS_PERMANENT,
+		F_UNBAN_TIME)
 )
 
 // BannedUser struct
 // ----------------------------------------------------------------------------
 
 type BannedUser struct {
-	Id               uint           `db:"id"`
-	UserId           uint64         `db:"user_id"`
-	UserName         string         `db:"user_name"`
-	OperatorId       int            `db:"operator_id"`
-	OperatorName     string         `db:"operator_name"`
-	Cause            string         `db:"banned_cause"`
-	IsPermanent      bool           `db:"is_permanent"`
-	BannedTime       mysql.NullTime `db:"banned_time"`
-	UnbanTime        mysql.NullTime `db:"unban_time"`
-	CreatedTime      mysql.NullTime `db:"created_time"`
-	LastModifiedTime mysql.NullTime `db:"last_modified_time"`
+	Id                 uint           `db:"id"`
+	UserId             uint64         `db:"user_id"`
+	UserName           string         `db:"user_name"`
+	OperatorId         int            `db:"operator_id"`
+	OperatorName       string         `db:"operator_name"`
+	Cause              string         `db:"banned_cause"`
+	IsPermanent        bool           `db:"is_permanent"`
+	BannedTime         mysql.NullTime `db:"banned_time"`
+	UnbanTime          mysql.NullTime `db:"unban_time"`   // null if permanent
+	IsActive           bool           `db:"is_active"`    // false once unbanned or expired
+	LiftedTime         mysql.NullTime `db:"lifted_time"`  // the time this ban ended
+	LiftedCause        string         `db:"lifted_cause"` // the cause of manual unban
+	LastModifiedById   int            `db:"last_modified_by_id"`
+	LastModifiedByName string         `db:"last_modified_by_name"`
+	CreatedTime        mysql.NullTime `db:"created_time"`
+	LastModifiedTime   mysql.NullTime `db:"last_modified_time"`
+}
+
+// Returns a new active BannedUser of the specified user and operator, the
+// ban is permanent if duration is not greater than zero.
+func NewBannedUser(user *User, operatorId int, operatorName, cause string,
+	duration time.Duration) *BannedUser {
+	timeNow := time.Now()
+	b := &BannedUser{
+		UserId: user.UserId, UserName: user.UserName,
+		OperatorId: operatorId, OperatorName: operatorName, Cause: cause,
+		IsPermanent: duration <= 0, IsActive: true,
+		BannedTime: mysql.NullTime{timeNow, true},
+	}
+	if !b.IsPermanent {
+		b.UnbanTime = mysql.NullTime{timeNow.Add(duration), true}
+	}
+	return b
+}
+
+// Returns true if this ban is in effect at the specified time.
+func (b BannedUser) IsEffective(t time.Time) bool {
+	return b.IsActive && (b.IsPermanent || (b.UnbanTime.Valid && b.UnbanTime.Time.After(t)))
 }
 
 // BannedUser instance default string
@@ -77,6 +125,58 @@ func (b *BannedUser) PreInsert(_ gorp.SqlExecutor) error {
 	return nil
 }
 
+func (b *BannedUser) PreUpdate(_ gorp.SqlExecutor) error {
+	b.LastModifiedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+// Bans the user by the operator, the login sessions of the user are removed.
+// The ban is permanent if duration is not greater than zero.
+func BanUser(exe gorp.SqlExecutor, user *User, operatorId int, operatorName, cause string,
+	duration time.Duration) (*BannedUser, error) {
+	b := NewBannedUser(user, operatorId, operatorName, cause, duration)
+	if err := exe.Insert(b); err != nil {
+		return nil, err
+	}
+	if _, err := DeleteUserSessions(exe, user.UserId); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Lifts all active bans of the user by the operator, returns the count of
+// lifted bans.
+func UnbanUser(exe gorp.SqlExecutor, userId uint64, operatorId int, operatorName,
+	cause string) (int64, error) {
+	timeNow := time.Now()
+	res, err := exe.Exec(liftBannedUsersSql, timeNow, cause, operatorId, operatorName,
+		timeNow, userId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Ends the active temporary bans which unban time is not after t, returns
+// the count of ended bans.
+func ExpireBannedUsers(exe gorp.SqlExecutor, t time.Time) (int64, error) {
+	res, err := exe.Exec(expireBannedUsersSql, time.Now(), t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Returns the bans in effect of the specified user name, the newest first.
+func ActiveBannedUsers(exe gorp.SqlExecutor, userName string) []*BannedUser {
+	return ToBannedUsers(exe.Select(BannedUser{}, activeBannedUsersSql, userName, time.Now()))
+}
+
+// Returns all bans of the specified user, the newest first.
+func BannedHistory(exe gorp.SqlExecutor, userId uint64) []*BannedUser {
+	return ToBannedUsers(exe.Select(BannedUser{}, bannedHistorySql, userId))
+}
+
 func ToBannedUser(i interface{}, err error) *BannedUser {
 	if err != nil {
 		panic(err)
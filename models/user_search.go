@@ -0,0 +1,219 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"smart-kids/query"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+// The tri-state values of the boolean search filters.
+const (
+	FILTER_ANY = 0
+	FILTER_YES = 1
+	FILTER_NO  = 2
+)
+
+var (
+	InvalidSortPropertyError = errors.New("UserSearch.invalidSortProperty")
+
+	// The sortable properties of the user search and their columns.
+	UserSortColumns = map[string]string{
+		"uid":     "x." + F_USER_ID,
+		"name":    "x." + F_USER_NAME,
+		"created": "x." + F_CREATED_TIME,
+		"grade":   "d." + F_GRADE,
+		"score":   "d." + F_TOTAL_SCORE,
+	}
+
+	userSearchFields = "x." + strings.Replace(UserFields, ", ", ", x.", -1)
+	userSearchFrom   = fmt.Sprintf(" FROM %s x LEFT JOIN %s d ON d.%s = x.%s "+
+		"LEFT JOIN %s i ON i.%s = x.%s", USER_TABLE, USER_DIGITAL_TABLE, F_USER_ID, F_USER_ID,
+		USER_INFO_TABLE, F_USER_ID, F_USER_ID)
+	userSearchBannedSql = fmt.Sprintf("EXISTS (SELECT 1 FROM %s b WHERE b.%s = x.%s "+
+		"AND b.%s = 1 AND (b.%s = 1 OR b.%s > ?))", BANNED_USER_TABLE, F_USER_ID, F_USER_ID,
+		F_IS_ACTIVE, F_IS_PERMANENT, F_UNBAN_TIME)
+	likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+)
+
+// RegionFilter matches the users in the region, zero id means any.
+type RegionFilter struct {
+	CountryId  uint
+	ProvinceId uint
+	CityId     uint
+	DistrictId uint
+}
+
+// Returns true if no region id is set.
+func (r RegionFilter) IsEmpty() bool {
+	return r.CountryId == 0 && r.ProvinceId == 0 && r.CityId == 0 && r.DistrictId == 0
+}
+
+// Appends the conditions of the set region ids on the columns of the prefix.
+func (r RegionFilter) appendTo(conditions []string, args []interface{}, prefix string) ([]string, []interface{}) {
+	for _, c := range []struct {
+		column string
+		id     uint
+	}{{"country_id", r.CountryId}, {"province_id", r.ProvinceId}, {"city_id", r.CityId},
+		{"dist_id", r.DistrictId}} {
+		if c.id > 0 {
+			conditions = append(conditions, fmt.Sprintf("i.%s_%s = ?", prefix, c.column))
+			args = append(args, c.id)
+		}
+	}
+	return conditions, args
+}
+
+// UserSearch is the filters of the user directory, the zero value of each
+// filter means any.
+type UserSearch struct {
+	NamePrefix     string
+	Email          string
+	GenderCode     uint16
+	MinGrade       uint
+	MaxGrade       uint
+	Hometown       RegionFilter
+	Residence      RegionFilter
+	Activated      int       // FILTER_ANY, FILTER_YES or FILTER_NO
+	Banned         int       // FILTER_ANY, FILTER_YES or FILTER_NO
+	RegisteredFrom time.Time // the first date, inclusive
+	RegisteredTo   time.Time // the last date, inclusive
+
+	// Only the activated and not banned users are found, and the region
+	// filters only match the sections visible to all.
+	public bool
+}
+
+// Returns the search of the public directory, which can not filter the
+// email, the activation and the ban status.
+func (s UserSearch) Public() *UserSearch {
+	s.Email, s.Activated, s.Banned, s.public = "", FILTER_YES, FILTER_NO, true
+	return &s
+}
+
+// Returns the WHERE clause and its args of this search at time t.
+func (s UserSearch) where(t time.Time) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if name := strings.TrimSpace(s.NamePrefix); len(name) > 0 {
+		conditions = append(conditions, fmt.Sprintf("x.%s LIKE ?", F_USER_NAME))
+		args = append(args, likeEscaper.Replace(name)+"%")
+	}
+	if email := strings.TrimSpace(s.Email); len(email) > 0 {
+		conditions = append(conditions, fmt.Sprintf("x.%s = ?", F_EMAIL))
+		args = append(args, strings.ToLower(email))
+	}
+	if s.GenderCode > 0 {
+		conditions = append(conditions, fmt.Sprintf("x.%s = ?", F_GENDER_CODE))
+		args = append(args, s.GenderCode)
+	}
+	if s.MinGrade > 0 {
+		conditions = append(conditions, fmt.Sprintf("d.%s >= ?", F_GRADE))
+		args = append(args, s.MinGrade)
+	}
+	if s.MaxGrade > 0 {
+		conditions = append(conditions, fmt.Sprintf("d.%s <= ?", F_GRADE))
+		args = append(args, s.MaxGrade)
+	}
+	if !s.Hometown.IsEmpty() {
+		conditions, args = s.Hometown.appendTo(conditions, args, "ht")
+		if s.public {
+			conditions = append(conditions, fmt.Sprintf("i.%s IN (0, %d)", F_HOMETOWN_V_CODE, V_ALL.Code))
+		}
+	}
+	if !s.Residence.IsEmpty() {
+		conditions, args = s.Residence.appendTo(conditions, args, "por")
+		if s.public {
+			conditions = append(conditions, fmt.Sprintf("i.%s IN (0, %d)", F_RESIDENCE_V_CODE, V_ALL.Code))
+		}
+	}
+	switch s.Activated {
+	case FILTER_YES:
+		conditions = append(conditions, fmt.Sprintf("x.%s = 1", F_IS_ACTIVATED))
+	case FILTER_NO:
+		conditions = append(conditions, fmt.Sprintf("x.%s = 0", F_IS_ACTIVATED))
+	}
+	switch s.Banned {
+	case FILTER_YES:
+		conditions = append(conditions, userSearchBannedSql)
+		args = append(args, t)
+	case FILTER_NO:
+		conditions = append(conditions, "NOT "+userSearchBannedSql)
+		args = append(args, t)
+	}
+	if !s.RegisteredFrom.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("x.%s >= ?", F_CREATED_TIME))
+		args = append(args, s.RegisteredFrom)
+	}
+	if !s.RegisteredTo.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("x.%s < ?", F_CREATED_TIME))
+		args = append(args, s.RegisteredTo.AddDate(0, 0, 1))
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Returns the Sort of the sortable properties in UserSortColumns, or
+// InvalidSortPropertyError if any property or the direction is not allowed.
+func NewUserSort(direction string, properties []string) (*util.Sort, error) {
+	direction = strings.ToUpper(direction)
+	if direction != util.ASC && direction != util.DESC {
+		return nil, InvalidSortPropertyError
+	}
+	if len(properties) == 0 {
+		return nil, InvalidSortPropertyError
+	}
+	columns := make([]string, len(properties))
+	for i, property := range properties {
+		column, exists := UserSortColumns[property]
+		if !exists {
+			return nil, InvalidSortPropertyError
+		}
+		columns[i] = column
+	}
+	return util.NewSort(direction, columns), nil
+}
+
+// Returns page users of the search, the pageable sort must be created by
+// NewUserSort, and the newest registered users are the first by default.
+func SearchUsers(exe gorp.SqlExecutor, search *UserSearch, pageable *util.Pageable) *util.Page {
+	where, args := search.where(time.Now())
+	total, err := exe.SelectInt(fmt.Sprintf("SELECT count(x.%s)", F_USER_ID)+userSearchFrom+where, args...)
+	if err != nil {
+		panic(err)
+	}
+	if total == 0 {
+		return util.NewPage(nil, pageable, total)
+	}
+	sql := query.NewSqlBuilder("SELECT "+userSearchFields+userSearchFrom+where).
+		PageOrderBy(pageable, util.DescendingSort([]string{UserSortColumns["created"]})).
+		ToSqlString()
+	content, err := exe.Select(User{}, sql, args...)
+	if err != nil {
+		panic(err)
+	}
+	return util.NewPage(content, pageable, total)
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"smart-kids/tokens"
+	"strings"
+	"time"
+)
+
+const (
+	USER_SESSION_TABLE = "sk_user_session"
+)
+
+// sk_user_session fields constants
+const (
+	F_CLIENT_CODE = "client_code"
+	F_CLIENT_IP   = "client_ip"
+	F_USER_AGENT  = "user_agent"
+)
+
+var (
+	UserSessionFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_USER_NAME, F_HASH_TOKEN, F_CLIENT_CODE, F_CLIENT_IP,
+		F_USER_AGENT, F_EXPIRED_TIME, F_LAST_ACCESS_TIME, F_CREATED_TIME,
+	}, ", ")
+
+	deleteUserSessionsSql = fmt.Sprintf("DELETE FROM %s WHERE %s = ?",
+		USER_SESSION_TABLE, F_USER_ID)
+)
+
+// Server side login session of a user, the client holds the raw session
+// token and only the hash of the token is stored.
+type UserSession struct {
+	Id             uint64         `db:"id" json:"-"`
+	UserId         uint64         `db:"user_id" json:"uid"`
+	UserName       string         `db:"user_name" json:"name"`
+	HashToken      string         `db:"hash_token" json:"-"` // Unique Index
+	ClientCode     uint16         `db:"client_code" json:"-"`
+	ClientIp       string         `db:"client_ip" json:"clientIp"`
+	UserAgent      string         `db:"user_agent" json:"-"`
+	ExpiredTime    mysql.NullTime `db:"expired_time" json:"-"`
+	LastAccessTime mysql.NullTime `db:"last_access_time" json:"-"`
+	CreatedTime    mysql.NullTime `db:"created_time" json:"-"`
+
+	// Transient property
+	Client *Client `db:"-" json:"client"`
+}
+
+// Returns a new UserSession of the specified user and the raw session token.
+func NewUserSession(user *User, client *Client, clientIp, userAgent string,
+	expires time.Duration) (*UserSession, string) {
+	token, hash := tokens.NewToken()
+	timeNow := time.Now()
+	session := &UserSession{
+		UserId: user.UserId, UserName: user.UserName, HashToken: hash,
+		ClientCode: client.Code, ClientIp: clientIp, UserAgent: userAgent,
+		ExpiredTime:    mysql.NullTime{timeNow.Add(expires), true},
+		LastAccessTime: mysql.NullTime{timeNow, true},
+		Client:         client,
+	}
+	return session, token
+}
+
+// Returns true if this session is expired.
+func (u UserSession) IsExpired() bool {
+	return !u.ExpiredTime.Valid || u.ExpiredTime.Time.Before(time.Now())
+}
+
+// Updates the last access time of this session.
+func (u *UserSession) Touch() *UserSession {
+	u.LastAccessTime = mysql.NullTime{time.Now(), true}
+	return u
+}
+
+func (u UserSession) String() string {
+	return fmt.Sprintf("UserSession{Id=%d, User=(%d, %s), ClientCode=%d, ClientIp=%s, "+
+		"ExpiredTime=%v}", u.Id, u.UserId, u.UserName, u.ClientCode, u.ClientIp,
+		u.ExpiredTime.Time)
+}
+
+// Removes all login sessions of the specified user, returns the count of
+// removed sessions.
+func DeleteUserSessions(exe gorp.SqlExecutor, userId uint64) (int64, error) {
+	res, err := exe.Exec(deleteUserSessionsSql, userId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (u *UserSession) PreInsert(_ gorp.SqlExecutor) error {
+	u.CreatedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+func (u *UserSession) PostGet(_ gorp.SqlExecutor) error {
+	u.Client = ClientOf(u.ClientCode, UNKNOWN_CLIENT)
+	return nil
+}
+
+func ToUserSession(i interface{}, err error) *UserSession {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*UserSession)
+}
+
+func ToUserSessions(results []interface{}, err error) []*UserSession {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	userSessions := make([]*UserSession, size)
+	if size == 0 {
+		return userSessions
+	}
+	for i, r := range results {
+		userSessions[i] = r.(*UserSession)
+	}
+	return userSessions
+}
@@ -18,9 +18,21 @@ package models
 
 import (
 	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"smart-kids/archive"
+	"smart-kids/crypt"
+	"smart-kids/lunar"
+	"smart-kids/tokens"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGender(t *testing.T) {
@@ -47,3 +59,849 @@ func TestHashPassword(t *testing.T) {
 	fmt.Printf("SourcePwd: %s, Salt: %s, HashPwd: %x\n", "admin", "admin", sha1Hash.Sum(nil))
 	fmt.Printf("HashPwd(16): %s\n", hex.EncodeToString(sha1Hash.Sum(nil)))
 }
+
+func TestBannedUserIsEffective(t *testing.T) {
+	user := &User{UserId: 10001, UserName: "testuser"}
+	timeNow := time.Now()
+	permanent := NewBannedUser(user, 1, "admin", "spam", 0)
+	if !permanent.IsPermanent || !permanent.IsEffective(timeNow.Add(24*time.Hour)) {
+		t.Error("Permanent ban must be effective, actual: ", permanent)
+	}
+	temporary := NewBannedUser(user, 1, "admin", "spam", time.Hour)
+	if temporary.IsPermanent || !temporary.IsEffective(timeNow) {
+		t.Error("Temporary ban must be effective before unban time, actual: ", temporary)
+	}
+	if temporary.IsEffective(timeNow.Add(2 * time.Hour)) {
+		t.Error("Temporary ban must not be effective after unban time, actual: ", temporary)
+	}
+	temporary.IsActive = false
+	if temporary.IsEffective(timeNow) {
+		t.Error("Lifted ban must not be effective, actual: ", temporary)
+	}
+}
+
+func TestBannedHistory(t *testing.T) {
+	if bannedHistorySql != "SELECT "+BannedUserFields+" FROM sk_banned_user WHERE user_id = ? ORDER BY banned_time DESC" {
+		t.Errorf("The history should be all bans of the user, the newest first, actual: %s", bannedHistorySql)
+	}
+	banned := func(id int64, active bool) []driver.Value {
+		timeNow := time.Now()
+		return []driver.Value{id, int64(10001), []byte("testuser"), int64(1), []byte("admin"),
+			[]byte("spam"), false, timeNow, timeNow.Add(time.Hour), active, nil, []byte(""),
+			int64(0), []byte(""), timeNow, timeNow}
+	}
+	var userIds []driver.Value
+	sql.Register("stub-banned-history", &stubDriver{handle: func(query string, args []driver.Value) (*stubRows, error) {
+		userIds = append(userIds, args...)
+		return &stubRows{strings.Split(BannedUserFields, ", "), [][]driver.Value{banned(2, true), banned(1, false)}}, nil
+	}})
+	db, err := sql.Open("stub-banned-history", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	history := BannedHistory(dbm, 10001)
+	if len(userIds) != 1 || userIds[0] != int64(10001) {
+		t.Errorf("The history should be queried by the user id, actual: %v", userIds)
+	}
+	if len(history) != 2 || history[0].Id != 2 || !history[0].IsActive || history[1].IsActive {
+		t.Errorf("The lifted bans should be kept in the history, actual: %v", history)
+	}
+}
+
+func TestScoreReasonOf(t *testing.T) {
+	if SCORE_THREAD != ScoreReasonOf(3, UNKNOWN_SCORE_REASON) {
+		t.Error("ScoreReasonOf get reason ptr is error, actual: ", ScoreReasonOf(3, nil))
+	}
+	if UNKNOWN_SCORE_REASON != ScoreReasonOf(100, UNKNOWN_SCORE_REASON) {
+		t.Error("ScoreReasonOf must return the default reason of unknown code.")
+	}
+	entry := NewScoreLedger(10001, "testuser", -5, SCORE_EXCHANGE, "order-1").OperatedBy(1, "admin")
+	if entry.ReasonCode != SCORE_EXCHANGE.Code || entry.OperatorName != "admin" {
+		t.Error("NewScoreLedger is error, actual: ", entry)
+	}
+}
+
+func TestWalletTxnEntries(t *testing.T) {
+	user := &User{UserId: 10001, UserName: "testuser"}
+	for _, txnType := range []uint16{WALLET_RECHARGE, WALLET_SPEND, WALLET_REFUND, WALLET_ADJUSTMENT} {
+		txn := NewWalletTxn(user, txnType, "key", 100, "", "")
+		entries := txn.Entries()
+		if len(entries) != 2 || entries[0].Amount+entries[1].Amount != 0 {
+			t.Error("The wallet entries must sum to zero, actual: ", entries)
+		}
+		if entries[0].Account != UserAccount(user.UserId) {
+			t.Error("The first entry must be the user account, actual: ", entries[0].Account)
+		}
+		if (entries[0].Amount > 0) != txn.IsCredit() {
+			t.Error("The user entry amount is error, actual: ", entries[0].Amount)
+		}
+	}
+}
+
+func TestApplyWalletTxnReplay(t *testing.T) {
+	user := &User{UserId: 10001, UserName: "testuser"}
+	stored := NewWalletTxn(user, WALLET_RECHARGE, "key", 100, "", "")
+	stored.Id, stored.Balance = 1, 100
+	var insertErr error
+	d := &stubDriver{handle: func(query string, args []driver.Value) (*stubRows, error) {
+		switch {
+		case query == lockWalletTxnByKeySql: // committed by the concurrent call
+			return &stubRows{strings.Split(WalletTxnFields, ", "), [][]driver.Value{{
+				int64(stored.Id), []byte(stored.IdempotencyKey), int64(stored.TxnType),
+				int64(stored.UserId), []byte(stored.UserName), int64(stored.Amount),
+				int64(stored.Balance), []byte(""), []byte(""), int64(0), []byte(""), time.Now()}}}, nil
+		case strings.HasPrefix(query, "insert into `"+WALLET_TXN_TABLE+"`"):
+			return nil, insertErr
+		}
+		return nil, nil
+	}}
+	sql.Register("stub-apply-wallet-txn", d)
+	db, err := sql.Open("stub-apply-wallet-txn", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	dbm.AddTableWithName(WalletTxn{}, WALLET_TXN_TABLE).SetKeys(true, "Id")
+
+	insertErr = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'key'"}
+	applied, replayed, err := ApplyWalletTxn(dbm, NewWalletTxn(user, WALLET_RECHARGE, "key", 100, "", ""))
+	if err != nil || !replayed || applied.Id != stored.Id || applied.Balance != stored.Balance {
+		t.Errorf("The duplicate key should replay the stored one, actual: %v %v %v", applied, replayed, err)
+	}
+	if len(d.execsOf(addBalanceSql)) != 0 {
+		t.Errorf("The balance must not be changed by the replay")
+	}
+	applied, replayed, err = ApplyWalletTxn(dbm, NewWalletTxn(user, WALLET_SPEND, "key", 100, "", ""))
+	if err != IdempotencyConflictError || applied != nil || replayed {
+		t.Errorf("The different content of the same key is a conflict, actual: %v %v %v", applied, replayed, err)
+	}
+	insertErr = &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}
+	if _, _, err = ApplyWalletTxn(dbm, NewWalletTxn(user, WALLET_RECHARGE, "key", 100, "", "")); err != insertErr {
+		t.Errorf("The other errors should be returned, actual: %v", err)
+	}
+}
+
+func TestGradeRules(t *testing.T) {
+	rules := GradeRules{
+		&GradeRule{Grade: 1, Name: "G1", MinTotalScore: 0},
+		&GradeRule{Grade: 3, Name: "G3", MinTotalScore: 500},
+		&GradeRule{Grade: 2, Name: "G2", MinTotalScore: 100},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Error("The grade rules must be valid, actual: ", err)
+	}
+	if grade := rules.GradeFor(99); grade != 1 {
+		t.Error("GradeFor(99) must be 1, actual: ", grade)
+	}
+	if grade := rules.GradeFor(800); grade != 3 {
+		t.Error("GradeFor(800) must jump to 3, actual: ", grade)
+	}
+	rules = append(rules, &GradeRule{Grade: 4, Name: "G4", MinTotalScore: 300})
+	if err := rules.Validate(); err != GradeRuleOrderError {
+		t.Error("The higher grade with lower score must be invalid.")
+	}
+}
+
+func TestEvaluateGrade(t *testing.T) {
+	d := &stubDriver{}
+	sql.Register("stub-evaluate-grade", d)
+	db, err := sql.Open("stub-evaluate-grade", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	defer SetGradeRules(CurrentGradeRules())
+	SetGradeRules(GradeRules{&GradeRule{Grade: 1, MinTotalScore: 0}, &GradeRule{Grade: 2, MinTotalScore: 100}})
+	notified := make([]GradeChange, 0)
+	OnGradeChanged(func(change GradeChange) {
+		notified = append(notified, change)
+	})
+
+	digital := &UserDigital{UserId: 10001, UserName: "testkid", Grade: 1, TotalScore: 150}
+	change, err := EvaluateGrade(dbm, digital)
+	if err != nil || change == nil || change.OldGrade != 1 || change.Grade != 2 || digital.Grade != 2 ||
+		len(d.execsOf(updateGradeSql)) != 1 {
+		t.Fatalf("The grade should be raised to 2, actual: %v %v", change, err)
+	}
+	if len(notified) != 0 {
+		t.Errorf("The hooks must not be called before the commit, actual: %v", notified)
+	}
+	NotifyGradeChanged(change)
+	NotifyGradeChanged(nil)
+	if len(notified) != 1 || notified[0].Grade != 2 {
+		t.Errorf("The hooks should be called with the committed change, actual: %v", notified)
+	}
+	if change, err = EvaluateGrade(dbm, digital); change != nil || err != nil {
+		t.Errorf("The same grade is not a change, actual: %v %v", change, err)
+	}
+}
+
+func TestUserIdentityReview(t *testing.T) {
+	identity := (&UserIdentity{UserId: 10001, RealName: "张三"}).Submit()
+	if !identity.IsPending() || identity.IsVarified || identity.VarifiedDate.Valid {
+		t.Error("The submitted identity must be pending, actual: ", identity)
+	}
+	identity.Reject(1, "admin", "照片不清晰")
+	if identity.Status != IDENTITY_REJECTED || identity.IsVarified || identity.RejectCause == "" {
+		t.Error("The rejected identity is error, actual: ", identity)
+	}
+	identity.Submit().Approve(1, "admin")
+	if identity.Status != IDENTITY_APPROVED || !identity.IsVarified ||
+		!identity.VarifiedDate.Valid || identity.RejectCause != "" {
+		t.Error("The approved identity is error, actual: ", identity)
+	}
+}
+
+func TestUserIdentityEncrypt(t *testing.T) {
+	keyring, err := crypt.NewKeyring(1, map[uint32][]byte{1: make([]byte, crypt.KEY_SIZE)}, []byte("index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crypt.SetDefault(keyring)
+	identity := &UserIdentity{UserId: 10001, RealName: "张三", Idcard: "11010519491231002X"}
+	identity.PreInsert(nil)
+	if !crypt.IsEnvelope(identity.RealName) || !crypt.IsEnvelope(identity.Idcard) ||
+		identity.IdcardHash != IdcardHashOf("11010519491231002X") {
+		t.Error("The saved identity must be encrypted, actual: ", identity)
+	}
+	identity.PostGet(nil)
+	if identity.RealName != "张三" || identity.Idcard != "11010519491231002X" {
+		t.Error("The loaded identity must be decrypted, actual: ", identity)
+	}
+	data, _ := json.Marshal(identity)
+	if strings.Contains(string(data), "11010519491231002X") || !strings.Contains(string(data), "1101**********002X") {
+		t.Error("The JSON output must be masked, actual: ", string(data))
+	}
+}
+
+func TestUserInfoSyncRelationIds(t *testing.T) {
+	dateOfBirth := time.Date(2008, time.June, 1, 0, 0, 0, 0, time.UTC)
+	userInfo := NewUserInfoBuilder(nil).User(&User{UserId: 10001, UserName: "testuser"}).
+		BloodType(BloodTypeOf(3, nil)).Education(EducationOf(9, nil)).
+		Hometown(&Location{Country: &Country{Id: 1}, Province: &Province{Id: 11}}).
+		DateOfBirth(dateOfBirth, CALENDAR_SOLAR).Builder()
+	userInfo.PreInsert(nil)
+	if userInfo.BloodTypeId != 3 || userInfo.EduId != 9 || userInfo.UserId != 10001 {
+		t.Error("The relation ids are error, actual: ", userInfo)
+	}
+	if userInfo.HtCountryId != 1 || userInfo.HtStateId != 11 || userInfo.HtCityId != 0 || userInfo.PorCountryId != 0 {
+		t.Error("The location ids are error, actual: ", userInfo)
+	}
+	if userInfo.DateOfBirthStr.String != "2008-06-01" {
+		t.Error("The DateOfBirthStr is error, actual: ", userInfo.DateOfBirthStr)
+	}
+}
+
+func TestUserInfoLunarDateOfBirth(t *testing.T) {
+	userInfo := NewUserInfoBuilder(nil).LunarDateOfBirth(lunar.Date{2020, 4, 1, true}).Builder()
+	if err := userInfo.PreInsert(nil); err != nil {
+		t.Fatal(err)
+	}
+	if userInfo.DateOfBirthStr.String != "2020-05-23" || userInfo.LunarBirthStr.String != "2020-L04-01" {
+		t.Error("The dates of birth are error, actual: ", userInfo.DateOfBirthStr, userInfo.LunarBirthStr)
+	}
+	userInfo.DateOfBirth = time.Date(2000, time.February, 5, 0, 0, 0, 0, time.UTC)
+	userInfo.LunarDateOfBirth = nil
+	userInfo.PreUpdate(nil)
+	if userInfo.LunarBirthStr.String != "2000-01-01" {
+		t.Error("The lunar date of birth must be converted, actual: ", userInfo.LunarBirthStr)
+	}
+}
+
+func TestConstellationAndAge(t *testing.T) {
+	cases := map[time.Time]uint16{
+		time.Date(2000, time.December, 22, 0, 0, 0, 0, time.UTC): 10,
+		time.Date(2001, time.January, 19, 0, 0, 0, 0, time.UTC):  10,
+		time.Date(2001, time.January, 20, 0, 0, 0, 0, time.UTC):  11,
+		time.Date(2001, time.March, 21, 0, 0, 0, 0, time.UTC):    1,
+	}
+	for date, id := range cases {
+		if c := ConstellationFor(date); c.Id != id {
+			t.Errorf("ConstellationFor(%v) should be %d, actual: %v", date, id, c)
+		}
+	}
+	leapBirth := time.Date(2008, time.February, 29, 0, 0, 0, 0, time.UTC)
+	// the birthday of February 29 is on February 28 in common years, the
+	// same day as the birthday greetings
+	for _, c := range []struct {
+		t   time.Time
+		age int
+	}{
+		{time.Date(2017, time.February, 27, 0, 0, 0, 0, time.UTC), 8},
+		{time.Date(2017, time.February, 28, 0, 0, 0, 0, time.UTC), 9},
+		{time.Date(2017, time.March, 1, 0, 0, 0, 0, time.UTC), 9},
+		{time.Date(2016, time.February, 28, 0, 0, 0, 0, time.UTC), 7},
+		{time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC), 8},
+		{time.Date(2100, time.February, 28, 0, 0, 0, 0, time.UTC), 92},
+	} {
+		if age := AgeAt(leapBirth, c.t); age != c.age {
+			t.Errorf("The age at %v should be %d, actual: %d", c.t, c.age, age)
+		}
+	}
+	if band := AgeBandOf(9); band.EName != "Primary" || AgeBandOf(-1) != AGE_Unknown {
+		t.Errorf("AgeBandOf is error, actual: %v", band)
+	}
+	userInfo := NewUserInfoBuilder(nil).DateOfBirth(leapBirth, CALENDAR_SOLAR).
+		Constellation(ConstellationOf(1, nil)).Builder()
+	userInfo.PreInsert(nil)
+	if userInfo.ConstellationId != 12 {
+		t.Errorf("The constellation must be derived from the date of birth, actual: %d", userInfo.ConstellationId)
+	}
+}
+
+func TestProfileView(t *testing.T) {
+	userInfo := NewUserInfoBuilder(nil).User(&User{UserId: 10001, UserName: "testkid"}).
+		DateOfBirth(time.Date(2010, time.May, 1, 0, 0, 0, 0, time.UTC), CALENDAR_SOLAR).
+		Hometown(&Location{Country: &Country{Id: 1}}).BloodType(BloodTypeOf(1, nil)).Builder()
+	userInfo.PreInsert(nil)
+	userInfo.BirthdayVCode, userInfo.HometownVCode = V_SELF.Code, V_FRIENDS.Code
+	stranger := NewProfileView(userInfo, 0, false)
+	if stranger.DateOfBirth != "" || stranger.Age != nil || stranger.Hometown != nil || stranger.BloodType == nil {
+		t.Errorf("The stranger can only view the public sections, actual: %+v", stranger)
+	}
+	if friend := NewProfileView(userInfo, 10002, true); friend.Hometown == nil || friend.DateOfBirth != "" {
+		t.Errorf("The friend can view the friends sections, actual: %+v", friend)
+	}
+	if owner := NewProfileView(userInfo, 10001, false); owner.DateOfBirth != "2010-05-01" || owner.Hometown == nil {
+		t.Errorf("The owner can view all sections, actual: %+v", owner)
+	}
+}
+
+func TestInnerUser(t *testing.T) {
+	for _, c := range []struct {
+		userId uint64
+		inner  bool
+	}{{0, false}, {1, true}, {MAX_INNER_USER_ID, true}, {MAX_INNER_USER_ID + 1, false}} {
+		user := User{UserId: c.userId, UserName: "testkid"}
+		if user.IsInnerUser() != c.inner {
+			t.Errorf("The IsInnerUser of %d should be %v", c.userId, c.inner)
+		}
+		data, err := json.Marshal(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if official := strings.Contains(string(data), `"official":true`); official != c.inner {
+			t.Errorf("The official badge of %d should be %v, actual: %s", c.userId, c.inner, data)
+		}
+	}
+	if InnerKindOf(K_MODERATOR.Code) != K_MODERATOR || InnerKindOf(0) != nil {
+		t.Errorf("InnerKindOf is error")
+	}
+}
+
+// stubDriver is a database/sql driver which returns the same rows for any
+// query, or the rows and the error answered by handle for the statement, so
+// the models can be tested without MySQL. The executed statements are
+// recorded in execs.
+type stubDriver struct {
+	columns []string
+	rows    [][]driver.Value
+	handle  func(query string, args []driver.Value) (*stubRows, error)
+	execs   []stubExec
+}
+
+type stubExec struct {
+	query string
+	args  []driver.Value
+}
+
+type stubStmt struct {
+	d     *stubDriver
+	query string
+}
+
+// stubResult is the result of an exec, it is also the last insert id.
+type stubResult int64
+
+func (d *stubDriver) Open(_ string) (driver.Conn, error)        { return d, nil }
+func (d *stubDriver) Prepare(query string) (driver.Stmt, error) { return &stubStmt{d, query}, nil }
+func (d *stubDriver) Close() error                              { return nil }
+func (d *stubDriver) Begin() (driver.Tx, error)                 { return d, nil }
+func (d *stubDriver) Commit() error                             { return nil }
+func (d *stubDriver) Rollback() error                           { return nil }
+
+// Returns the executed statements which contain the s.
+func (d *stubDriver) execsOf(s string) []stubExec {
+	execs := make([]stubExec, 0)
+	for _, e := range d.execs {
+		if strings.Contains(e.query, s) {
+			execs = append(execs, e)
+		}
+	}
+	return execs
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.execs = append(s.d.execs, stubExec{s.query, args})
+	if s.d.handle != nil {
+		if _, err := s.d.handle(s.query, args); err != nil {
+			return nil, err
+		}
+	}
+	return stubResult(len(s.d.execs)), nil
+}
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.d.handle != nil {
+		rows, err := s.d.handle(s.query, args)
+		if rows == nil && err == nil {
+			rows = &stubRows{}
+		}
+		return rows, err
+	}
+	return &stubRows{s.d.columns, s.d.rows}, nil
+}
+
+func (r stubResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r stubResult) RowsAffected() (int64, error) { return 1, nil }
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestLoadInnerAccount(t *testing.T) {
+	sql.Register("stub-inner-account", &stubDriver{
+		columns: []string{F_USER_ID, F_USER_NAME, F_KIND_CODE},
+		rows:    [][]driver.Value{{int64(1), []byte("official"), int64(K_ANNOUNCER.Code)}},
+	})
+	db, err := sql.Open("stub-inner-account", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	accounts := ToInnerAccounts(dbm.Select(InnerAccount{}, "SELECT "+F_USER_ID+" FROM "+INNER_ACCOUNT_TABLE))
+	if len(accounts) != 1 || accounts[0].Kind != K_ANNOUNCER {
+		t.Errorf("The kind of the loaded account should be set by PostGet, actual: %v", accounts)
+	}
+}
+
+func TestReserveInnerUserIds(t *testing.T) {
+	var next, maxId int64
+	d := &stubDriver{handle: func(query string, args []driver.Value) (*stubRows, error) {
+		switch query {
+		case autoIncrementSql:
+			return &stubRows{[]string{"AUTO_INCREMENT"}, [][]driver.Value{{next}}}, nil
+		case maxInnerUserIdSql:
+			return &stubRows{[]string{"max"}, [][]driver.Value{{maxId}}}, nil
+		}
+		return nil, nil
+	}}
+	sql.Register("stub-inner-user-ids", d)
+	db, err := sql.Open("stub-inner-user-ids", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	for _, c := range []struct {
+		next   int64
+		raised bool
+	}{{1, true}, {int64(MAX_INNER_USER_ID), true}, {int64(MAX_INNER_USER_ID) + 1, false}, {20001, false}} {
+		next, d.execs = c.next, nil
+		raised, err := ReserveInnerUserIds(dbm)
+		if altered := len(d.execsOf(reserveInnerUserIdsSql)) == 1; err != nil || raised != c.raised ||
+			altered != c.raised {
+			t.Errorf("The AUTO_INCREMENT %d should be raised: %v, actual: %v %v", c.next, c.raised, raised, err)
+		}
+	}
+	if reserveInnerUserIdsSql != "ALTER TABLE sk_user AUTO_INCREMENT = 10001" {
+		t.Errorf("The reserved ids are error, actual: %s", reserveInnerUserIdsSql)
+	}
+
+	for _, c := range []struct {
+		maxId  int64
+		userId uint64
+		err    error
+	}{{0, 1, nil}, {9, 10, nil}, {int64(MAX_INNER_USER_ID) - 1, MAX_INNER_USER_ID, nil},
+		{int64(MAX_INNER_USER_ID), 0, InnerUserIdsExhaustedError}} {
+		maxId = c.maxId
+		if userId, err := NextInnerUserId(dbm); userId != c.userId || err != c.err {
+			t.Errorf("The next inner user id after %d should be %d, actual: %d %v", c.maxId, c.userId, userId, err)
+		}
+	}
+}
+
+// stubFriendships answers the friendship statements by the status of the
+// rows in memory, keyed by the user id and the friend id.
+type stubFriendships map[[2]int64]int16
+
+func (s stubFriendships) handle(query string, args []driver.Value) (*stubRows, error) {
+	key := func(userId, friendId driver.Value) [2]int64 {
+		return [2]int64{userId.(int64), friendId.(int64)}
+	}
+	switch {
+	case query == countFriendshipSql:
+		count := int64(0)
+		if status, ok := s[key(args[0], args[1])]; ok && int64(status) == args[2].(int64) {
+			count = 1
+		}
+		return &stubRows{[]string{"count"}, [][]driver.Value{{count}}}, nil
+	case query == deleteAcceptedSql:
+		if int64(s[key(args[0], args[1])]) == args[2].(int64) {
+			delete(s, key(args[0], args[1]))
+		}
+	case query == deleteFriendshipSql, strings.HasPrefix(query, "delete from `"+FRIENDSHIP_TABLE):
+		delete(s, key(args[0], args[1]))
+	case strings.HasPrefix(query, "insert into `"+FRIENDSHIP_TABLE),
+		strings.HasPrefix(query, "update `"+FRIENDSHIP_TABLE):
+		s[key(args[0], args[2])] = int16(args[4].(int64))
+	case strings.HasPrefix(query, "select `user_id`"):
+		rows := &stubRows{columns: strings.Split(FriendshipFields, ", ")}
+		if status, ok := s[key(args[0], args[1])]; ok {
+			rows.rows = [][]driver.Value{{args[0], []byte("user"), args[1], []byte("friend"),
+				int64(status), nil, nil}}
+		}
+		return rows, nil
+	}
+	return nil, nil
+}
+
+func TestFriendship(t *testing.T) {
+	friendships := stubFriendships{}
+	sql.Register("stub-friendship", &stubDriver{handle: friendships.handle})
+	db, err := sql.Open("stub-friendship", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	dbm.AddTableWithName(Friendship{}, FRIENDSHIP_TABLE).SetKeys(false, "UserId", "FriendId")
+	a, b := &User{UserId: 10001, UserName: "kid_a"}, &User{UserId: 10002, UserName: "kid_b"}
+
+	// the mutual requests are accepted at once
+	if f, err := RequestFriend(dbm, a, b); err != nil || f.Status != FRIENDSHIP_PENDING {
+		t.Fatalf("The request should be pending, actual: %v %v", f, err)
+	}
+	if _, err := RequestFriend(dbm, a, b); err != AlreadyRequestedError {
+		t.Errorf("The request can not be repeated, actual: %v", err)
+	}
+	if f, err := RequestFriend(dbm, b, a); err != nil || f.Status != FRIENDSHIP_ACCEPTED {
+		t.Fatalf("The reverse request should accept the friendship, actual: %v %v", f, err)
+	}
+	if !AreFriends(dbm, a.UserId, b.UserId) || !AreFriends(dbm, b.UserId, a.UserId) {
+		t.Errorf("Both directions should be accepted, actual: %v", friendships)
+	}
+
+	// unfollowing removes both accepted rows
+	if _, err := Unfollow(dbm, a.UserId, b.UserId); err != nil || len(friendships) != 0 {
+		t.Errorf("The friendship should be removed, actual: %v %v", friendships, err)
+	}
+
+	// the blocked user can not request
+	if f, err := BlockUser(dbm, a, b); err != nil || f.Status != FRIENDSHIP_BLOCKED {
+		t.Fatalf("The user should be blocked, actual: %v %v", f, err)
+	}
+	if _, err := RequestFriend(dbm, b, a); err != FriendBlockedError {
+		t.Errorf("The blocked user can not request, actual: %v", err)
+	}
+	if !IsBlocked(dbm, a.UserId, b.UserId) || !IsBlocked(dbm, b.UserId, a.UserId) {
+		t.Errorf("The block should be seen from both users")
+	}
+	if f, err := RequestFriend(dbm, a, b); err != nil || f.Status != FRIENDSHIP_PENDING ||
+		IsBlocked(dbm, a.UserId, b.UserId) {
+		t.Errorf("The request should unblock the user, actual: %v %v", f, err)
+	}
+}
+
+func TestUserSearch(t *testing.T) {
+	now := time.Now()
+	if where, args := (UserSearch{}).where(now); where != "" || len(args) != 0 {
+		t.Errorf("The empty search should have no conditions, actual: %s %v", where, args)
+	}
+	search := UserSearch{NamePrefix: " kid_1%", Email: "Kid@Example.com", MinGrade: 2,
+		Hometown: RegionFilter{ProvinceId: 11}, Banned: FILTER_YES,
+		RegisteredTo: time.Date(2013, time.May, 1, 0, 0, 0, 0, time.UTC)}
+	where, args := search.where(now)
+	for _, s := range []string{"x.user_name LIKE ?", "x.email = ?", "d.user_grade >= ?",
+		"i.ht_province_id = ?", "EXISTS (SELECT 1 FROM sk_banned_user", "x.created_time < ?"} {
+		if !strings.Contains(where, s) {
+			t.Errorf("The where clause should contain %q, actual: %s", s, where)
+		}
+	}
+	if strings.Contains(where, "hometown_v_code") {
+		t.Errorf("The ruler search should not check the visibility, actual: %s", where)
+	}
+	if len(args) != 6 || args[0] != "kid\\_1\\%%" || args[1] != "kid@example.com" ||
+		!args[5].(time.Time).Equal(time.Date(2013, time.May, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("The args of the search are error, actual: %v", args)
+	}
+
+	public, _ := search.Public().where(now)
+	for _, s := range []string{"x.is_activated = 1", "NOT EXISTS", "i.hometown_v_code IN (0, 1)"} {
+		if !strings.Contains(public, s) {
+			t.Errorf("The public where clause should contain %q, actual: %s", s, public)
+		}
+	}
+	if strings.Contains(public, "x.email") {
+		t.Errorf("The public search can not filter the email, actual: %s", public)
+	}
+
+	if sort, err := NewUserSort("desc", []string{"grade", "created"}); err != nil ||
+		sort.SqlString() != " ORDER BY d.user_grade DESC, x.created_time DESC" {
+		t.Errorf("NewUserSort is error, actual: %v, %v", sort, err)
+	}
+	for _, c := range [][]string{{"ASC", "hash_password"}, {"ASC; DROP", "uid"}, {"ASC"}} {
+		if _, err := NewUserSort(c[0], c[1:]); err != InvalidSortPropertyError {
+			t.Errorf("NewUserSort(%v) should be rejected", c)
+		}
+	}
+}
+
+func TestUserDataArchive(t *testing.T) {
+	user := &User{UserId: 10001, UserName: "testkid", Email: "kid@example.com"}
+	data := &UserData{Account: &Account{User: user, Email: user.Email},
+		Threads: []interface{}{&Thread{Id: 1, UserId: user.UserId, Title: "hello"}}}
+	zip, err := data.Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := archive.ReadManifest(zip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, entry := range manifest.Entries {
+		counts[entry.Name] = entry.Count
+	}
+	if len(counts) != 10 || counts["account.json"] != 1 || counts["threads.json"] != 1 ||
+		counts["identity.json"] != 0 || counts["photos.json"] != 0 {
+		t.Errorf("The manifest entries are error, actual: %v", counts)
+	}
+
+	last := deleteUserDataSqls[len(deleteUserDataSqls)-1]
+	if last != "DELETE FROM sk_user WHERE user_id = ?" {
+		t.Errorf("The user must be deleted at last, actual: %s", last)
+	}
+	if _, err = DeleteUserData(nil, &User{UserId: 1}); err != InnerUserDeletionError {
+		t.Errorf("The inner user can not be deleted, actual: %v", err)
+	}
+	request := NewUserDataRequest(user, DATA_EXPORT).Done("exports/1.zip", "/exports/1.zip", time.Hour)
+	if request.Status != DATA_REQUEST_DONE || !request.ExpiresTime.Valid ||
+		request.Expire().Status != DATA_REQUEST_EXPIRED || request.ArchiveUri != "" {
+		t.Errorf("The request status is error, actual: %v", request)
+	}
+	if cause := NewUserDataRequest(user, DATA_DELETION).Fail(strings.Repeat("错", 600)).FailedCause; len([]rune(cause)) != 500 {
+		t.Errorf("The failed cause should be cut to 500 characters, actual: %d", len([]rune(cause)))
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	if emailInUseSql != "SELECT COUNT(user_id) FROM sk_user WHERE (email = ? OR spare_email = ?) AND user_id <> ?" {
+		t.Errorf("The email must be unique across both columns, actual: %s", emailInUseSql)
+	}
+	if !strings.Contains(requestedEmailChangeSql, "AND new_email = ?") ||
+		!strings.Contains(confirmedEmailChangeSql, "AND old_email = ?") {
+		t.Errorf("The change queries are error, actual: %s; %s", requestedEmailChangeSql,
+			confirmedEmailChangeSql)
+	}
+	user := &User{UserId: 10001, UserName: "testkid", Email: "kid@example.com",
+		SpareEmail: sql.NullString{"spare@example.com", true}}
+	if user.emailOf(EMAIL_PRIMARY) != user.Email || user.emailOf(EMAIL_SPARE) != "spare@example.com" {
+		t.Errorf("The email of the kind is error")
+	}
+	for _, email := range []string{user.Email, user.SpareEmail.String} {
+		if _, err := RequestEmailChange(nil, user, EMAIL_SPARE, email); err != SameEmailError {
+			t.Errorf("The current address %s can not be requested, actual: %v", email, err)
+		}
+	}
+	if _, err := RemoveSpareEmail(nil, &User{UserId: 10002}); err != EmailChangeNotFoundError {
+		t.Errorf("The user has no spare email to remove, actual: %v", err)
+	}
+}
+
+func emailChangeRows(changes ...*EmailChange) *stubRows {
+	rows := &stubRows{columns: strings.Split(EmailChangeFields, ", ")}
+	for _, c := range changes {
+		rows.rows = append(rows.rows, []driver.Value{int64(c.Id), int64(c.UserId), []byte(c.UserName),
+			int64(c.Kind), []byte(c.OldEmail), []byte(c.NewEmail), int64(c.Status),
+			c.ConfirmedTime.Time, nil, c.ConfirmedTime.Time, c.ConfirmedTime.Time})
+	}
+	return rows
+}
+
+func TestUndoEmailChange(t *testing.T) {
+	// the email is changed from a to b, then from b to c
+	confirmed := mysql.NullTime{time.Now(), true}
+	toB := &EmailChange{Id: 11, UserId: 10001, UserName: "testkid", Kind: EMAIL_PRIMARY,
+		OldEmail: "a@example.com", NewEmail: "b@example.com", Status: EMAIL_CHANGE_CONFIRMED,
+		ConfirmedTime: confirmed}
+	toC := &EmailChange{Id: 12, UserId: 10001, UserName: "testkid", Kind: EMAIL_PRIMARY,
+		OldEmail: "b@example.com", NewEmail: "c@example.com", Status: EMAIL_CHANGE_CONFIRMED,
+		ConfirmedTime: confirmed}
+	d := &stubDriver{handle: func(query string, args []driver.Value) (*stubRows, error) {
+		switch query {
+		case confirmedEmailChangeSql:
+			for _, c := range []*EmailChange{toC, toB} {
+				if c.Status == EMAIL_CHANGE_CONFIRMED && args[3] == c.OldEmail {
+					return emailChangeRows(c), nil
+				}
+			}
+			return emailChangeRows(), nil
+		case confirmedChangesAfterSql:
+			if toC.Status == EMAIL_CHANGE_CONFIRMED && args[3] == int64(toB.Id) {
+				return emailChangeRows(toC), nil
+			}
+			return emailChangeRows(), nil
+		case emailInUseSql, spareChangedAfterSql:
+			return &stubRows{[]string{"count"}, [][]driver.Value{{int64(0)}}}, nil
+		}
+		return nil, nil
+	}}
+	sql.Register("stub-undo-email-change", d)
+	db, err := sql.Open("stub-undo-email-change", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbm := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
+	dbm.AddTableWithName(User{}, USER_TABLE).SetKeys(true, "UserId")
+	dbm.AddTableWithName(EmailChange{}, EMAIL_CHANGE_TABLE).SetKeys(true, "Id")
+
+	user := &User{UserId: 10001, UserName: "testkid", Email: "x@example.com"}
+	if _, err := UndoEmailChange(dbm, user, "a@example.com"); err != EmailChangeNotFoundError ||
+		len(d.execs) != 0 {
+		t.Errorf("The change not leading to the current email can not be undone, actual: %v", err)
+	}
+	// undoes b to c first, then a to b
+	user.Email = "c@example.com"
+	if change, err := UndoEmailChange(dbm, user, "b@example.com"); err != nil || change.Id != toC.Id ||
+		user.Email != "b@example.com" || len(d.execsOf("update `"+EMAIL_CHANGE_TABLE+"`")) != 1 {
+		t.Errorf("The latest change should be undone, actual: %v %v", change, err)
+	}
+	toC.Status = EMAIL_CHANGE_UNDONE
+	if change, err := UndoEmailChange(dbm, user, "a@example.com"); err != nil || change.Id != toB.Id ||
+		user.Email != "a@example.com" {
+		t.Errorf("The change should be undone after the later one, actual: %v %v", change, err)
+	}
+
+	// undoes a to b directly, b to c is undone too
+	toC.Status, user.Email, d.execs = EMAIL_CHANGE_CONFIRMED, "c@example.com", nil
+	change, err := UndoEmailChange(dbm, user, "a@example.com")
+	if err != nil || change.Id != toB.Id || user.Email != "a@example.com" {
+		t.Fatalf("The earlier change in the chain should be undone, actual: %v %v", change, err)
+	}
+	undone := d.execsOf("update `" + EMAIL_CHANGE_TABLE + "`")
+	if len(undone) != 2 || undone[0].args[5] != int64(EMAIL_CHANGE_UNDONE) ||
+		undone[1].args[5] != int64(EMAIL_CHANGE_UNDONE) || undone[1].args[10] != int64(toC.Id) {
+		t.Errorf("The later changes should be undone too, actual: %v", undone)
+	}
+	if tokens := d.execsOf(invalidateMailedTokensSql); len(tokens) != 1 ||
+		!strings.Contains(invalidateMailedTokensSql, fmt.Sprintf("%d", TOKEN_UNDO_EMAIL_CHANGE)) {
+		t.Errorf("The other undo links should be invalidated, actual: %s", invalidateMailedTokensSql)
+	}
+	if len(d.execsOf(deleteUserSessionsSql)) != 1 {
+		t.Errorf("The sessions of the user should be deleted")
+	}
+}
+
+func TestAppGrantTokens(t *testing.T) {
+	grant := NewAppGrant(&App{Id: 1, Name: "app"}, &User{UserId: 10001, UserName: "testkid"})
+	code := grant.NewAuthCode("http://app.example.com/cb", "basic email", time.Minute)
+	for _, redirectUri := range []string{"http://evil.example.com/cb", ""} {
+		if err := grant.UseAuthCode(code, redirectUri); err != Err_Redirect_URI_Mismatch {
+			t.Errorf("The redirect uri %q must match, actual: %v", redirectUri, err)
+		}
+	}
+	if err := grant.UseAuthCode(code, "http://app.example.com/cb"); err != nil {
+		t.Errorf("The auth code should be exchanged, actual: %v", err)
+	}
+	if err := grant.UseAuthCode(code, "http://app.example.com/cb"); err != Err_Invalid_Grant {
+		t.Errorf("The auth code can be used only once, actual: %v", err)
+	}
+	accessToken, refreshToken := grant.IssueTokens(time.Hour, 24*time.Hour)
+	response := grant.TokenResponse(accessToken, refreshToken)
+	if response["access_token"] == "" || response["refresh_token"] == "" ||
+		response["token_type"] != TOKEN_TYPE_BEARER || response["expires_in"].(int64) <= 3500 {
+		t.Errorf("The token response is error, actual: %v", response)
+	}
+	if grant.HashAccessToken.String != tokens.Hash(accessToken) ||
+		grant.HashRefreshToken.String == refreshToken {
+		t.Errorf("Only the hashes of the tokens should be stored, actual: %v", grant)
+	}
+	if err := grant.UseRefreshToken(refreshToken); err != nil {
+		t.Errorf("The refresh token should be usable, actual: %v", err)
+	}
+	if err := grant.UseRefreshToken(grant.HashRefreshToken.String); err != Err_Invalid_Grant {
+		t.Errorf("The stored hash is not a refresh token, actual: %v", err)
+	}
+	if grant.Scope != "basic email" || response["scope"] != grant.Scope {
+		t.Errorf("The scope of the code should be granted, actual: %s", grant.Scope)
+	}
+	code = grant.NewAuthCode("http://app.example.com/cb", "friends", -time.Second)
+	if err := grant.UseAuthCode(code, "http://app.example.com/cb"); err != Err_Expired_Token || grant.HashAuthCode != "" {
+		t.Errorf("The expired auth code should be cleared, actual: %v", err)
+	}
+	if !tokens.Match(refreshToken, grant.HashRefreshToken.String) || grant.Scope != "basic email" {
+		t.Error("A new auth code should not change the issued tokens")
+	}
+	if Err_Invalid_Client.Status() != 401 || Err_Invalid_Grant.Status() != 400 {
+		t.Error("The HTTP status of the auth errors is error")
+	}
+
+	for scope, expected := range map[string]string{
+		"":                      DEFAULT_SCOPE,
+		" email  basic email ":  "basic email",
+		"friends profile basic": "basic friends profile",
+	} {
+		if actual, err := ParseScope(scope); err != nil || actual != expected {
+			t.Errorf("The scope of %q should be %q, actual: %q, %v", scope, expected, actual, err)
+		}
+	}
+	if _, err := ParseScope("basic admin"); err != Err_invalid_scope {
+		t.Errorf("The unknown scope is invalid, actual: %v", err)
+	}
+	for scope, covered := range map[string]bool{"basic": true, "basic email": true,
+		"email friends": false, "profile": false} {
+		if grant.Covers(scope) != covered {
+			t.Errorf("The granted %q covers %q should be %v", grant.Scope, scope, covered)
+		}
+	}
+}
+
+func TestAppMatchRedirectUri(t *testing.T) {
+	app := App{Url: "http://app.example.com/kids"}
+	for uri, matched := range map[string]bool{
+		"http://app.example.com/kids":            true,
+		"http://app.example.com/kids/cb?x=1":     true,
+		"http://APP.example.com/kids/cb":         true,
+		"http://app.example.com/kidsevil/cb":     false,
+		"http://app.example.com/kids/../evil/cb": false,
+		"http://app.example.com/other/cb":        false,
+		"https://app.example.com/kids/cb":        false,
+		"http://evil.com/kids/cb":                false,
+		"http://app.example.com.evil.com/kids":   false,
+		"http://evil.com@app.example.com/kids":   false,
+		"http://app.example.com/kids/cb#frag":    false,
+		"/kids/cb":                               false,
+		"":                                       false,
+	} {
+		if app.MatchRedirectUri(uri) != matched {
+			t.Errorf("The redirect uri %q matched should be %v", uri, matched)
+		}
+	}
+	app.IsBindDomain = true
+	for uri, matched := range map[string]bool{
+		"http://app.example.com/other/cb":      true,
+		"http://m.app.example.com/cb":          true,
+		"http://evilapp.example.com/cb":        false,
+		"http://app.example.com.evil.com/kids": false,
+	} {
+		if app.MatchRedirectUri(uri) != matched {
+			t.Errorf("The redirect uri %q of the bound domain matched should be %v", uri, matched)
+		}
+	}
+	if (App{Url: "app.example.com"}).MatchRedirectUri("http://app.example.com/cb") {
+		t.Error("The app without an absolute url matches nothing")
+	}
+}
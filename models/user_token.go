@@ -0,0 +1,140 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package models
+
+import (
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"smart-kids/tokens"
+	"strings"
+	"time"
+)
+
+const (
+	USER_TOKEN_TABLE = "sk_user_token"
+)
+
+// UserToken type constants
+const (
+	TOKEN_ACTIVATION         = uint16(1) // 激活账号
+	TOKEN_RESET_PASSWORD     = uint16(2) // 重置密码
+	TOKEN_CHANGE_EMAIL       = uint16(3) // 确认新的登录邮箱
+	TOKEN_UNDO_EMAIL_CHANGE  = uint16(4) // 撤销登录邮箱的修改
+	TOKEN_VERIFY_SPARE_EMAIL = uint16(5) // 验证备用邮箱
+)
+
+// sk_user_token fields constants
+const (
+	F_TOKEN_TYPE   = "token_type"
+	F_HASH_TOKEN   = "hash_token"
+	F_IS_USED      = "is_used"
+	F_EXPIRED_TIME = "expired_time"
+	F_USED_TIME    = "used_time"
+)
+
+var (
+	UserTokenFields = strings.Join([]string{
+		F_ID, F_USER_ID, F_USER_NAME, F_TOKEN_TYPE, F_HASH_TOKEN, F_EMAIL,
+		F_IS_USED, F_EXPIRED_TIME, F_USED_TIME, F_CREATED_TIME,
+	}, ", ")
+)
+
+// Single-use token sent to a user by mail, only the hash of the token is stored.
+type UserToken struct {
+	Id          uint64         `db:"id"`
+	UserId      uint64         `db:"user_id"`
+	UserName    string         `db:"user_name"`
+	TokenType   uint16         `db:"token_type"`
+	HashToken   string         `db:"hash_token"` // Unique Index
+	Email       string         `db:"email"`      // the address the token was sent to
+	IsUsed      bool           `db:"is_used"`
+	ExpiredTime mysql.NullTime `db:"expired_time"`
+	UsedTime    mysql.NullTime `db:"used_time"`
+	CreatedTime mysql.NullTime `db:"created_time"`
+}
+
+// Returns a new UserToken of the specified user and the raw token,
+// the raw token is only known by the mail recipient.
+func NewUserToken(user *User, tokenType uint16, email string, expires time.Duration) (*UserToken, string) {
+	token, hash := tokens.NewToken()
+	userToken := &UserToken{
+		UserId: user.UserId, UserName: user.UserName, TokenType: tokenType,
+		HashToken: hash, Email: email,
+		ExpiredTime: mysql.NullTime{time.Now().Add(expires), true},
+	}
+	return userToken, token
+}
+
+// Returns sha256 hex string of the raw token.
+func HashToken(token string) string {
+	return tokens.Hash(token)
+}
+
+// Returns true if this token is expired.
+func (u UserToken) IsExpired() bool {
+	return !u.ExpiredTime.Valid || u.ExpiredTime.Time.Before(time.Now())
+}
+
+// Returns true if this token is neither used nor expired.
+func (u UserToken) IsUsable() bool {
+	return !u.IsUsed && !u.IsExpired()
+}
+
+// Marks this token used.
+func (u *UserToken) Use() *UserToken {
+	u.IsUsed = true
+	u.UsedTime = mysql.NullTime{time.Now(), true}
+	return u
+}
+
+func (u UserToken) String() string {
+	return fmt.Sprintf("UserToken{Id=%d, User=(%d, %s), TokenType=%d, Email=%s, "+
+		"IsUsed=%v, ExpiredTime=%v}", u.Id, u.UserId, u.UserName, u.TokenType,
+		u.Email, u.IsUsed, u.ExpiredTime.Time)
+}
+
+func (u *UserToken) PreInsert(_ gorp.SqlExecutor) error {
+	u.CreatedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+func ToUserToken(i interface{}, err error) *UserToken {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*UserToken)
+}
+
+func ToUserTokens(results []interface{}, err error) []*UserToken {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	userTokens := make([]*UserToken, size)
+	if size == 0 {
+		return userTokens
+	}
+	for i, r := range results {
+		userTokens[i] = r.(*UserToken)
+	}
+	return userTokens
+}
@@ -0,0 +1,315 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coopernurse/gorp"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"smart-kids/query"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+const (
+	WALLET_TXN_TABLE   = "sk_wallet_txn"
+	WALLET_ENTRY_TABLE = "sk_wallet_entry"
+)
+
+// Wallet transaction type constants
+const (
+	WALLET_RECHARGE   = uint16(1) // 充值
+	WALLET_SPEND      = uint16(2) // 消费
+	WALLET_REFUND     = uint16(3) // 退款
+	WALLET_ADJUSTMENT = uint16(4) // 管理员调整（增加余额）
+)
+
+// The system accounts of wallet entries, a user's wallet account is
+// "user:<userId>".
+const (
+	ACCOUNT_RECHARGE = "system:recharge" // the money paid in by parents
+	ACCOUNT_REVENUE  = "system:revenue"  // the money spent by users
+)
+
+// The MySQL error number of a duplicate entry of a unique key.
+const errDupEntry = 1062
+
+// sk_wallet_txn and sk_wallet_entry fields constants
+const (
+	F_IDEMPOTENCY_KEY = "idempotency_key"
+	F_TXN_TYPE        = "txn_type"
+	F_AMOUNT          = "amount"
+	F_MEMO            = "memo"
+	F_TXN_ID          = "txn_id"
+	F_ACCOUNT         = "account"
+)
+
+var (
+	WalletTxnFields = strings.Join([]string{
+		F_ID, F_IDEMPOTENCY_KEY, F_TXN_TYPE, F_USER_ID, F_USER_NAME, F_AMOUNT,
+		F_BALANCE, F_REF_ID, F_MEMO, F_OPERATOR_ID, F_OPERATOR_NAME, F_CREATED_TIME,
+	}, ", ")
+	WalletEntryFields = strings.Join([]string{
+		F_ID, F_TXN_ID, F_ACCOUNT, F_AMOUNT, F_CREATED_TIME,
+	}, ", ")
+
+	walletTxnByKeySql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		WalletTxnFields, WALLET_TXN_TABLE, F_IDEMPOTENCY_KEY)
+	// reads the latest committed transaction instead of the snapshot of the caller
+	lockWalletTxnByKeySql = walletTxnByKeySql + " LOCK IN SHARE MODE"
+	walletStatementSql    = query.SimpleQuerySql(WalletTxnFields, WALLET_TXN_TABLE, "x") +
+		fmt.Sprintf(" WHERE x.%s = ?", F_USER_ID)
+	countWalletStatementSql = query.CountSql(F_ID, WALLET_TXN_TABLE) +
+		fmt.Sprintf(" WHERE x.%s = ?", F_USER_ID)
+	addBalanceSql = fmt.Sprintf("UPDATE %s SET %s = %s + ?, %s = %s + ?, %s = ? WHERE %s = ?",
+		USER_DIGITAL_TABLE, F_BALANCE, F_BALANCE, F_TOTAL_AMOUNT, F_TOTAL_AMOUNT,
+		F_LAST_MODIFIED_TIME, F_USER_ID)
+	subtractBalanceSql = fmt.Sprintf("UPDATE %s SET %s = %s - ?, %s = ? WHERE %s = ? AND %s >= ?",
+		USER_DIGITAL_TABLE, F_BALANCE, F_BALANCE, F_LAST_MODIFIED_TIME, F_USER_ID, F_BALANCE)
+	sumAccountSql = fmt.Sprintf("SELECT COALESCE(SUM(%s), 0) FROM %s WHERE %s = ?",
+		F_AMOUNT, WALLET_ENTRY_TABLE, F_ACCOUNT)
+
+	IdempotencyConflictError = errors.New("Idempotency.conflict")
+
+	invalidWalletTxnError = errors.New("The wallet transaction must have a type, " +
+		"an idempotency key and a positive amount.")
+)
+
+// Returns the wallet account name of the specified user.
+func UserAccount(userId uint64) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// A wallet transaction of a user, the IdempotencyKey is supplied by the caller,
+// so a retried call never applies the same transaction twice. Amount is always
+// positive, Balance is the user's balance after this transaction.
+type WalletTxn struct {
+	Id             uint64         `db:"id" json:"id"`
+	IdempotencyKey string         `db:"idempotency_key" json:"-"` // Unique Index
+	TxnType        uint16         `db:"txn_type" json:"type"`
+	UserId         uint64         `db:"user_id" json:"uid"`
+	UserName       string         `db:"user_name" json:"name"`
+	Amount         uint64         `db:"amount" json:"amount"`
+	Balance        uint64         `db:"balance" json:"balance"`
+	RefId          string         `db:"ref_id" json:"refId"` // the id of the payment, order, etc.
+	Memo           string         `db:"memo" json:"memo"`
+	OperatorId     int            `db:"operator_id" json:"-"`
+	OperatorName   string         `db:"operator_name" json:"-"`
+	CreatedTime    mysql.NullTime `db:"created_time" json:"-"`
+}
+
+// One side of a double-entry wallet transaction, the amounts of the entries
+// of a transaction sum to zero. A positive amount increases the account.
+type WalletEntry struct {
+	Id          uint64         `db:"id"`
+	TxnId       uint64         `db:"txn_id"`
+	Account     string         `db:"account"`
+	Amount      int64          `db:"amount"`
+	CreatedTime mysql.NullTime `db:"created_time"`
+}
+
+func NewWalletTxn(user *User, txnType uint16, key string, amount uint64, refId, memo string) *WalletTxn {
+	return &WalletTxn{
+		IdempotencyKey: key, TxnType: txnType, UserId: user.UserId,
+		UserName: user.UserName, Amount: amount, RefId: refId, Memo: memo,
+	}
+}
+
+// Sets the admin who made this transaction, the system is the operator by default.
+func (w *WalletTxn) OperatedBy(operatorId int, operatorName string) *WalletTxn {
+	w.OperatorId, w.OperatorName = operatorId, operatorName
+	return w
+}
+
+// Returns true if this transaction increases the user's balance.
+func (w WalletTxn) IsCredit() bool {
+	return w.TxnType != WALLET_SPEND
+}
+
+// Returns the double entries of this transaction.
+func (w WalletTxn) Entries() []*WalletEntry {
+	other := ACCOUNT_RECHARGE
+	if w.TxnType == WALLET_SPEND || w.TxnType == WALLET_REFUND {
+		other = ACCOUNT_REVENUE
+	}
+	amount := int64(w.Amount)
+	if !w.IsCredit() {
+		amount = -amount
+	}
+	return []*WalletEntry{
+		&WalletEntry{TxnId: w.Id, Account: UserAccount(w.UserId), Amount: amount},
+		&WalletEntry{TxnId: w.Id, Account: other, Amount: -amount},
+	}
+}
+
+// Returns true if the other transaction has the same content as this one.
+func (w WalletTxn) sameAs(other *WalletTxn) bool {
+	return w.TxnType == other.TxnType && w.UserId == other.UserId &&
+		w.Amount == other.Amount
+}
+
+func (w WalletTxn) String() string {
+	return fmt.Sprintf("WalletTxn{Id=%d, Key=%s, Type=%d, User=(%d, %s), Amount=%d, "+
+		"Balance=%d, RefId=%s, Operator=(%d, %s)}", w.Id, w.IdempotencyKey, w.TxnType,
+		w.UserId, w.UserName, w.Amount, w.Balance, w.RefId, w.OperatorId, w.OperatorName)
+}
+
+func (w *WalletTxn) PreInsert(_ gorp.SqlExecutor) error {
+	w.CreatedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+func (w *WalletEntry) PreInsert(_ gorp.SqlExecutor) error {
+	w.CreatedTime = mysql.NullTime{time.Now(), true}
+	return nil
+}
+
+// Applies the wallet transaction: inserts the transaction, changes the user's
+// balance by a single SQL statement and appends the double entries. If a
+// transaction of the same idempotency key exists, the stored one is returned
+// with replayed true and nothing is changed, or IdempotencyConflictError if its
+// content differs. Returns InsufficientBalanceError if the balance is less than
+// the spent amount, the executor should be a transaction and rolled back on error.
+func ApplyWalletTxn(exe gorp.SqlExecutor, txn *WalletTxn) (applied *WalletTxn, replayed bool, err error) {
+	if txn.TxnType == 0 || len(txn.IdempotencyKey) == 0 || txn.Amount == 0 {
+		return nil, false, invalidWalletTxnError
+	}
+	if existing := FindWalletTxnByKey(exe, txn.IdempotencyKey); existing != nil {
+		return replayWalletTxn(existing, txn)
+	}
+	// The unique key blocks the concurrent call of the same key before
+	// the balance is changed, it is replayed after the other one commits.
+	if err = exe.Insert(txn); err != nil {
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == errDupEntry {
+			if existing := findWalletTxn(exe, lockWalletTxnByKeySql, txn.IdempotencyKey); existing != nil {
+				return replayWalletTxn(existing, txn)
+			}
+		}
+		return nil, false, err
+	}
+	var affected int64
+	timeNow := time.Now()
+	switch txn.TxnType {
+	case WALLET_RECHARGE, WALLET_ADJUSTMENT:
+		affected, err = execAffected(exe, addBalanceSql, txn.Amount, txn.Amount, timeNow, txn.UserId)
+	case WALLET_REFUND:
+		affected, err = execAffected(exe, addBalanceSql, txn.Amount, 0, timeNow, txn.UserId)
+	default:
+		affected, err = execAffected(exe, subtractBalanceSql, txn.Amount, timeNow, txn.UserId,
+			txn.Amount)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	digital := FindUserDigital(exe, txn.UserId)
+	if digital == nil {
+		return nil, false, DigitalNotFoundError
+	}
+	if affected == 0 {
+		return nil, false, InsufficientBalanceError
+	}
+	txn.Balance = digital.Balance
+	if _, err = exe.Update(txn); err != nil {
+		return nil, false, err
+	}
+	for _, entry := range txn.Entries() {
+		if err = exe.Insert(entry); err != nil {
+			return nil, false, err
+		}
+	}
+	return txn, false, nil
+}
+
+// Returns the existing transaction as replayed, or IdempotencyConflictError
+// if its content differs from the txn.
+func replayWalletTxn(existing, txn *WalletTxn) (*WalletTxn, bool, error) {
+	if !existing.sameAs(txn) {
+		return nil, false, IdempotencyConflictError
+	}
+	return existing, true, nil
+}
+
+// Returns the wallet transaction of the specified idempotency key, or nil.
+func FindWalletTxnByKey(exe gorp.SqlExecutor, key string) *WalletTxn {
+	return findWalletTxn(exe, walletTxnByKeySql, key)
+}
+
+func findWalletTxn(exe gorp.SqlExecutor, query, key string) *WalletTxn {
+	txns := ToWalletTxns(exe.Select(WalletTxn{}, query, key))
+	if len(txns) == 0 {
+		return nil
+	}
+	return txns[0]
+}
+
+// Returns the page wallet transactions of the specified user, the newest first.
+func FindWalletStatement(exe gorp.SqlExecutor, userId uint64, pageable *util.Pageable) *util.Page {
+	total, err := exe.SelectInt(countWalletStatementSql, userId)
+	if total == 0 || err != nil {
+		return util.NewPage(nil, pageable, total)
+	}
+	sql := query.NewSqlBuilder(walletStatementSql).
+		PageOrderBy(pageable, util.DescendingSort([]string{F_ID})).
+		ToSqlString()
+	content, err := exe.Select(WalletTxn{}, sql, userId)
+	if err != nil {
+		panic(err)
+	}
+	return util.NewPage(content, pageable, total)
+}
+
+// Returns the user's balance summed from the wallet entries and the stored
+// balance, they are equal if the wallet of the user is reconciled.
+func ReconcileWallet(exe gorp.SqlExecutor, userId uint64) (entryBalance int64, balance uint64, err error) {
+	if entryBalance, err = exe.SelectInt(sumAccountSql, UserAccount(userId)); err != nil {
+		return 0, 0, err
+	}
+	digital := FindUserDigital(exe, userId)
+	if digital == nil {
+		return entryBalance, 0, DigitalNotFoundError
+	}
+	return entryBalance, digital.Balance, nil
+}
+
+func ToWalletTxn(i interface{}, err error) *WalletTxn {
+	if err != nil {
+		panic(err)
+	}
+	if i == nil || reflect.ValueOf(i).IsNil() {
+		return nil
+	}
+	return i.(*WalletTxn)
+}
+
+func ToWalletTxns(results []interface{}, err error) []*WalletTxn {
+	if err != nil {
+		panic(err)
+	}
+	size := len(results)
+	walletTxns := make([]*WalletTxn, size)
+	if size == 0 {
+		return walletTxns
+	}
+	for i, r := range results {
+		walletTxns[i] = r.(*WalletTxn)
+	}
+	return walletTxns
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package passwd hashes passwords with pluggable algorithms.
+//
+// An encoded hash is versioned by its algorithm id, "<id>$<payload>", e.g.
+//    bcrypt$$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy
+//    scrypt$16384$8$1$<salt>$<key>
+// A hash without the "$" separator is a legacy hex encoded sha1("password{salt}")
+// which keeps its salt in a separate column.
+package passwd
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	LEGACY_SHA1 = "sha1"
+	separator   = "$"
+)
+
+var (
+	unknownHasherError = errors.New("Unknown password hasher.")
+
+	mutex         sync.RWMutex
+	hashers       = make(map[string]Hasher)
+	defaultHasher Hasher
+)
+
+// Implement this interface to add a password hashing algorithm.
+type Hasher interface {
+
+	// Returns the algorithm id, the prefix of the encoded hashes.
+	Id() string
+	// Returns the encoded hash of the password, without the id prefix.
+	Hash(password string) (string, error)
+	// Returns true if the password matches the encoded hash.
+	Verify(password, encoded string) bool
+	// Returns true if the encoded hash was created with weaker parameters.
+	NeedsRehash(encoded string) bool
+}
+
+func init() {
+	Register(NewScryptHasher(DEFAULT_SCRYPT_N, DEFAULT_SCRYPT_R, DEFAULT_SCRYPT_P))
+	Register(NewBcryptHasher(DEFAULT_BCRYPT_COST))
+	defaultHasher = hashers[BCRYPT]
+}
+
+// Registers the hasher, replaces the registered one of the same id.
+func Register(hasher Hasher) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	hashers[hasher.Id()] = hasher
+	if defaultHasher != nil && defaultHasher.Id() == hasher.Id() {
+		defaultHasher = hasher
+	}
+}
+
+// Sets the hasher of the specified id as the current algorithm.
+func SetDefault(id string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	hasher, ok := hashers[id]
+	if !ok {
+		return unknownHasherError
+	}
+	defaultHasher = hasher
+	return nil
+}
+
+// Returns the hasher of the current algorithm.
+func Default() Hasher {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return defaultHasher
+}
+
+func hasherOf(id string) (Hasher, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	hasher, ok := hashers[id]
+	return hasher, ok
+}
+
+// Returns the encoded hash of password with the current algorithm.
+func Hash(password string) (string, error) {
+	hasher := Default()
+	encoded, err := hasher.Hash(password)
+	if err != nil {
+		return "", err
+	}
+	return hasher.Id() + separator + encoded, nil
+}
+
+// Returns the legacy hex encoded sha1("password{salt}").
+func LegacySha1(password, salt string) string {
+	sha1Hash := sha1.New()
+	sha1Hash.Write([]byte(fmt.Sprintf("%s{%s}", password, salt)))
+	return hex.EncodeToString(sha1Hash.Sum(nil))
+}
+
+// Returns true if the encoded hash is a legacy salted sha1 hash.
+func IsLegacy(encoded string) bool {
+	return len(encoded) > 0 && !strings.Contains(encoded, separator)
+}
+
+// Verifies password against the encoded hash, salt is only used by legacy hashes.
+// If the password matches but the hash is legacy or not created by the current
+// algorithm, rehashed is the new encoded hash the caller should store, otherwise
+// rehashed is empty.
+func Check(password, encoded, salt string) (matched bool, rehashed string) {
+	if len(password) == 0 || len(encoded) == 0 {
+		return false, ""
+	}
+	current := Default()
+	if IsLegacy(encoded) {
+		legacy := LegacySha1(password, salt)
+		if subtle.ConstantTimeCompare([]byte(legacy), []byte(encoded)) != 1 {
+			return false, ""
+		}
+	} else {
+		parts := strings.SplitN(encoded, separator, 2)
+		hasher, ok := hasherOf(parts[0])
+		if !ok || len(parts) < 2 || !hasher.Verify(password, parts[1]) {
+			return false, ""
+		}
+		if hasher.Id() == current.Id() && !hasher.NeedsRehash(parts[1]) {
+			return true, ""
+		}
+	}
+	rehashed, err := Hash(password)
+	if err != nil { // keep the old hash, the next login tries again.
+		return true, ""
+	}
+	return true, rehashed
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passwd
+
+import (
+	"strings"
+	"testing"
+)
+
+func init() {
+	// Cheap parameters keep the tests fast.
+	Register(NewBcryptHasher(4))
+	Register(NewScryptHasher(1024, 8, 1))
+}
+
+func TestHashAndCheck(t *testing.T) {
+	for _, id := range []string{BCRYPT, SCRYPT} {
+		if err := SetDefault(id); err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := Hash("secret123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encoded, id+"$") || IsLegacy(encoded) {
+			t.Errorf("Hash should be prefixed by %s$, actual: %s", id, encoded)
+		}
+		if len(encoded) > 100 {
+			t.Errorf("%s hash is longer than the 100 chars column: %d", id, len(encoded))
+		}
+		if matched, rehashed := Check("secret123", encoded, ""); !matched || len(rehashed) > 0 {
+			t.Errorf("%s: Check(%s) = %v, %q", id, encoded, matched, rehashed)
+		}
+		if matched, _ := Check("secret124", encoded, ""); matched {
+			t.Errorf("%s: wrong password matched", id)
+		}
+	}
+	SetDefault(BCRYPT)
+}
+
+func TestLegacySha1Upgrade(t *testing.T) {
+	SetDefault(BCRYPT)
+	legacy := LegacySha1("admin", "admin")
+	if legacy != "a40546cc4fd6a12572828bb803380888ad1bfdab" || !IsLegacy(legacy) {
+		t.Error("LegacySha1 error: ", legacy)
+	}
+	if matched, _ := Check("admin", legacy, "wrong-salt"); matched {
+		t.Error("Legacy hash matched with a wrong salt")
+	}
+	matched, rehashed := Check("admin", legacy, "admin")
+	if !matched || !strings.HasPrefix(rehashed, BCRYPT+"$") {
+		t.Fatalf("Legacy hash should be upgraded, actual: %v, %q", matched, rehashed)
+	}
+	if matched, again := Check("admin", rehashed, ""); !matched || len(again) > 0 {
+		t.Errorf("Upgraded hash should not be rehashed again, actual: %v, %q", matched, again)
+	}
+}
+
+func TestRehashOtherAlgorithm(t *testing.T) {
+	SetDefault(SCRYPT)
+	encoded, _ := Hash("secret123")
+	SetDefault(BCRYPT)
+	matched, rehashed := Check("secret123", encoded, "")
+	if !matched || !strings.HasPrefix(rehashed, BCRYPT+"$") {
+		t.Errorf("scrypt hash should be rehashed by bcrypt, actual: %v, %q", matched, rehashed)
+	}
+}
+
+func TestUnknownHasher(t *testing.T) {
+	if err := SetDefault("md5"); err != unknownHasherError {
+		t.Error("SetDefault should return unknownHasherError, actual: ", err)
+	}
+	if matched, _ := Check("secret123", "md5$abc", ""); matched {
+		t.Error("Unknown algorithm matched")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passwd
+
+import (
+	"code.google.com/p/go.crypto/scrypt"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	SCRYPT           = "scrypt"
+	DEFAULT_SCRYPT_N = 16384
+	DEFAULT_SCRYPT_R = 8
+	DEFAULT_SCRYPT_P = 1
+	scryptSaltLen    = 16
+	scryptKeyLen     = 32
+)
+
+// scrypt hasher, the encoded hash is "N$r$p$salt$key",
+// salt and key are standard base64 encoded.
+type scryptHasher struct {
+	n, r, p int
+}
+
+// Returns a scrypt Hasher of the specified cost parameters.
+func NewScryptHasher(n, r, p int) Hasher {
+	return &scryptHasher{n, r, p}
+}
+
+func (s *scryptHasher) Id() string {
+	return SCRYPT
+}
+
+func (s *scryptHasher) Hash(password string) (string, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, s.n, s.r, s.p, scryptKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d$%d$%d$%s$%s", s.n, s.r, s.p,
+		base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// Returns the cost parameters, salt and key of the encoded hash.
+func (s *scryptHasher) decode(encoded string) (params []int, salt, key []byte, ok bool) {
+	parts := strings.Split(encoded, separator)
+	if len(parts) != 5 {
+		return nil, nil, nil, false
+	}
+	params = make([]int, 3)
+	for i := 0; i < 3; i++ {
+		value, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return nil, nil, nil, false
+		}
+		params[i] = value
+	}
+	var err error
+	if salt, err = base64.StdEncoding.DecodeString(parts[3]); err != nil {
+		return nil, nil, nil, false
+	}
+	if key, err = base64.StdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, false
+	}
+	return params, salt, key, true
+}
+
+func (s *scryptHasher) Verify(password, encoded string) bool {
+	params, salt, key, ok := s.decode(encoded)
+	if !ok {
+		return false
+	}
+	actual, err := scrypt.Key([]byte(password), salt, params[0], params[1], params[2], len(key))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+func (s *scryptHasher) NeedsRehash(encoded string) bool {
+	params, _, _, ok := s.decode(encoded)
+	return !ok || params[0] < s.n || params[1] < s.r || params[2] < s.p
+}
@@ -17,10 +17,7 @@
 package controllers
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	_ "encoding/json"
-	"fmt"
 	"github.com/robfig/revel"
 	"log"
 	m "smart-kids/ruler/app/models"
@@ -34,16 +31,6 @@ type Administrators struct {
 	Application
 }
 
-func hashPassword(srcPwd, salt string) (string, string) {
-	if len(salt) == 0 {
-		salt = util.RandomAlphanumeric(8)
-	}
-	sha1Hash := sha1.New()
-	sha1Hash.Write([]byte(fmt.Sprintf("%s{%s}", srcPwd, salt)))
-	hashPwd := hex.EncodeToString(sha1Hash.Sum(nil))
-	return hashPwd, salt
-}
-
 // Returns admin of the specified id.
 func (a Administrators) findAdmin(id uint32) *m.Admin {
 	return m.ToAdmin(a.Txn.Get(m.Admin{}, id))
@@ -139,7 +126,9 @@ func (a Administrators) SaveAdmin(admin m.Admin) revel.Result {
 	}
 	admin.LastIp.Scan(a.clientIp())
 	if admin.Id <= 0 { // create new admin
-		admin.HashPassword, admin.Salt = hashPassword("123456", "")
+		if err = admin.SetPassword("123456"); err != nil {
+			return a.RenderJson(util.ErrorResult(err.Error()))
+		}
 		if currentAdmin := a.connected(); currentAdmin != nil {
 			admin.CreatedBy(currentAdmin.Id, currentAdmin.AdminName)
 		}
@@ -264,13 +253,12 @@ func (a Administrators) Passwd(oldpwd, newpwd1, newpwd2 string) revel.Result {
 		return result
 	}
 	currentAdmin := a.connected()
-	hashOldpwd, _ := hashPassword(oldpwd, currentAdmin.Salt)
-	if currentAdmin.HashPassword != hashOldpwd {
+	if matched, _ := currentAdmin.MatchPassword(oldpwd); !matched {
 		return a.RenderJson(util.ErrorResult(a.Message("admin.v.oldpwdError")))
 	}
-	newHashPassword, newSalt := hashPassword(newpwd1, "")
-	currentAdmin.HashPassword = newHashPassword
-	currentAdmin.Salt = newSalt
+	if err := currentAdmin.SetPassword(newpwd1); err != nil {
+		return a.RenderJson(util.ErrorResult(err.Error()))
+	}
 	_, err := a.Txn.Update(currentAdmin)
 	if err != nil {
 		return a.RenderJson(util.ErrorResult(err.Error()))
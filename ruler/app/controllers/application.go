@@ -17,8 +17,6 @@
 package controllers
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
 	"github.com/robfig/revel"
 	"log"
@@ -79,19 +77,16 @@ func (c Application) DoLogin(adminName, password string) revel.Result {
 	if admin != nil {
 		if !admin.IsEnabled {
 			c.Flash.Error("用户(%s)已被禁用", adminName)
-		} else {
-			sha1Hash := sha1.New()
-			var srcPassword string
-			if len(admin.Salt) == 0 {
-				srcPassword = fmt.Sprintf("%s{%s}", password, admin.AdminName)
-			} else {
-				srcPassword = fmt.Sprintf("%s{%s}", password, admin.Salt)
-			}
-			sha1Hash.Write([]byte(srcPassword))
-			if admin.HashPassword == hex.EncodeToString(sha1Hash.Sum(nil)) {
-				c.Session["AdminName"] = adminName
-				return c.Redirect(redirectUrl)
+		} else if matched, upgraded := admin.MatchPassword(password); matched {
+			if upgraded {
+				if _, err := c.Txn.Update(admin); err != nil {
+					panic(err)
+				}
 			}
+			c.Session["AdminName"] = adminName
+			return c.Redirect(redirectUrl)
+		} else {
+			c.Flash.Error("用户名或密码错误！")
 		}
 	} else {
 		c.Flash.Error("用户名或密码错误！")
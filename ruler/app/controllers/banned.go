@@ -0,0 +1,173 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"github.com/robfig/revel"
+	"log"
+	"net/url"
+	m "smart-kids/models"
+	"smart-kids/query"
+	"smart-kids/util"
+	"strings"
+	"time"
+)
+
+var (
+	bannedListSql = query.SimpleQuerySql(m.BannedUserFields, m.BANNED_USER_TABLE, "x")
+	bannedUserSql = fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", m.UserFields,
+		m.USER_TABLE, m.F_USER_NAME)
+)
+
+type BannedUsers struct {
+	Application
+}
+
+// Returns the user of the specified user name, or nil if not exists.
+func (b BannedUsers) findUserByName(userName string) *m.User {
+	users := m.ToUsers(b.Txn.Select(m.User{}, bannedUserSql, userName))
+	if len(users) == 0 {
+		return nil
+	}
+	return users[0]
+}
+
+// Returns page banned users of the pageable, filtered by the user name prefix
+// and whether the ban is in effect.
+func (b BannedUsers) findPageBanned(pageable *util.Pageable, userName string, active bool) *util.Page {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if len(userName) > 0 {
+		conditions = append(conditions, fmt.Sprintf("x.%s LIKE ?", m.F_USER_NAME))
+		args = append(args, userName+"%")
+	}
+	if active {
+		conditions = append(conditions, fmt.Sprintf("x.%s = 1", m.F_IS_ACTIVE))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	total, err := b.Txn.SelectInt(query.CountSql(m.F_ID, m.BANNED_USER_TABLE)+where, args...)
+	if total == 0 || err != nil {
+		return util.NewPage(nil, pageable, total)
+	}
+	sql := query.NewSqlBuilder(bannedListSql+where).
+		PageOrderBy(pageable, util.DescendingSort([]string{m.F_BANNED_TIME})).
+		ToSqlString()
+	content, err := b.Txn.Select(m.BannedUser{}, sql, args...)
+	if err != nil {
+		panic(err)
+	}
+	return util.NewPage(content, pageable, total)
+}
+
+// Banned users pagination, the history of a user is searched by userName.
+func (b BannedUsers) BannedList(p int, userName string, active bool) revel.Result {
+	if p <= 0 {
+		p = 1
+	}
+	pageable, err := util.NewPageable(p, DEFAULT_PAGE_SIZE, util.DESC, []string{m.F_BANNED_TIME})
+	if err != nil { // never heppen
+		log.Fatalf("Error for %s", err.Error())
+		panic(err)
+	}
+	userName = strings.TrimSpace(userName)
+	pageBanned := b.findPageBanned(pageable, userName, active)
+	pageUrl := "/banned/list/%d"
+	if len(userName) > 0 || active {
+		params := url.Values{}
+		params.Set("userName", userName)
+		params.Set("active", fmt.Sprint(active))
+		pageUrl += "?" + strings.Replace(params.Encode(), "%", "%%", -1)
+	}
+	title := b.Message("banned.title.list")
+	return b.Render(title, pageBanned, pageUrl, userName, active)
+}
+
+// The ban history of the specified user, the newest first.
+func (b BannedUsers) BannedHistory(userId uint64) revel.Result {
+	user := m.ToUser(b.Txn.Get(m.User{}, userId))
+	if user == nil {
+		return b.NotFound(b.NotFoundMessage("用户"))
+	}
+	bannedHistory := m.BannedHistory(b.Txn, user.UserId)
+	title := b.Message("banned.title.history", user.UserName)
+	return b.Render(title, user, bannedHistory)
+}
+
+// To ban a user page.
+func (b BannedUsers) NewBan(userName string) revel.Result {
+	title := b.Message("banned.title.creation")
+	return b.Render(title, userName)
+}
+
+// Bans the user of the specified user name by the current admin, the ban is
+// permanent if days is not greater than zero.
+func (b BannedUsers) SaveBan(userName, cause string, days int) revel.Result {
+	userName, cause = strings.TrimSpace(userName), strings.TrimSpace(cause)
+	if len(userName) == 0 {
+		return b.RenderJson(util.FailureResult(b.Message("banned.v.userNameRequired")))
+	}
+	if result, passed := b.validateCause(cause); !passed {
+		return result
+	}
+	user := b.findUserByName(userName)
+	if user == nil {
+		return b.RenderJson(util.FailureResult(b.Message("banned.userNotFound", userName)))
+	}
+	admin := b.connected()
+	banned, err := m.BanUser(b.Txn, user, int(admin.Id), admin.AdminName, cause,
+		time.Duration(days)*24*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	return b.RenderJson(util.SuccessResult(b.Message("banned.s.banned", user.UserName)).
+		AddValue("banned", banned))
+}
+
+// Lifts the bans in effect of the specified user by the current admin.
+func (b BannedUsers) LiftBan(userId uint64, cause string) revel.Result {
+	cause = strings.TrimSpace(cause)
+	if result, passed := b.validateCause(cause); !passed {
+		return result
+	}
+	admin := b.connected()
+	count, err := m.UnbanUser(b.Txn, userId, int(admin.Id), admin.AdminName, cause)
+	if err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		return b.RenderJson(util.FailureResult(b.Message("banned.notBanned")))
+	}
+	return b.RenderJson(util.SuccessResult(b.Message("banned.s.lifted")))
+}
+
+// Returns (nil, true) if the cause is valid, otherwise (result, false).
+func (b BannedUsers) validateCause(cause string) (revel.Result, bool) {
+	if len(cause) == 0 {
+		return b.RenderJson(util.FailureResult(b.Message("banned.v.causeRequired"))), false
+	}
+	if len([]rune(cause)) > 500 {
+		return b.RenderJson(util.FailureResult(b.Message("banned.v.causeLength", 500))), false
+	}
+	return nil, true
+}
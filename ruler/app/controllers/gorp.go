@@ -23,6 +23,7 @@ import (
 	"github.com/robfig/revel"
 	"github.com/robfig/revel/modules/db/app"
 	"log"
+	m "smart-kids/models"
 	"smart-kids/ruler/app/models"
 )
 
@@ -35,6 +36,7 @@ func Init() {
 	Dbm = &gorp.DbMap{Db: db.Db, Dialect: gorp.MySQLDialect{"InnoDB", "UTF8"}}
 
 	initAdmin()
+	initUsers()
 
 	Dbm.TraceOn("[gorp]", revel.INFO)
 
@@ -79,6 +81,73 @@ func initAdmin() {
 	})
 }
 
+func initUsers() {
+	// Register User, the key is assigned as the inner users have reserved ids
+	t := Dbm.AddTableWithName(m.User{}, m.USER_TABLE).SetKeys(false, "UserId")
+	setColumnSizes(t, map[string]int{
+		"Email":          50,
+		"UserName":       50,
+		"HashPassword":   100,
+		"PasswordSalt":   100,
+		"AvatarUri":      200,
+		"SmallAvatarUri": 200,
+		"ThumbAvatarUri": 200,
+		"SpareEmail":     50,
+	})
+	t.ColMap("UserName").SetUnique(true)
+	t.ColMap("Email").SetUnique(true)
+
+	// Register UserDigital
+	t = Dbm.AddTableWithName(m.UserDigital{}, m.USER_DIGITAL_TABLE).SetKeys(false, "UserId")
+	setColumnSizes(t, map[string]int{"UserName": 50})
+
+	// Register UserInfo
+	t = Dbm.AddTableWithName(m.UserInfo{}, m.USER_INFO_TABLE).SetKeys(false, "UserId")
+	setColumnSizes(t, map[string]int{
+		"UserName":       50,
+		"Nickname":       50,
+		"DateOfBirthStr": 10,
+		"LunarBirthStr":  11,
+		"OtherState":     100,
+	})
+
+	// Register InnerAccount
+	t = Dbm.AddTableWithName(m.InnerAccount{}, m.INNER_ACCOUNT_TABLE).SetKeys(false, "UserId")
+	setColumnSizes(t, map[string]int{
+		"UserName":           50,
+		"Title":              50,
+		"OperatorName":       50,
+		"LastModifiedByName": 50,
+	})
+
+	// Register BannedUser
+	t = Dbm.AddTableWithName(m.BannedUser{}, m.BANNED_USER_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{
+		"UserName":           50,
+		"OperatorName":       50,
+		"Cause":              2000,
+		"LiftedCause":        2000,
+		"LastModifiedByName": 50,
+	})
+
+	// Register UserIdentity
+	t = Dbm.AddTableWithName(m.UserIdentity{}, m.USER_IDENTITY_TABLE).SetKeys(false, "UserId")
+	setColumnSizes(t, map[string]int{
+		"RealName":     200,
+		"Idcard":       200,
+		"IdcardHash":   64,
+		"IdImgDomain":  100,
+		"IdImgPath":    200,
+		"RejectCause":  500,
+		"ReviewerName": 50,
+	})
+
+	// Register GradeRule
+	t = Dbm.AddTableWithName(m.GradeRule{}, m.GRADE_RULE_TABLE).SetKeys(true, "Id")
+	setColumnSizes(t, map[string]int{"Name": 50})
+	t.ColMap("Grade").SetUnique(true)
+}
+
 type GorpController struct {
 	*revel.Controller
 	Txn *gorp.Transaction
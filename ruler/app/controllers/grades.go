@@ -0,0 +1,101 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"strings"
+)
+
+type GradeRules struct {
+	Application
+}
+
+func (g GradeRules) loadGradeRule(id uint) *m.GradeRule {
+	return m.ToGradeRule(g.Txn.Get(m.GradeRule{}, id))
+}
+
+// Grade rules list, sorted by grade.
+func (g GradeRules) GradeRuleList() revel.Result {
+	gradeRules := m.LoadGradeRules(g.Txn)
+	title := g.Message("gradeRule.title.list")
+	return g.Render(title, gradeRules)
+}
+
+// Grade rule edit page
+func (g GradeRules) GradeRuleEdit(id uint) revel.Result {
+	title := g.Message("gradeRule.title.creation")
+	if id <= 0 {
+		return g.Render(title)
+	}
+	rule := g.loadGradeRule(id)
+	if rule == nil {
+		return g.Render(title)
+	}
+	title = g.Message("gradeRule.title.edit", rule.Name)
+	return g.Render(title, rule)
+}
+
+// Insert or Update GradeRule, a higher grade must require a higher total score
+// than all lower grades.
+func (g GradeRules) SaveGradeRule(rule m.GradeRule) revel.Result {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Grade <= 0 || len(rule.Name) == 0 {
+		return g.RenderJson(util.FailureResult(g.Message("gradeRule.v.required")))
+	}
+	rules, saved := m.LoadGradeRules(g.Txn), &rule
+	if rule.Id > 0 {
+		if saved = g.loadGradeRule(rule.Id); saved == nil {
+			return g.RenderJson(util.FailureResult(g.NotFoundMessage("等级规则")))
+		}
+		saved.Grade, saved.Name, saved.MinTotalScore = rule.Grade, rule.Name, rule.MinTotalScore
+		for i, r := range rules {
+			if r.Id == saved.Id {
+				rules[i] = saved
+			}
+		}
+	} else {
+		rules = append(rules, saved)
+	}
+	if err := rules.Validate(); err != nil {
+		return g.RenderJson(util.FailureResult(g.Message("gradeRule.v.order")))
+	}
+	var err error
+	if saved.Id > 0 {
+		_, err = g.Txn.Update(saved)
+	} else {
+		err = g.Txn.Insert(saved)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return g.RenderJson(util.SuccessResult(g.Message("gradeRule.s.saved", saved.Name)))
+}
+
+func (g GradeRules) DeleteGradeRule(id uint) revel.Result {
+	rule := g.loadGradeRule(id)
+	if rule == nil {
+		return g.RenderJson(util.FailureResult(g.NotFoundMessage("等级规则")))
+	}
+	if _, err := g.Txn.Delete(rule); err != nil {
+		panic(err)
+	}
+	return g.RenderJson(util.SuccessResult(g.OperOkMessage()))
+}
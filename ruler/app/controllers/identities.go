@@ -0,0 +1,115 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"github.com/robfig/revel"
+	"log"
+	m "smart-kids/models"
+	"smart-kids/query"
+	"smart-kids/util"
+	"strings"
+)
+
+var (
+	pendingIdentitySql = query.SimpleQuerySql(m.UserIdentityFields, m.USER_IDENTITY_TABLE, "x") +
+		fmt.Sprintf(" WHERE x.%s = %d", m.F_VERIFY_STATUS, m.IDENTITY_PENDING)
+	countPendingIdentitySql = query.CountSql(m.F_USER_ID, m.USER_IDENTITY_TABLE) +
+		fmt.Sprintf(" WHERE x.%s = %d", m.F_VERIFY_STATUS, m.IDENTITY_PENDING)
+	countApprovedIdcardSql = fmt.Sprintf("SELECT count(%s) FROM %s WHERE %s = ? AND %s = ? AND %s <> ?",
+		m.F_USER_ID, m.USER_IDENTITY_TABLE, m.F_IDCARD_HASH, m.F_VERIFY_STATUS, m.F_USER_ID)
+)
+
+type IdentityReviews struct {
+	Application
+}
+
+func (i IdentityReviews) findIdentity(userId uint64) *m.UserIdentity {
+	return m.ToUserIdentity(i.Txn.Get(m.UserIdentity{}, userId))
+}
+
+// Returns page pending identities of the pageable, the earliest submitted first.
+func (i IdentityReviews) findPagePending(pageable *util.Pageable) *util.Page {
+	total, err := i.Txn.SelectInt(countPendingIdentitySql)
+	if total == 0 || err != nil {
+		return util.NewPage(nil, pageable, total)
+	}
+	sql := query.NewSqlBuilder(pendingIdentitySql).
+		PageOrderBy(pageable, util.AscendingSort([]string{m.F_SUBMIT_TIME})).
+		ToSqlString()
+	content, err := i.Txn.Select(m.UserIdentity{}, sql)
+	if err != nil {
+		panic(err)
+	}
+	return util.NewPage(content, pageable, total)
+}
+
+// The review queue of pending identities.
+func (i IdentityReviews) ReviewList(p int) revel.Result {
+	if p <= 0 {
+		p = 1
+	}
+	pageable, err := util.NewPageable(p, DEFAULT_PAGE_SIZE, util.ASC, []string{m.F_SUBMIT_TIME})
+	if err != nil { // never heppen
+		log.Fatalf("Error for %s", err.Error())
+		panic(err)
+	}
+	pageIdentity := i.findPagePending(pageable)
+	title := i.Message("identity.title.reviewList")
+	return i.Render(title, pageIdentity)
+}
+
+// Approves the pending identity of the specified user, unless the idcard
+// is already approved for another user.
+func (i IdentityReviews) Approve(userId uint64) revel.Result {
+	identity := i.findIdentity(userId)
+	if identity == nil || !identity.IsPending() {
+		return i.RenderJson(util.FailureResult(i.Message("identity.notPending")))
+	}
+	// the same idcard may be pending for several users, only one is approved
+	count, err := i.Txn.SelectInt(countApprovedIdcardSql, identity.IdcardHash, m.IDENTITY_APPROVED, userId)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		return i.RenderJson(util.FailureResult(i.Message("identity.idcardUsed")))
+	}
+	admin := i.connected()
+	if _, err = i.Txn.Update(identity.Approve(int(admin.Id), admin.AdminName)); err != nil {
+		panic(err)
+	}
+	return i.RenderJson(util.SuccessResult(i.Message("identity.s.approved", identity.MaskedRealName())))
+}
+
+// Rejects the pending identity of the specified user with the cause.
+func (i IdentityReviews) Reject(userId uint64, cause string) revel.Result {
+	cause = strings.TrimSpace(cause)
+	if len(cause) == 0 {
+		return i.RenderJson(util.FailureResult(i.Message("identity.v.causeRequired")))
+	}
+	identity := i.findIdentity(userId)
+	if identity == nil || !identity.IsPending() {
+		return i.RenderJson(util.FailureResult(i.Message("identity.notPending")))
+	}
+	admin := i.connected()
+	if _, err := i.Txn.Update(identity.Reject(int(admin.Id), admin.AdminName, cause)); err != nil {
+		panic(err)
+	}
+	return i.RenderJson(util.SuccessResult(i.Message("identity.s.rejected", identity.MaskedRealName())))
+}
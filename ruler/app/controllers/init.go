@@ -17,14 +17,20 @@
 package controllers
 
 import (
+	"encoding/base64"
 	"github.com/robfig/revel"
+	"log"
 	"reflect"
+	"smart-kids/crypt"
+	"smart-kids/passwd"
 	"smart-kids/util"
 	"strings"
 )
 
 func init() {
 	revel.OnAppStart(Init)
+	revel.OnAppStart(initPasswordHasher)
+	revel.OnAppStart(initCrypt)
 	revel.InterceptMethod((*GorpController).Begin, revel.BEFORE)
 	revel.InterceptMethod(Application.checkLogin, revel.BEFORE)
 	revel.InterceptMethod(Application.AddMenus, revel.BEFORE)
@@ -38,6 +44,34 @@ func init() {
 	revel.TemplateFuncs["replaceAll"] = replaceAll
 }
 
+// Sets the current password hasher of the `passwd.hasher` config,
+// hashes created by other hashers are upgraded on login.
+func initPasswordHasher() {
+	hasher := revel.Config.StringDefault("passwd.hasher", passwd.BCRYPT)
+	if err := passwd.SetDefault(hasher); err != nil {
+		log.Fatalf("Unsupported passwd.hasher: %s", hasher)
+	}
+}
+
+// Sets the default keyring of the `crypt.*` configs which encrypts the
+// personal data, the app can not start without valid keys.
+func initCrypt() {
+	keys, err := crypt.ParseKeys(revel.Config.StringDefault("crypt.keys", ""))
+	if err != nil {
+		log.Fatalf("Invalid crypt.keys: %s", err.Error())
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(revel.Config.StringDefault("crypt.index_key", ""))
+	if err != nil {
+		log.Fatalf("Invalid crypt.index_key: %s", err.Error())
+	}
+	current := revel.Config.IntDefault("crypt.current", 1)
+	keyring, err := crypt.NewKeyring(uint32(current), keys, indexKey)
+	if err != nil {
+		log.Fatalf("Invalid crypt configs: %s", err.Error())
+	}
+	crypt.SetDefault(keyring)
+}
+
 func replaceAll(src, old, newVal interface{}) string {
 	var newStr string
 	s := reflect.ValueOf(src).String()
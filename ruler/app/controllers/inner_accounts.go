@@ -0,0 +1,168 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"fmt"
+	"github.com/robfig/revel"
+	"log"
+	m "smart-kids/models"
+	"smart-kids/query"
+	"smart-kids/util"
+	"strings"
+)
+
+var (
+	innerAccountListSql = query.SimpleQuerySql(m.InnerAccountFields, m.INNER_ACCOUNT_TABLE, "x")
+	existsUserNameSql   = query.ExistsQueryString(m.USER_TABLE, m.F_USER_ID, []string{m.F_USER_NAME})
+)
+
+type InnerAccounts struct {
+	Application
+}
+
+// Returns the InnerAccount of the specified user id, or nil if not exists.
+func (i InnerAccounts) findInnerAccount(userId uint64) *m.InnerAccount {
+	return m.ToInnerAccount(i.Txn.Get(m.InnerAccount{}, userId))
+}
+
+// Returns page inner accounts of the pageable, filtered by the kind code if
+// it is greater than zero.
+func (i InnerAccounts) findPageAccounts(pageable *util.Pageable, kindCode uint16) *util.Page {
+	where, args := "", []interface{}{}
+	if kindCode > 0 {
+		where = fmt.Sprintf(" WHERE x.%s = ?", m.F_KIND_CODE)
+		args = append(args, kindCode)
+	}
+	total, err := i.Txn.SelectInt(query.CountSql(m.F_USER_ID, m.INNER_ACCOUNT_TABLE)+where, args...)
+	if total == 0 || err != nil {
+		return util.NewPage(nil, pageable, total)
+	}
+	sql := query.NewSqlBuilder(innerAccountListSql+where).
+		PageOrderBy(pageable, util.AscendingSort([]string{m.F_USER_ID})).
+		ToSqlString()
+	content, err := i.Txn.Select(m.InnerAccount{}, sql, args...)
+	if err != nil {
+		panic(err)
+	}
+	return util.NewPage(content, pageable, total)
+}
+
+// Inner accounts pagination, filtered by the kind.
+func (i InnerAccounts) AccountList(p int, kind uint16) revel.Result {
+	if p <= 0 {
+		p = 1
+	}
+	pageable, err := util.NewPageable(p, DEFAULT_PAGE_SIZE, util.ASC, []string{m.F_USER_ID})
+	if err != nil { // never heppen
+		log.Fatalf("Error for %s", err.Error())
+		panic(err)
+	}
+	pageAccounts := i.findPageAccounts(pageable, kind)
+	pageUrl := "/inner/account_list/%d"
+	if kind > 0 {
+		pageUrl += fmt.Sprintf("?kind=%d", kind)
+	}
+	title := i.Message("inner.title.list")
+	kinds := m.InnerKinds
+	return i.Render(title, pageAccounts, pageUrl, kind, kinds)
+}
+
+// To create an inner account page.
+func (i InnerAccounts) NewAccount() revel.Result {
+	title := i.Message("inner.title.creation")
+	kinds := m.InnerKinds
+	return i.Render(title, kinds)
+}
+
+// Creates an activated inner account with the next reserved user id by the
+// current admin.
+func (i InnerAccounts) SaveAccount(user m.User, kindCode uint16, title string) revel.Result {
+	user.UserName = strings.TrimSpace(user.UserName)
+	user.Email = strings.ToLower(strings.TrimSpace(user.Email))
+	title = strings.TrimSpace(title)
+	user.Validate(i.Validation)
+	if i.Validation.HasErrors() {
+		result := util.FailureResult(i.Message("inner.v.accountInvalid"))
+		for k, v := range i.Validation.ErrorMap() {
+			if v != nil {
+				result.AddValue(k, v.Message)
+			}
+		}
+		return i.RenderJson(result)
+	}
+	kind := m.InnerKindOf(kindCode)
+	if kind == nil {
+		return i.RenderJson(util.FailureResult(i.Message("inner.v.kindRequired")))
+	}
+	if len(title) == 0 || len([]rune(title)) > 50 {
+		return i.RenderJson(util.FailureResult(i.Message("inner.v.titleLength", 50)))
+	}
+	count, err := i.Txn.SelectInt(existsUserNameSql, user.UserName)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		return i.RenderJson(util.FailureResult(i.Message("inner.nameExists", user.UserName)))
+	}
+	// the email and the spare email of the users share the same namespace
+	inUse, err := m.EmailInUse(i.Txn, user.Email, 0)
+	if err != nil {
+		panic(err)
+	}
+	if inUse {
+		return i.RenderJson(util.FailureResult(i.Message("inner.emailExists", user.Email)))
+	}
+	admin := i.connected()
+	account, err := m.CreateInnerAccount(i.Txn, &user, kind, title, int(admin.Id), admin.AdminName)
+	if err == m.InnerUserIdsExhaustedError {
+		return i.RenderJson(util.FailureResult(i.Message("inner.idsExhausted", m.MAX_INNER_USER_ID)))
+	}
+	if err != nil {
+		panic(err)
+	}
+	return i.RenderJson(util.SuccessResult(i.Message("inner.s.created", user.UserName, user.UserId)).
+		AddValue("account", account))
+}
+
+// Disables the inner account, the user can not login until it is enabled.
+func (i InnerAccounts) DisableAccount(userId uint64) revel.Result {
+	return i.setActive(userId, false)
+}
+
+// Enables the disabled inner account.
+func (i InnerAccounts) EnableAccount(userId uint64) revel.Result {
+	return i.setActive(userId, true)
+}
+
+func (i InnerAccounts) setActive(userId uint64, active bool) revel.Result {
+	account := i.findInnerAccount(userId)
+	if account == nil {
+		return i.RenderJson(util.FailureResult(i.Message("inner.notFound")))
+	}
+	if account.IsActive == active {
+		return i.RenderJson(util.SuccessResult(i.Message("inner.s.unchanged")))
+	}
+	admin := i.connected()
+	if err := m.SetInnerAccountActive(i.Txn, account, active, int(admin.Id), admin.AdminName); err != nil {
+		panic(err)
+	}
+	if active {
+		return i.RenderJson(util.SuccessResult(i.Message("inner.s.enabled", account.UserName)))
+	}
+	return i.RenderJson(util.SuccessResult(i.Message("inner.s.disabled", account.UserName)))
+}
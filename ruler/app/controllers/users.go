@@ -0,0 +1,58 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package controllers
+
+import (
+	"github.com/robfig/revel"
+	m "smart-kids/models"
+	"smart-kids/util"
+	"strings"
+)
+
+type SiteUsers struct {
+	Application
+}
+
+// Site users pagination, filtered by the search and sorted by the comma
+// separated properties of m.UserSortColumns, the newest users first if the
+// sort is empty or not allowed.
+func (s SiteUsers) UserList(p int, search m.UserSearch, sort, dir string) revel.Result {
+	if p <= 0 {
+		p = 1
+	}
+	var userSort *util.Sort
+	if len(strings.TrimSpace(sort)) > 0 {
+		if len(dir) == 0 {
+			dir = util.DESC
+		}
+		userSort, _ = m.NewUserSort(dir, strings.Split(strings.Replace(sort, " ", "", -1), ","))
+	}
+	pageable, err := util.NewPageable0(p, DEFAULT_PAGE_SIZE, userSort)
+	if err != nil { // never heppen
+		panic(err)
+	}
+	pageUsers := m.SearchUsers(s.Txn, &search, pageable)
+	params := s.Request.URL.Query()
+	params.Del("p")
+	pageUrl := "/users/list/%d"
+	if len(params) > 0 {
+		pageUrl += "?" + strings.Replace(params.Encode(), "%", "%%", -1)
+	}
+	title := s.Message("users.title.list")
+	genders := []*m.Gender{m.Male, m.Female, m.SecretGender}
+	return s.Render(title, pageUsers, pageUrl, search, sort, dir, genders)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/coopernurse/gorp"
 	"github.com/go-sql-driver/mysql"
 	"reflect"
+	"smart-kids/passwd"
 	"time"
 )
 
@@ -63,14 +64,12 @@ type Admin struct {
 	Roles        []*Role `db:"-" json:",omitempty"`
 }
 
+// Updates the profile of this admin by the form bound admin, the password
+// is only changed by SetPassword.
 func (a *Admin) UpdateBy(admin *Admin) *Admin {
 	if len(admin.AdminName) > 0 {
 		a.AdminName = admin.AdminName
 	}
-	if len(admin.HashPassword) > 0 && len(admin.Salt) > 0 {
-		a.HashPassword = admin.HashPassword
-		a.Salt = admin.Salt
-	}
 	if admin.EmpName.Valid {
 		a.EmpName = admin.EmpName
 	}
@@ -84,6 +83,34 @@ func (a *Admin) UpdateBy(admin *Admin) *Admin {
 	return a
 }
 
+// Hashes the password with the current password hasher, the salt is a part
+// of the hash.
+func (a *Admin) SetPassword(password string) error {
+	hashPwd, err := passwd.Hash(password)
+	if err != nil {
+		return err
+	}
+	a.HashPassword, a.Salt = hashPwd, ""
+	return nil
+}
+
+// Returns true if the given password matches this admin's HashPassword.
+// A legacy sha1 hash is salted with Salt, or AdminName if Salt is empty.
+// A legacy or outdated hash is re-hashed with the current hasher at the
+// same time, upgraded is true if the admin should be updated.
+func (a *Admin) MatchPassword(password string) (matched bool, upgraded bool) {
+	salt := a.Salt
+	if len(salt) == 0 && passwd.IsLegacy(a.HashPassword) {
+		salt = a.AdminName
+	}
+	matched, rehashed := passwd.Check(password, a.HashPassword, salt)
+	if matched && len(rehashed) > 0 {
+		a.HashPassword, a.Salt = rehashed, ""
+		return true, true
+	}
+	return matched, false
+}
+
 func (a *Admin) CreatedBy(id uint32, name string) *Admin {
 	if id > 0 && len(name) > 0 {
 		a.CreatedById = id
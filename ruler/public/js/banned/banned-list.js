@@ -0,0 +1,43 @@
+/* 
+ * Copyright (C) 2012-2013 king4go authors All rights reserved.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements. See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ *           http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * @author king4go fcrpg3000 (fcrpg2005 At gmail.com)
+ * @since 1.0
+ */
+(function($) {
+
+  function liftBan(btn, userId) {
+    var cause = window.prompt('请输入解除封禁的原因：', '');
+    if (cause === null) {
+      return false;
+    }
+    $.post('/banned/a/lift_ban/' + userId, {cause: cause}, function(data) {
+      if (data.code === 1) {
+        $(btn).remove();
+        window.location.reload();
+      }
+      alert(data.message);
+    }, 'json');
+    return false;
+  }
+
+ window.liftBan = liftBan;
+
+})(jQuery);
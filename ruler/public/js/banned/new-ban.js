@@ -0,0 +1,44 @@
+/* 
+ * Copyright (C) 2012-2013 king4go authors All rights reserved.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements. See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ *           http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * @author king4go fcrpg3000 (fcrpg2005 At gmail.com)
+ * @since 1.0
+ */
+(function($) {
+
+  $(function() {
+    var jForm = $('#form_ban'), jAlert = $('#message_tip'), jSave = $('#btn_save_ban');
+    jForm.submit(function() {
+      jAlert.hide();
+      jSave.button('saving');
+      $.post(jForm.attr('action'), jForm.serialize(), function(data) {
+        jSave.button('reset');
+        if (data.code === 1) {
+          alert(data.message);
+          window.location.href = '/banned/list';
+        } else {
+          jAlert.text(data.message).show();
+        }
+      }, 'json');
+      return false;
+    });
+  });
+
+})(jQuery);
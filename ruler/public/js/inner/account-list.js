@@ -0,0 +1,44 @@
+/* 
+ * Copyright (C) 2012-2013 king4go authors All rights reserved.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements. See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ *           http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/**
+ * @author king4go fcrpg3000 (fcrpg2005 At gmail.com)
+ * @since 1.0
+ */
+(function($) {
+
+  function setActive(btn, userId, active) {
+    var url = (active === true ? '/inner/a/enable_account/' :
+        '/inner/a/disable_account/') + userId;
+    if (active !== true && !window.confirm('停用后该账号将无法登录，确定要停用吗？')) {
+      return false;
+    }
+    $.post(url, function(data) {
+      if (data.code === 1) {
+        $(btn).remove();
+        window.location.reload();
+      }
+      alert(data.message);
+    }, 'json');
+    return false;
+  }
+
+ window.setActive = setActive;
+
+})(jQuery);
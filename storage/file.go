@@ -0,0 +1,70 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStorage writes the data into Dir, the uri is the key prefixed by
+// UrlPrefix. Serve Dir as static files in development or by the web server.
+type FileStorage struct {
+	Dir       string
+	UrlPrefix string
+}
+
+func NewFileStorage(dir, urlPrefix string) *FileStorage {
+	return &FileStorage{Dir: dir, UrlPrefix: strings.TrimRight(urlPrefix, "/")}
+}
+
+func (f *FileStorage) Put(key, contentType string, data []byte) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	path := filepath.Join(f.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return f.UrlPrefix + "/" + key, nil
+}
+
+func (f *FileStorage) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(f.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileStorage) KeyOf(uri string) (string, bool) {
+	prefix := f.UrlPrefix + "/"
+	if !strings.HasPrefix(uri, prefix) {
+		return "", false
+	}
+	key := uri[len(prefix):]
+	return key, checkKey(key) == nil
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage saves the uploaded files and returns their public uris.
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	InvalidKeyError = errors.New("storage: invalid key.")
+)
+
+// Implement this interface to store the uploaded files.
+type Storage interface {
+
+	// Saves the data under the key, returns the public uri of it.
+	Put(key, contentType string, data []byte) (string, error)
+
+	// Deletes the data of the key, it's not an error if the key not exists.
+	Delete(key string) error
+
+	// Returns the key of the uri returned by Put, ok is false if the uri is
+	// not stored by this storage.
+	KeyOf(uri string) (key string, ok bool)
+}
+
+// Validates the key is a relative slash separated path without "..".
+func checkKey(key string) error {
+	if len(key) == 0 || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return InvalidKeyError
+	}
+	for _, part := range strings.Split(key, "/") {
+		if len(part) == 0 || part == "." || part == ".." {
+			return InvalidKeyError
+		}
+	}
+	return nil
+}
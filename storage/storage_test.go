@@ -0,0 +1,63 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewFileStorage(dir, "http://127.0.0.1/uploads/")
+	uri, err := s.Put("avatars/1/a.png", "image/png", []byte("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != "http://127.0.0.1/uploads/avatars/1/a.png" {
+		t.Errorf("The uri is error, actual: %s", uri)
+	}
+	if key, ok := s.KeyOf(uri); !ok || key != "avatars/1/a.png" {
+		t.Errorf("The key of the uri is error, actual: %s, %v", key, ok)
+	}
+	for _, other := range []string{"http://127.0.0.1/other/a.png", "http://127.0.0.1/uploads/../a.png"} {
+		if _, ok := s.KeyOf(other); ok {
+			t.Errorf("The uri %q is not stored by the storage", other)
+		}
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "avatars", "1", "a.png")); string(data) != "png" {
+		t.Errorf("The stored data is error, actual: %s", data)
+	}
+	if err = s.Delete("avatars/1/a.png"); err != nil {
+		t.Error(err)
+	}
+	if err = s.Delete("avatars/1/a.png"); err != nil {
+		t.Errorf("Deleting the missing key must not be error, actual: %v", err)
+	}
+	for _, key := range []string{"", "/etc/passwd", "../a.png", "a//b.png", "a\\b.png"} {
+		if _, err = s.Put(key, "image/png", nil); err != InvalidKeyError {
+			t.Errorf("The key %q must be invalid, actual: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package tokens issues the secret codes and tokens which are sent to the
+// users and clients, e.g. the OAuth2 auth codes, access and refresh tokens.
+//
+// A code or token is a URL-safe string of random bytes from crypto/rand,
+// only its sha256 hex hash is stored, so a leaked table can not be replayed.
+package tokens
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"smart-kids/util"
+)
+
+const (
+	CODE_SIZE  = uint(24) // random bytes of the short-lived codes
+	TOKEN_SIZE = uint(32) // random bytes of the tokens
+)
+
+// Returns a new random code or token of size bytes and its hash.
+func New(size uint) (raw, hash string) {
+	raw = util.RandomToken(size)
+	return raw, Hash(raw)
+}
+
+// Returns a new code and its hash.
+func NewCode() (string, string) {
+	return New(CODE_SIZE)
+}
+
+// Returns a new token and its hash.
+func NewToken() (string, string) {
+	return New(TOKEN_SIZE)
+}
+
+// Returns the sha256 hex string of the raw code or token.
+func Hash(raw string) string {
+	h := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(h[:])
+}
+
+// Returns true if the raw code or token is not empty and matches the hash,
+// the hashes are compared in constant time.
+func Match(raw, hash string) bool {
+	if len(raw) == 0 || len(hash) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(Hash(raw)), []byte(hash)) == 1
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tokens
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		raw, hash := NewCode()
+		if seen[raw] {
+			t.Fatalf("The code %s is repeated", raw)
+		}
+		seen[raw] = true
+		b, err := base64.URLEncoding.DecodeString(raw + strings.Repeat("=", (4-len(raw)%4)%4))
+		if err != nil || len(b) != int(CODE_SIZE) {
+			t.Errorf("The code must be URL-safe %d bytes, actual: %s", CODE_SIZE, raw)
+		}
+		if len(hash) != 64 || hash != Hash(raw) || hash == raw {
+			t.Errorf("The hash of the code %s is error, actual: %s", raw, hash)
+		}
+	}
+	if raw, _ := NewToken(); len(raw) != 43 {
+		t.Errorf("The token must encode %d bytes, actual: %s", TOKEN_SIZE, raw)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	raw, hash := NewToken()
+	if !Match(raw, hash) {
+		t.Error("The token should match its hash")
+	}
+	if Match(raw+"x", hash) || Match(hash, hash) || Match("", Hash("")) || Match(raw, "") {
+		t.Error("Only the raw token matches the hash")
+	}
+}
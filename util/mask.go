@@ -0,0 +1,66 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"strings"
+)
+
+const (
+	MASK_CHAR = "*"
+)
+
+// Masks the characters of s except the first head and the last tail ones,
+// all characters are masked if s is not longer than head + tail.
+// Examples:
+//    Mask("11010519491231002X", 4, 4) => "1101**********002X"
+//    Mask("张三", 1, 0) => "张*"
+func Mask(s string, head, tail int) string {
+	runes := []rune(s)
+	size := len(runes)
+	if size == 0 {
+		return ""
+	}
+	if size <= head+tail {
+		return strings.Repeat(MASK_CHAR, size)
+	}
+	return string(runes[:head]) + strings.Repeat(MASK_CHAR, size-head-tail) +
+		string(runes[size-tail:])
+}
+
+// Masks the idcard number except the first and last 4 characters.
+func MaskIdcard(idcard string) string {
+	return Mask(idcard, 4, 4)
+}
+
+// Masks the name except the first character (the family name mostly).
+func MaskName(name string) string {
+	if len([]rune(name)) == 1 {
+		return MASK_CHAR
+	}
+	return Mask(name, 1, 0)
+}
+
+// Masks the local part of the email except the first character.
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return Mask(email, 1, 0)
+	}
+	return Mask(email[:at], 1, 0) + email[at:]
+}
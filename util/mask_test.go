@@ -0,0 +1,38 @@
+// Copyright (C) 2012-2013 king4go authors All rights reserved.
+//
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//           http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	cases := map[string]string{
+		MaskIdcard("11010519491231002X"): "1101**********002X",
+		MaskIdcard("1234567"):            "*******",
+		MaskName("张三丰"):                  "张**",
+		MaskName("张"):                    "*",
+		MaskEmail("kid@smartkids.com"):   "k**@smartkids.com",
+		Mask("", 1, 1):                   "",
+	}
+	for actual, expected := range cases {
+		if actual != expected {
+			t.Errorf("Mask result should be %s, actual: %s", expected, actual)
+		}
+	}
+}
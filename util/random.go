@@ -17,12 +17,15 @@
 package util
 
 import (
+	crand "crypto/rand"
+	"encoding/base64"
 	_ "errors"
 	_ "fmt"
+	"io"
 	"math"
 	"math/rand"
 	_ "strconv"
-	_ "strings"
+	"strings"
 	"time"
 )
 
@@ -169,3 +172,13 @@ func RandomAlphanumeric(count uint) string {
 func RandomNumeric(count uint) string {
 	return RandomAlphaOrNumeric(count, false, true)
 }
+
+// Creates a URL-safe random token which encodes size bytes read from
+// crypto/rand, use it for activation links, auth codes and other secrets.
+func RandomToken(size uint) string {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(crand.Reader, b); err != nil {
+		panic(err)
+	}
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
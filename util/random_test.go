@@ -118,3 +118,11 @@ func TestRandomString(t *testing.T) {
 	assertTrue(t, str1 != str2, "PASS", "str1 != str2")
 	fmt.Printf("RandomSpec0(21):\nstr1=%s\nstr2=%s\n", str1, str2)
 }
+
+func TestRandomToken(t *testing.T) {
+	token1, token2 := RandomToken(32), RandomToken(32)
+	assertEquals(t, len(token1), 43, "RandomToken(32) length")
+	assertTrue(t, token1 != token2, "PASS", "RandomToken returns the same token twice")
+	assertTrue(t, regexp.MustCompile("^[\\w\\-]+$").MatchString(token1), "PASS",
+		"RandomToken must be URL-safe: "+token1)
+}